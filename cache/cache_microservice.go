@@ -30,6 +30,9 @@ var mu sync.Mutex
 
 const nkeys = 100_000
 
+// version is sent with the registration message; set it with -ldflags "-X main.version=..."
+var version = "dev"
+
 func main() {
 	/* Initialize the logger */
 	brokers := []string{"localhost:9092"} //change brokerIP here
@@ -42,6 +45,12 @@ func main() {
 
 	nodeID = int(uuid.New().ID())
 
+	/* Register with the address the cache listens on */
+	address := ""
+	if len(os.Args) > 1 {
+		address = os.Args[1]
+	}
+	logger.SetNodeMetadata(version, address)
 	logger.SendRegistrationMsg(nodeID, "cache")
 
 	go logger.StartHeartbeatRoutine(nodeID)
@@ -1,150 +1,253 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
-	"github.com/elastic/go-elasticsearch/v8"
+	"example.com/store"
+
+	"github.com/gdamore/tcell/v2"
 	"github.com/urfave/cli/v2"
 )
 
-// ElasticClient wraps the Elasticsearch client for querying logs
-type ElasticClient struct {
-	Client *elasticsearch.Client
-	Index  string
-}
-
-// NewElasticClient initializes an Elasticsearch client
-func NewElasticClient(index string) (*ElasticClient, error) {
-	cfg := elasticsearch.Config{
-		Addresses: []string{"http://localhost:9200"},
-		Username:  "elastic",
-		Password:  "XXiqV27E1FcB*Qeh0jox",
+// openStore opens the log store selected by the global flags and the profile, reading
+// the given Elasticsearch index or the matching subdirectory of the local data directory.
+// The local store is opened read-only, since the server may be writing to it, and an
+// Elasticsearch index without the store's mapping is refused, as filters would miss logs.
+func openStore(c *cli.Context, index string) (store.LogStore, error) {
+	dir := c.String("data-dir")
+	if index != c.String("index") {
+		dir = filepath.Join(dir, index)
 	}
-	client, err := elasticsearch.NewClient(cfg)
+	_, profile := loadedConfig(c)
+	addresses, username, password := elasticConnection(profile)
+	logs, err := store.Open(store.Config{
+		Backend: c.String("backend"),
+		Elasticsearch: store.ElasticConfig{
+			Addresses: addresses,
+			Username:  username,
+			Password:  password,
+			Index:     index,
+		},
+		Local: store.LocalConfig{Dir: dir, ReadOnly: true},
+	})
 	if err != nil {
 		return nil, err
 	}
-	return &ElasticClient{Client: client, Index: index}, nil
+	// Other errors, such as Elasticsearch being down, are reported by the first query
+	var mappingErr *store.MappingError
+	if es, ok := logs.(*store.ElasticStore); ok && errors.As(es.CheckMapping(), &mappingErr) {
+		logs.Close()
+		return nil, fmt.Errorf("%w: run \"reindex\" in the server directory, then use the new index with --index", mappingErr)
+	}
+	return logs, nil
 }
 
-// SearchLogs performs a search on the Elasticsearch index with a query
-func (ec *ElasticClient) SearchLogs(query string) (map[string]interface{}, error) {
-	searchRes, err := ec.Client.Search(
-		ec.Client.Search.WithIndex(ec.Index),
-		ec.Client.Search.WithBody(strings.NewReader(query)),
-		ec.Client.Search.WithPretty(),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to search: %w", err)
+// levelFilter returns the filter of a --level value: info, alerts or all
+func levelFilter(level string) store.Filter {
+	if level == "info" {
+		return store.Term{Field: "log_level", Values: []string{"INFO"}}
+	} else if level == "alerts" {
+		return store.Or{
+			store.Term{Field: "log_level", Values: []string{"WARN", "ERROR"}},
+			store.Term{Field: "message_type", Values: []string{"REGISTRATION", "HEARTBEAT"}},
+		}
 	}
-	defer searchRes.Body.Close()
+	return nil
+}
+
+// maxQuerySize is the most logs one query returns; Elasticsearch refuses more by default
+const maxQuerySize = 10000
 
-	var searchResult map[string]interface{}
-	if err := json.NewDecoder(searchRes.Body).Decode(&searchResult); err != nil {
-		return nil, fmt.Errorf("failed to decode search result: %w", err)
+// ShowLogs fetches the logs matching a query and prints them; beyond maxQuerySize, or
+// without a limit (Size 0), it scans the store instead
+func ShowLogs(logs store.LogStore, q store.Query, printer *Printer) error {
+	if q.Size == 0 || q.Size > maxQuerySize {
+		err := logs.Scan(q, func(hit store.Hit) error {
+			return printer.Print(hit.Doc)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to retrieve logs: %w", err)
+		}
+		return printer.Close()
+	}
+
+	result, err := logs.Query(q)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve logs: %w", err)
 	}
 
-	return searchResult, nil
+	for _, hit := range result.Hits {
+		if err := printer.Print(hit.Doc); err != nil {
+			return err
+		}
+	}
+	return printer.Close()
 }
 
-// ShowLogs fetches logs based on the specified level and prints them
-func (ec *ElasticClient) ShowLogs(level string, limit int) {
-	var query string
-	fmt.Println(level, limit)
+// ShowAlerts prints the alert history, newest first, optionally filtered by state and rule
+func ShowAlerts(history store.LogStore, state string, rule string, limit int) {
+	var filters store.And
+	if state != "" {
+		filters = append(filters, store.Term{Field: "state", Values: []string{state}})
+	}
+	if rule != "" {
+		filters = append(filters, store.Term{Field: "rule", Values: []string{rule}})
+	}
 
-	if level == "all" {
-		query = fmt.Sprintf(`{
-			"query": {
-				"match_all": {}
-			},
-			"size": %d
-		}`, limit)
-	} else if level == "info" {
-		query = fmt.Sprintf(`{
-			"query": {
-				"match": {
-					"log_level": "%s"
-				}
-			},
-			"size": %d
-		}`, level, limit)
-	} else {
-		query = fmt.Sprintf(`{
-			"query": {
-				"bool": {
-					"should": [
-					{
-						"terms": {
-						"log_level.keyword": ["WARN", "ERROR"]
-						}
-					},
-					{
-						"terms": {
-						"message_type.keyword": ["REGISTRATION", "HEARTBEAT"]
-						}
-					}
-					],
-					"minimum_should_match": 1
-				}
-				}
-				,
-			"size": %d
-		}`, limit)
+	result, err := history.Query(store.Query{Filter: filters, Sort: store.SortNewest, Size: limit})
+	if err != nil {
+		log.Fatalf("Failed to retrieve alerts: %v", err)
+	}
+
+	for _, hit := range result.Hits {
+		alert := hit.Doc
+		fmt.Printf("%s - %s [%s] %s - %s\n", alert["@timestamp"], alert["state"], alert["severity"], alert["rule"], alert["message"])
 	}
+}
 
-	logs, err := ec.SearchLogs(query)
+// queryFlags select the logs of the logs and export commands
+func queryFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "query",
+			Aliases: []string{"q"},
+			Usage:   "Only show logs matching a query, such as 'level:ERROR AND service:cache* AND NOT message:timeout AND @timestamp>now-1h'",
+		},
+		&cli.BoolFlag{
+			Name:  "explain",
+			Usage: "Print the filter and Elasticsearch request the flags make instead of running them",
+		},
+		&cli.StringFlag{
+			Name:     "level",
+			Usage:    "Specify the log level to filter by: 'info', 'alerts', or 'all'",
+			Value:    "all",
+			Required: false,
+		},
+		&cli.StringFlag{
+			Name:  "since",
+			Usage: "Only show logs from this time on: a duration back from now (15m, 2h, 7d) or a time (2006-01-02T15:04:05Z, 2006-01-02 15:04)",
+		},
+		&cli.StringFlag{
+			Name:  "until",
+			Usage: "Only show logs before this time, in the same formats as --since",
+		},
+		&cli.StringSliceFlag{
+			Name:  "service",
+			Usage: "Only show logs of these services; prefix with ! to exclude one (--service '!router')",
+		},
+		&cli.StringSliceFlag{
+			Name:  "node",
+			Usage: "Only show logs of these node IDs; prefix with ! to exclude one",
+		},
+		&cli.StringSliceFlag{
+			Name:  "error-code",
+			Usage: "Only show error logs with these error codes; prefix with ! to exclude one",
+		},
+		&cli.StringSliceFlag{
+			Name:  "message-type",
+			Usage: "Only show these message types (LOG, HEARTBEAT, REGISTRATION); prefix with ! to exclude one",
+		},
+		&cli.StringFlag{
+			Name:  "grep",
+			Usage: "Only show logs whose message holds all these words; prefix with ! to exclude them",
+		},
+		&cli.StringFlag{
+			Name:  "regex",
+			Usage: "Only show logs whose message matches this regular expression; prefix with ! to exclude them",
+		},
+	}
+}
+
+// queryFromFlags validates the query flags and turns them into a query
+func queryFromFlags(c *cli.Context) (store.Query, error) {
+	level := c.String("level")
+	if level != "info" && level != "alerts" && level != "all" {
+		return store.Query{}, fmt.Errorf("invalid log level %q, use 'info', 'alerts', or 'all'", level)
+	}
+	from, to, err := ParseTimeRange(c.String("since"), c.String("until"), time.Now())
+	if err != nil {
+		return store.Query{}, err
+	}
+	filter, err := FilterFlags{
+		Services:     c.StringSlice("service"),
+		Nodes:        c.StringSlice("node"),
+		ErrorCodes:   c.StringSlice("error-code"),
+		MessageTypes: c.StringSlice("message-type"),
+		Grep:         c.String("grep"),
+		Regex:        c.String("regex"),
+	}.Filter()
 	if err != nil {
-		log.Fatalf("Failed to retrieve logs: %v", err)
+		return store.Query{}, err
 	}
 
-	hits := logs["hits"].(map[string]interface{})["hits"].([]interface{})
-	for _, hit := range hits {
-		logData := hit.(map[string]interface{})["_source"].(map[string]interface{})
-		messageType, ok := logData["message_type"].(string)
-		if !ok {
-			log.Printf("Invalid or missing 'message_type': %+v", logData)
-			return
+	var query store.Filter
+	if c.String("query") != "" {
+		if query, err = ParseQuery(c.String("query"), time.Now()); err != nil {
+			return store.Query{}, err
 		}
-		if messageType != "" {
-			messageType := logData["message_type"].(string)
-
-			switch messageType {
-			case "LOG":
-				{
-					level, levelOk := logData["log_level"].(string)
-					message, messageOk := logData["message"].(string)
-
-					if !levelOk {
-						level = "unknown"
-					}
-					if !messageOk {
-						message = "No message"
-					}
-
-					fmt.Printf("%s - Message: %s\n", level, message)
-				}
-			case "HEARTBEAT":
-				{
-					fmt.Printf("%s - id: %d - status: %s\n", messageType, int(logData["node_id"].(float64)), logData["status"].(string))
-				}
-			case "REGISTRATION":
-				{
-					fmt.Printf("%s - id: %d - service name: %s\n", messageType, int(logData["node_id"].(float64)), logData["service_name"].(string))
-				}
-			}
+	}
+
+	var filters store.And
+	for _, f := range []store.Filter{levelFilter(level), filter, query} {
+		if and, ok := f.(store.And); ok {
+			filters = append(filters, and...)
+		} else if f != nil {
+			filters = append(filters, f)
 		}
 	}
+	if len(filters) == 1 {
+		return store.Query{Filter: filters[0], From: from, To: to}, nil
+	}
+	return store.Query{Filter: filters, From: from, To: to}, nil
 }
 
+// outputFlag and fieldsFlag choose how the logs and tail commands print logs
+var (
+	outputFlag = &cli.StringFlag{
+		Name:    "output",
+		Aliases: []string{"o"},
+		Value:   OutputText,
+		Usage:   "Output format: " + strings.Join(outputFormats, ", "),
+	}
+	fieldsFlag = &cli.StringSliceFlag{
+		Name:  "fields",
+		Usage: "Fields to show, in order, for every format but text (e.g. @timestamp,service_name,error_details.error_code)",
+	}
+)
+
 func main() {
 	app := &cli.App{
 		Name:  "Log CLI",
-		Usage: "Search and display logs from Elasticsearch",
+		Usage: "Search and display logs from Elasticsearch or a local log store",
 		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "config",
+				Value: DefaultConfigPath(),
+				Usage: "Config file holding the profiles and saved queries",
+			},
+			&cli.StringFlag{
+				Name:    "profile",
+				EnvVars: []string{"LOGCTL_PROFILE"},
+				Usage:   "Profile of the config file to use, instead of its default profile",
+			},
+			&cli.StringFlag{
+				Name:  "backend",
+				Value: "elasticsearch",
+				Usage: "Log store to query: 'elasticsearch' or 'local'",
+			},
+			&cli.StringFlag{
+				Name:  "data-dir",
+				Value: "data",
+				Usage: "Directory of the local log store",
+			},
 			&cli.StringFlag{
 				Name:     "index",
 				Value:    "kafka-logs",
@@ -152,51 +255,537 @@ func main() {
 				Required: false,
 			},
 		},
+		Before: applyConfig,
 		Commands: []*cli.Command{
 			{
 				Name:  "logs",
 				Usage: "Show logs based on the specified log level (info, alerts, or all)",
-				Flags: []cli.Flag{
-					&cli.StringFlag{
-						Name:     "level",
-						Usage:    "Specify the log level to filter by: 'info', 'alerts', or 'all'",
-						Required: false,
-					},
+				Flags: append(queryFlags(),
 					&cli.IntFlag{
 						Name:     "limit",
-						Usage:    "Specify the number of logs to retrieve",
+						Usage:    "Specify the number of logs to retrieve, 0 for all of them",
 						Value:    10, // Default limit
 						Required: false,
 					},
+					&cli.BoolFlag{
+						Name:  "asc",
+						Usage: "Show the oldest logs of the range first, instead of the newest",
+					},
+					&cli.BoolFlag{
+						Name:    "follow",
+						Aliases: []string{"f"},
+						Usage:   "Keep printing new logs as they arrive, oldest first, until interrupted",
+					},
+					&cli.DurationFlag{
+						Name:  "interval",
+						Value: time.Second,
+						Usage: "How often --follow checks for new logs",
+					},
+					outputFlag,
+					fieldsFlag,
+				),
+				Action: func(c *cli.Context) error {
+					limit := c.Int("limit")
+					if limit < 0 {
+						return fmt.Errorf("limit must be a positive number, or 0 for all logs")
+					}
+					q, err := queryFromFlags(c)
+					if err != nil {
+						return err
+					}
+					follow := c.Bool("follow")
+					if follow && (!q.To.IsZero() || c.Bool("asc")) {
+						return fmt.Errorf("--follow can't be combined with --until or --asc")
+					}
+					if follow && limit > maxQuerySize {
+						return fmt.Errorf("--follow starts with at most %d logs", maxQuerySize)
+					}
+					if follow && c.Duration("interval") <= 0 {
+						return fmt.Errorf("interval must be positive")
+					}
+					printer, err := NewPrinter(os.Stdout, outputFormat(c), c.StringSlice("fields"))
+					if err != nil {
+						return err
+					}
+
+					q.Sort, q.Size = store.SortNewest, limit
+					if c.Bool("asc") {
+						q.Sort = store.SortOldest
+					}
+					if c.Bool("explain") {
+						return ExplainQuery(os.Stdout, q)
+					}
+
+					logs, err := openStore(c, c.String("index"))
+					if err != nil {
+						return fmt.Errorf("failed to open log store: %w", err)
+					}
+					defer logs.Close()
+					if follow {
+						// Ctrl-C stops following and exits normally
+						ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+						defer stop()
+						return FollowLogs(ctx, logs, q, c.Duration("interval"), printer)
+					}
+					return ShowLogs(logs, q, printer)
 				},
+			},
+			{
+				Name:  "export",
+				Usage: "Export the matching logs, oldest first, as NDJSON",
+				Flags: append(queryFlags(),
+					&cli.StringFlag{
+						Name:     "file",
+						Usage:    "File to write, or - for stdout",
+						Required: true,
+					},
+					&cli.BoolFlag{
+						Name:  "gzip",
+						Usage: "Compress the export with gzip, the default for a file ending in .gz",
+					},
+					&cli.IntFlag{
+						Name:  "limit",
+						Usage: "Export at most this many logs, 0 for all of them",
+					},
+				),
 				Action: func(c *cli.Context) error {
-					index := c.String("index")
-					level := c.String("level")
 					limit := c.Int("limit")
+					if limit < 0 {
+						return fmt.Errorf("limit must be a positive number, or 0 for all logs")
+					}
+					q, err := queryFromFlags(c)
+					if err != nil {
+						return err
+					}
+					q.Sort, q.Size = store.SortOldest, limit
+					if c.Bool("explain") {
+						return ExplainQuery(os.Stdout, q)
+					}
 
-					if limit <= 0 {
-						return fmt.Errorf("limit must be a positive number")
+					logs, err := openStore(c, c.String("index"))
+					if err != nil {
+						return fmt.Errorf("failed to open log store: %w", err)
+					}
+					defer logs.Close()
+					// Ctrl-C stops the export, leaving the partial file behind
+					ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+					defer stop()
+					path := c.String("file")
+					return ExportLogs(ctx, logs, q, path, exportCompressed(path, c.Bool("gzip")))
+				},
+			},
+			{
+				Name:  "stats",
+				Usage: "Count the matching logs by level, service, node and error code, over time, with latency percentiles and the top error messages",
+				Flags: append(queryFlags(),
+					&cli.StringSliceFlag{
+						Name:  "by",
+						Usage: "Count by these groups: level, service, node, error-code, message-type",
+						Value: cli.NewStringSlice(defaultStatsGroups...),
+					},
+					&cli.DurationFlag{
+						Name:  "interval",
+						Usage: "Interval of the histogram bars, picked from the time range when not set",
+					},
+					&cli.BoolFlag{
+						Name:  "bars",
+						Usage: "Draw the histogram as one bar per interval instead of a sparkline",
+					},
+					&cli.IntFlag{
+						Name:  "top",
+						Usage: "Number of groups and error messages to show",
+						Value: 10,
+					},
+					&cli.StringFlag{
+						Name:  "latency-field",
+						Usage: "Field holding response times, for the percentiles",
+						Value: "response_time_ms",
+					},
+					&cli.StringSliceFlag{
+						Name:  "percentiles",
+						Usage: "Percentiles of the latency field to compute",
+						Value: cli.NewStringSlice("50", "90", "95", "99"),
+					},
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Usage:   "Output format: text or json",
+						Value:   OutputText,
+					},
+				),
+				Action: func(c *cli.Context) error {
+					format := outputFormat(c)
+					if format != OutputText && format != OutputJSON && !c.IsSet("output") {
+						// Profiles may pick a format only the log commands know
+						format = OutputText
+					}
+					if format != OutputText && format != OutputJSON {
+						return fmt.Errorf("invalid output %q, use text or json", format)
+					}
+					opts := StatsOptions{
+						Interval:     c.Duration("interval"),
+						Top:          c.Int("top"),
+						LatencyField: c.String("latency-field"),
+					}
+					for _, name := range c.StringSlice("by") {
+						name = strings.TrimSpace(name)
+						if _, ok := statsGroups[name]; !ok {
+							return fmt.Errorf("invalid group %q, use level, service, node, error-code or message-type", name)
+						}
+						opts.Groups = append(opts.Groups, name)
+					}
+					if opts.Top <= 0 {
+						return fmt.Errorf("top must be a positive number")
+					}
+					if c.IsSet("interval") && opts.Interval < time.Second {
+						return fmt.Errorf("interval must be at least 1s")
+					}
+					percents, err := ParsePercents(c.StringSlice("percentiles"))
+					if err != nil {
+						return err
+					}
+					opts.Percents = percents
+					q, err := queryFromFlags(c)
+					if err != nil {
+						return err
+					}
+					// The histogram needs both ends of the range
+					if q.To.IsZero() {
+						q.To = time.Now()
+					}
+					if q.From.IsZero() {
+						q.From = q.To.Add(-24 * time.Hour)
+					}
+					if !q.From.Before(q.To) {
+						return fmt.Errorf("--since must be before --until")
+					}
+					if c.Bool("explain") {
+						return ExplainQuery(os.Stdout, q)
 					}
 
-					if level == "" {
-						fmt.Println("Please specify a valid log level using --level.")
-						return nil
+					logs, err := openStore(c, c.String("index"))
+					if err != nil {
+						return fmt.Errorf("failed to open log store: %w", err)
+					}
+					defer logs.Close()
+					stats, err := CollectStats(logs, q, opts)
+					if err != nil {
+						return err
+					}
+					return PrintStats(os.Stdout, stats, format, c.Bool("bars"))
+				},
+			},
+			{
+				Name:  "tui",
+				Usage: "Browse logs in a full-screen terminal interface",
+				Flags: append(queryFlags(),
+					&cli.StringFlag{
+						Name:  "server",
+						Value: "http://localhost:8090",
+						Usage: "Address of the log server API, for the node panel; empty to hide it",
+					},
+					&cli.StringFlag{
+						Name:    "token",
+						EnvVars: []string{"LOGCTL_API_TOKEN"},
+						Usage:   "API token of the server, when it requires one",
+					},
+					&cli.DurationFlag{
+						Name:  "interval",
+						Usage: "How often the live tail polls the log store",
+						Value: time.Second,
+					},
+				),
+				Action: func(c *cli.Context) error {
+					if c.Duration("interval") <= 0 {
+						return fmt.Errorf("interval must be positive")
+					}
+					q, err := queryFromFlags(c)
+					if err != nil {
+						return err
+					}
+					if c.Bool("explain") {
+						return ExplainQuery(os.Stdout, q)
 					}
 
-					ec, err := NewElasticClient(index)
+					logs, err := openStore(c, c.String("index"))
+					if err != nil {
+						return fmt.Errorf("failed to open log store: %w", err)
+					}
+					defer logs.Close()
+					screen, err := tcell.NewScreen()
 					if err != nil {
-						return fmt.Errorf("failed to initialize Elasticsearch client: %w", err)
+						return fmt.Errorf("failed to open the terminal: %w", err)
+					}
+					return RunTUI(screen, logs, q, c.String("server"), c.String("token"), c.Duration("interval"))
+				},
+			},
+			{
+				Name:      "context",
+				Usage:     "Show the logs just before and after a log on its node, or on every node",
+				ArgsUsage: "<log_id or event_id>",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:    "lines",
+						Aliases: []string{"n"},
+						Usage:   "Number of logs to show before and after the log",
+						Value:   10,
+					},
+					&cli.IntFlag{
+						Name:  "before",
+						Usage: "Number of logs to show before the log, instead of --lines",
+					},
+					&cli.IntFlag{
+						Name:  "after",
+						Usage: "Number of logs to show after the log, instead of --lines",
+					},
+					&cli.DurationFlag{
+						Name:  "window",
+						Usage: "Show the logs of every node within this time of the log, not only its node's",
+					},
+					outputFlag,
+					fieldsFlag,
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return fmt.Errorf("usage: context [flags] <log_id or event_id>, with the flags before the ID")
+					}
+					before, after := c.Int("lines"), c.Int("lines")
+					if c.IsSet("before") {
+						before = c.Int("before")
+					}
+					if c.IsSet("after") {
+						after = c.Int("after")
+					}
+					if before < 0 || after < 0 {
+						return fmt.Errorf("the number of logs can't be negative")
+					}
+					window := c.Duration("window")
+					if window < 0 {
+						return fmt.Errorf("window must be positive")
+					}
+					printer, err := NewPrinter(os.Stdout, outputFormat(c), c.StringSlice("fields"))
+					if err != nil {
+						return err
 					}
 
-					if level == "info" || level == "alerts" || level == "all" {
-						ec.ShowLogs(level, limit)
-						return nil
+					logs, err := openStore(c, c.String("index"))
+					if err != nil {
+						return fmt.Errorf("failed to open log store: %w", err)
+					}
+					defer logs.Close()
+					anchor, err := FindLog(logs, c.Args().First())
+					if err != nil {
+						return err
+					}
+					var filter store.Filter
+					if window == 0 {
+						node := anchor.Doc.String("node_id")
+						if node == "" {
+							return fmt.Errorf("log %s has no node_id, use --window to see the logs of every node around it", anchor.ID)
+						}
+						filter = store.Term{Field: "node_id", Values: []string{node}}
+					}
+					hits, index, err := ContextLogs(logs, anchor, filter, window, before, after)
+					if err != nil {
+						return err
+					}
+					return ShowContext(hits, index, printer)
+				},
+			},
+			{
+				Name:      "last-words",
+				Usage:     "Show the last logs of a node before it timed out",
+				ArgsUsage: "<node_id>",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:    "lines",
+						Aliases: []string{"n"},
+						Usage:   "Number of logs to show",
+						Value:   20,
+					},
+					&cli.StringFlag{
+						Name:  "until",
+						Usage: "Look at the last timeout before this time instead of the latest one, in the same formats as --since",
+					},
+					outputFlag,
+					fieldsFlag,
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return fmt.Errorf("usage: last-words [flags] <node_id>, with the flags before the node ID")
+					}
+					node, err := checkNodeID(c.Args().First())
+					if err != nil {
+						return err
+					}
+					if c.Int("lines") <= 0 {
+						return fmt.Errorf("lines must be a positive number")
+					}
+					until, err := ParseTime(c.String("until"), time.Now())
+					if err != nil {
+						return fmt.Errorf("--until: %w", err)
+					}
+					printer, err := NewPrinter(os.Stdout, outputFormat(c), c.StringSlice("fields"))
+					if err != nil {
+						return err
 					}
 
-					fmt.Println("Invalid log level. Use 'info', 'alerts', or 'all'.")
+					logs, err := openStore(c, c.String("index"))
+					if err != nil {
+						return fmt.Errorf("failed to open log store: %w", err)
+					}
+					defer logs.Close()
+					timeout, found, err := LastTimeout(logs, node, until)
+					if err != nil {
+						return err
+					}
+					var anchor *store.Hit
+					if found {
+						anchor = &timeout
+					}
+					words, err := LastWords(logs, node, anchor, until, c.Int("lines"))
+					if err != nil {
+						return err
+					}
+					hits, index := words, -1
+					if anchor != nil {
+						hits, index = append(words, timeout), len(words)
+					}
+					if err := ShowContext(hits, index, printer); err != nil {
+						return err
+					}
+					reportLastWords(node, anchor, words)
+					return nil
+				},
+			},
+			{
+				Name:  "tail",
+				Usage: "Stream new logs from the server as they arrive, like tail -f",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "server",
+						Value: "http://localhost:8090",
+						Usage: "Address of the log server API",
+					},
+					&cli.StringFlag{
+						Name:    "token",
+						EnvVars: []string{"LOGCTL_API_TOKEN"},
+						Usage:   "API token of the server, when it requires one",
+					},
+					&cli.StringSliceFlag{
+						Name:  "level",
+						Usage: "Only show these levels or message types (INFO, WARN, ERROR, HEARTBEAT, REGISTRATION)",
+					},
+					&cli.StringSliceFlag{
+						Name:  "service",
+						Usage: "Only show logs of these services",
+					},
+					&cli.StringSliceFlag{
+						Name:  "node",
+						Usage: "Only show logs of these node IDs",
+					},
+					&cli.StringFlag{
+						Name:  "grep",
+						Usage: "Only show logs whose message contains this text",
+					},
+					&cli.StringFlag{
+						Name:  "trace-id",
+						Usage: "Only show logs with this trace ID",
+					},
+					outputFlag,
+					fieldsFlag,
+				},
+				Action: func(c *cli.Context) error {
+					printer, err := NewPrinter(os.Stdout, outputFormat(c), c.StringSlice("fields"))
+					if err != nil {
+						return err
+					}
+					return TailLogs(c.String("server"), c.String("token"), printer, TailFilter{
+						Levels:   c.StringSlice("level"),
+						Services: c.StringSlice("service"),
+						Nodes:    c.StringSlice("node"),
+						Contains: c.String("grep"),
+						TraceID:  c.String("trace-id"),
+					})
+				},
+			},
+			{
+				Name:  "alerts",
+				Usage: "Show the alert history recorded by the server",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "alerts-index",
+						Value: "kafka-alerts",
+						Usage: "Index (or local subdirectory) holding the alert history",
+					},
+					&cli.StringFlag{
+						Name:  "state",
+						Usage: "Only show alerts in this state: 'pending', 'firing' or 'resolved'",
+					},
+					&cli.StringFlag{
+						Name:  "rule",
+						Usage: "Only show alerts of this rule",
+					},
+					&cli.IntFlag{
+						Name:  "limit",
+						Usage: "Specify the number of alerts to retrieve",
+						Value: 10,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					state := c.String("state")
+					limit := c.Int("limit")
+
+					if limit <= 0 {
+						return fmt.Errorf("limit must be a positive number")
+					}
+					if state != "" && state != "pending" && state != "firing" && state != "resolved" {
+						return fmt.Errorf("invalid state %q, use 'pending', 'firing' or 'resolved'", state)
+					}
+
+					history, err := openStore(c, c.String("alerts-index"))
+					if err != nil {
+						return fmt.Errorf("failed to open alert history: %w", err)
+					}
+					defer history.Close()
+					ShowAlerts(history, state, c.String("rule"), limit)
 					return nil
 				},
 			},
+			{
+				Name:      "run",
+				Usage:     "Run a query saved in the config file, with name=value parameters",
+				ArgsUsage: "<name> [name=value ...]",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "list",
+						Usage: "List the saved queries and their parameters",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					cfg, _ := loadedConfig(c)
+					if c.Bool("list") {
+						ListQueries(os.Stdout, cfg)
+						return nil
+					}
+					if c.NArg() == 0 {
+						return fmt.Errorf("usage: run <name> [name=value ...], or run --list")
+					}
+					query, ok := cfg.Queries[c.Args().First()]
+					if !ok {
+						return fmt.Errorf("no saved query named %q", c.Args().First())
+					}
+					values, err := parseParams(c.Args().Tail())
+					if err != nil {
+						return err
+					}
+					args, err := query.Expand(values)
+					if err != nil {
+						return fmt.Errorf("failed to run %s: %w", c.Args().First(), err)
+					}
+					return c.App.Run(runArgs(c, query, args))
+				},
+			},
 		},
 	}
 
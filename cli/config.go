@@ -0,0 +1,312 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultEndpoints is where Elasticsearch is reached when the profile doesn't say
+var defaultEndpoints = []string{"http://localhost:9200"}
+
+// Profile is a named set of defaults for the global flags and the Elasticsearch connection
+type Profile struct {
+	Backend     string   `yaml:"backend"`
+	Endpoints   []string `yaml:"endpoints"`
+	Username    string   `yaml:"username"`
+	Password    string   `yaml:"password"`
+	PasswordEnv string   `yaml:"password_env"`
+	Index       string   `yaml:"index"`
+	DataDir     string   `yaml:"data_dir"`
+	Output      string   `yaml:"output"`
+}
+
+// SavedQuery is a command line saved under a name, with ${param} placeholders
+type SavedQuery struct {
+	Description string            `yaml:"description"`
+	Profile     string            `yaml:"profile"`
+	Args        []string          `yaml:"args"`
+	Params      map[string]string `yaml:"params"`
+}
+
+// Config is the content of the CLI config file
+type Config struct {
+	DefaultProfile string                `yaml:"default_profile"`
+	Profiles       map[string]Profile    `yaml:"profiles"`
+	Queries        map[string]SavedQuery `yaml:"queries"`
+}
+
+// DefaultConfigPath returns $XDG_CONFIG_HOME/logctl/config.yaml, or ~/.config/logctl/config.yaml
+func DefaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "logctl", "config.yaml")
+}
+
+// LoadConfig reads the config file at path; a missing file is an empty config
+// unless required is set
+func LoadConfig(path string, required bool) (*Config, error) {
+	cfg := &Config{}
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	for name, query := range cfg.Queries {
+		if len(query.Args) == 0 {
+			return nil, fmt.Errorf("saved query %q has no args", name)
+		}
+		if savedCommand(query.Args) == "run" {
+			return nil, fmt.Errorf("saved query %q can't run another saved query", name)
+		}
+	}
+	return cfg, nil
+}
+
+// savedCommand returns the command a saved query runs: its first argument that isn't a
+// global flag or the value of one
+func savedCommand(args []string) string {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			return arg
+		}
+		name := strings.TrimLeft(arg, "-")
+		if strings.Contains(name, "=") {
+			continue
+		}
+		for _, flag := range globalFlags {
+			if name == flag {
+				// The value of the flag
+				i++
+				break
+			}
+		}
+	}
+	return ""
+}
+
+// Profile returns the named profile, or the default one when name is empty
+func (cfg *Config) Profile(name string) (Profile, error) {
+	if name == "" {
+		name = cfg.DefaultProfile
+	}
+	if name == "" {
+		return Profile{}, nil
+	}
+	profile, ok := cfg.Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("unknown profile %q", name)
+	}
+	return profile, nil
+}
+
+// globalFlags are the global string flags a saved query run keeps
+var globalFlags = []string{"config", "profile", "backend", "data-dir", "index"}
+
+// applyConfig loads the config file and the selected profile before any command runs.
+// The profile fills in the global flags that weren't given on the command line.
+func applyConfig(c *cli.Context) error {
+	cfg, err := LoadConfig(c.String("config"), c.IsSet("config"))
+	if err != nil {
+		return err
+	}
+	profile, err := cfg.Profile(c.String("profile"))
+	if err != nil {
+		return err
+	}
+	var given []string
+	for _, flag := range globalFlags {
+		if c.IsSet(flag) {
+			given = append(given, "--"+flag, c.String(flag))
+		}
+	}
+	for flag, value := range map[string]string{
+		"backend":  profile.Backend,
+		"data-dir": profile.DataDir,
+		"index":    profile.Index,
+	} {
+		if value != "" && !c.IsSet(flag) {
+			if err := c.Set(flag, value); err != nil {
+				return fmt.Errorf("invalid %s in profile: %w", flag, err)
+			}
+		}
+	}
+	c.App.Metadata = map[string]interface{}{"config": cfg, "profile": profile, "flags": given}
+	return nil
+}
+
+// loadedConfig returns the config file and profile applyConfig loaded
+func loadedConfig(c *cli.Context) (*Config, Profile) {
+	cfg, _ := c.App.Metadata["config"].(*Config)
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	profile, _ := c.App.Metadata["profile"].(Profile)
+	return cfg, profile
+}
+
+// outputFormat returns the --output flag, or the profile's output format when the flag isn't given
+func outputFormat(c *cli.Context) string {
+	if _, profile := loadedConfig(c); profile.Output != "" && !c.IsSet("output") {
+		return profile.Output
+	}
+	return c.String("output")
+}
+
+// elasticConnection returns the Elasticsearch endpoints and credentials of the profile;
+// without credentials in the profile, the CLI connects without authentication
+func elasticConnection(profile Profile) ([]string, string, string) {
+	endpoints := defaultEndpoints
+	if len(profile.Endpoints) > 0 {
+		endpoints = profile.Endpoints
+	}
+	password := profile.Password
+	if profile.PasswordEnv != "" {
+		password = os.Getenv(profile.PasswordEnv)
+	}
+	return endpoints, profile.Username, password
+}
+
+// paramPattern matches a ${name} placeholder of a saved query
+var paramPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_-]*)\}`)
+
+// Expand returns the arguments of the saved query with its placeholders replaced by
+// the given values, or by the defaults of the query
+func (query SavedQuery) Expand(values map[string]string) ([]string, error) {
+	var missing []string
+	lookup := func(name string) string {
+		if value, ok := values[name]; ok {
+			return value
+		}
+		if value, ok := query.Params[name]; ok {
+			return value
+		}
+		missing = append(missing, name)
+		return ""
+	}
+
+	args := make([]string, len(query.Args))
+	for i, arg := range query.Args {
+		args[i] = paramPattern.ReplaceAllStringFunc(arg, func(match string) string {
+			return lookup(paramPattern.FindStringSubmatch(match)[1])
+		})
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing parameters: %s", strings.Join(unique(missing), ", "))
+	}
+	for name := range values {
+		if _, ok := query.Params[name]; !ok && !query.uses(name) {
+			return nil, fmt.Errorf("unknown parameter %q", name)
+		}
+	}
+	return args, nil
+}
+
+// Parameters lists the placeholders of the saved query, in order of appearance
+func (query SavedQuery) Parameters() []string {
+	var names []string
+	for _, arg := range query.Args {
+		for _, match := range paramPattern.FindAllStringSubmatch(arg, -1) {
+			names = append(names, match[1])
+		}
+	}
+	return unique(names)
+}
+
+// uses reports whether the saved query has a placeholder for the parameter
+func (query SavedQuery) uses(name string) bool {
+	for _, param := range query.Parameters() {
+		if param == name {
+			return true
+		}
+	}
+	return false
+}
+
+// unique returns the values without duplicates, keeping the first occurrence
+func unique(values []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// parseParams turns name=value arguments into parameter values
+func parseParams(args []string) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid parameter %q, use name=value", arg)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// ListQueries prints the saved queries with their description and parameters
+func ListQueries(out io.Writer, cfg *Config) {
+	if len(cfg.Queries) == 0 {
+		fmt.Fprintln(out, "No saved queries")
+		return
+	}
+	names := make([]string, 0, len(cfg.Queries))
+	for name := range cfg.Queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		query := cfg.Queries[name]
+		fmt.Fprintf(out, "%s: %s\n", name, strings.Join(query.Args, " "))
+		if query.Description != "" {
+			fmt.Fprintf(out, "    %s\n", query.Description)
+		}
+		for _, param := range query.Parameters() {
+			if value, ok := query.Params[param]; ok {
+				fmt.Fprintf(out, "    %s (default %q)\n", param, value)
+			} else {
+				fmt.Fprintf(out, "    %s (required)\n", param)
+			}
+		}
+	}
+}
+
+// runArgs returns the command line that runs a saved query, keeping the global flags
+// given on the command line; the profile of the query applies unless --profile was given
+func runArgs(c *cli.Context, query SavedQuery, args []string) []string {
+	given, _ := c.App.Metadata["flags"].([]string)
+	line := append([]string{c.App.Name}, given...)
+	if query.Profile != "" && !c.IsSet("profile") {
+		line = append(line, "--profile", query.Profile)
+	}
+	return append(line, args...)
+}
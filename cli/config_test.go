@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigRejectsRun(t *testing.T) {
+	tests := []struct {
+		name string
+		args string
+		ok   bool
+	}{
+		{"query", "[query, --level, ERROR]", true},
+		{"flag before command", "[--index, run, query]", true},
+		{"flag with equals", "[--index=x, query]", true},
+		{"run", "[run, other]", false},
+		{"run after flag", "[--index, x, run, other]", false},
+		{"run after flag with equals", "[--profile=prod, run, other]", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			data := "queries:\n  saved:\n    args: " + tt.args + "\n"
+			if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+			_, err := LoadConfig(path, true)
+			if tt.ok && err != nil {
+				t.Errorf("LoadConfig: %v", err)
+			}
+			if !tt.ok && (err == nil || !strings.Contains(err.Error(), "can't run another saved query")) {
+				t.Errorf("LoadConfig error = %v, want a saved query loop", err)
+			}
+		})
+	}
+}
+
+func TestElasticConnection(t *testing.T) {
+	t.Setenv("TEST_ES_PASSWORD", "secret")
+	tests := []struct {
+		name     string
+		profile  Profile
+		username string
+		password string
+	}{
+		{"no profile", Profile{}, "", ""},
+		{"password", Profile{Username: "reader", Password: "pw"}, "reader", "pw"},
+		{"password env", Profile{Username: "reader", Password: "pw", PasswordEnv: "TEST_ES_PASSWORD"}, "reader", "secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoints, username, password := elasticConnection(tt.profile)
+			if len(endpoints) != 1 || endpoints[0] != "http://localhost:9200" {
+				t.Errorf("endpoints = %v", endpoints)
+			}
+			if username != tt.username || password != tt.password {
+				t.Errorf("credentials = %q/%q, want %q/%q", username, password, tt.username, tt.password)
+			}
+		})
+	}
+}
+
+func TestSavedQueryExpand(t *testing.T) {
+	query := SavedQuery{
+		Args:   []string{"query", "--service", "${service}", "--since", "${since}"},
+		Params: map[string]string{"since": "1h"},
+	}
+	tests := []struct {
+		name   string
+		values map[string]string
+		want   string
+		err    string
+	}{
+		{"default", map[string]string{"service": "cache"}, "query --service cache --since 1h", ""},
+		{"override", map[string]string{"service": "cache", "since": "2d"}, "query --service cache --since 2d", ""},
+		{"missing", nil, "", "missing parameters: service"},
+		{"unknown", map[string]string{"service": "cache", "level": "ERROR"}, "", `unknown parameter "level"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := query.Expand(tt.values)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("error = %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expand: %v", err)
+			}
+			if got := strings.Join(args, " "); got != tt.want {
+				t.Errorf("args = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"example.com/store"
+)
+
+// timeoutMessage is the error message of the log the server writes when a node times out
+const timeoutMessage = "node timed out"
+
+// FindLog looks a log up by its log_id, or by its event_id
+func FindLog(logs store.LogStore, id string) (store.Hit, error) {
+	filter := store.Filter(store.Term{Field: store.FieldEventID, Values: []string{id}})
+	if _, err := strconv.ParseUint(id, 10, 64); err == nil {
+		filter = store.Term{Field: "log_id", Values: []string{id}}
+	}
+	result, err := logs.Query(store.Query{Filter: filter, Sort: store.SortNewest, Size: 1})
+	if err != nil {
+		return store.Hit{}, fmt.Errorf("failed to retrieve log: %w", err)
+	}
+	if len(result.Hits) == 0 {
+		return store.Hit{}, fmt.Errorf("no log with ID %s", id)
+	}
+	return result.Hits[0], nil
+}
+
+// ContextLogs returns up to before logs before the anchor and after logs after it that
+// match filter, with the anchor in between, oldest first, and the index of the anchor.
+// A window other than 0 only looks that far from the anchor.
+func ContextLogs(logs store.LogStore, anchor store.Hit, filter store.Filter, window time.Duration, before int, after int) ([]store.Hit, int, error) {
+	position := store.PositionOf(anchor)
+	older := store.Query{Filter: filter, Sort: store.SortNewest, After: &position, Size: before}
+	newer := store.Query{Filter: filter, Sort: store.SortOldest, After: &position, Size: after}
+	if window > 0 {
+		older.From = position.Time.Add(-window)
+		newer.To = position.Time.Add(window)
+	}
+
+	var hits []store.Hit
+	if before > 0 {
+		result, err := logs.Query(older)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to retrieve logs: %w", err)
+		}
+		hits = reversed(result.Hits)
+	}
+	index := len(hits)
+	hits = append(hits, anchor)
+	if after > 0 {
+		result, err := logs.Query(newer)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to retrieve logs: %w", err)
+		}
+		hits = append(hits, result.Hits...)
+	}
+	return hits, index, nil
+}
+
+// LastTimeout finds the latest log the server wrote about node timing out, before
+// until unless it's zero
+func LastTimeout(logs store.LogStore, node string, until time.Time) (store.Hit, bool, error) {
+	result, err := logs.Query(store.Query{
+		Filter: store.And{
+			store.Term{Field: "node_id", Values: []string{node}},
+			store.Term{Field: "service_name", Values: []string{"server"}},
+			store.Term{Field: "error_details.error_message", Values: []string{timeoutMessage}},
+		},
+		To:   until,
+		Sort: store.SortNewest,
+		Size: 1,
+	})
+	if err != nil {
+		return store.Hit{}, false, fmt.Errorf("failed to retrieve logs: %w", err)
+	}
+	if len(result.Hits) == 0 {
+		return store.Hit{}, false, nil
+	}
+	return result.Hits[0], true, nil
+}
+
+// LastWords returns the last logs a node sent itself, leaving out what the server
+// logged about it, before the anchor, or before until when there is no anchor
+func LastWords(logs store.LogStore, node string, anchor *store.Hit, until time.Time, count int) ([]store.Hit, error) {
+	q := store.Query{
+		Filter: store.And{
+			store.Term{Field: "node_id", Values: []string{node}},
+			store.Not{Filter: store.Term{Field: "service_name", Values: []string{"server"}}},
+		},
+		To:   until,
+		Sort: store.SortNewest,
+		Size: count,
+	}
+	if anchor != nil {
+		position := store.PositionOf(*anchor)
+		q.After, q.To = &position, time.Time{}
+	}
+	result, err := logs.Query(q)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve logs: %w", err)
+	}
+	return reversed(result.Hits), nil
+}
+
+// ShowContext prints logs with the one at anchor highlighted, when the format allows
+func ShowContext(hits []store.Hit, anchor int, printer *Printer) error {
+	for i, hit := range hits {
+		if err := printer.PrintMarked(hit.Doc, i == anchor); err != nil {
+			return err
+		}
+	}
+	return printer.Close()
+}
+
+// reportLastWords tells on stderr how long the node stayed silent before timing out
+func reportLastWords(node string, timeout *store.Hit, words []store.Hit) {
+	var last time.Time
+	if len(words) > 0 {
+		last = store.PositionOf(words[len(words)-1]).Time
+	}
+	switch {
+	case timeout == nil && len(words) == 0:
+		fmt.Fprintf(os.Stderr, "No timeout and no logs recorded for node %s\n", node)
+	case timeout == nil:
+		fmt.Fprintf(os.Stderr, "No timeout recorded for node %s, showing its last logs; the last one is from %s\n",
+			node, last.Local().Format(textTimeLayout))
+	case len(words) == 0:
+		fmt.Fprintf(os.Stderr, "Node %s timed out at %s without any log before\n",
+			node, store.PositionOf(*timeout).Time.Local().Format(textTimeLayout))
+	default:
+		at := store.PositionOf(*timeout).Time
+		fmt.Fprintf(os.Stderr, "Node %s timed out at %s, %s after its last log\n",
+			node, at.Local().Format(textTimeLayout), at.Sub(last).Round(time.Millisecond))
+	}
+}
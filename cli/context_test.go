@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/store"
+)
+
+func TestContextLogs(t *testing.T) {
+	start := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	var docs []store.Document
+	for i := 0; i < 10; i++ {
+		doc := testLog(fmt.Sprintf("c%02d", i), start.Add(time.Duration(i)*10*time.Second))
+		doc["node_id"] = float64(1 + i%2)
+		docs = append(docs, doc)
+	}
+	// At the same time as c05, after it by ID
+	tie := testLog("c05b", start.Add(50*time.Second))
+	tie["node_id"] = float64(2)
+	logs := openTestStore(t, append(docs, tie)...)
+
+	tests := []struct {
+		name   string
+		anchor string
+		filter store.Filter
+		window time.Duration
+		before int
+		after  int
+		want   string // the anchor in brackets
+	}{
+		{"around", "c05", nil, 0, 2, 2, "c03 c04 [c05] c05b c06"},
+		{"anchor only", "c05", nil, 0, 0, 0, "[c05]"},
+		{"only before", "c05", nil, 0, 3, 0, "c02 c03 c04 [c05]"},
+		{"only after", "c05b", nil, 0, 0, 2, "[c05b] c06 c07"},
+		{"tie before", "c05b", nil, 0, 1, 0, "c05 [c05b]"},
+		{"window", "c05", nil, 25 * time.Second, 5, 5, "c03 c04 [c05] c05b c06 c07"},
+		{"first log", "c01", nil, 0, 5, 1, "c00 [c01] c02"},
+		{"last log", "c09", nil, 0, 1, 5, "c08 [c09]"},
+		{"filtered", "c05", store.Term{Field: "node_id", Values: []string{"1"}}, 0, 2, 2, "c02 c04 [c05] c06 c08"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			anchor, err := FindLog(logs, tt.anchor)
+			if err != nil {
+				t.Fatalf("FindLog: %v", err)
+			}
+			hits, index, err := ContextLogs(logs, anchor, tt.filter, tt.window, tt.before, tt.after)
+			if err != nil {
+				t.Fatalf("ContextLogs: %v", err)
+			}
+
+			var got []string
+			for i, hit := range hits {
+				message := hit.Doc.String("message")
+				if i == index {
+					message = "[" + message + "]"
+				}
+				got = append(got, message)
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("context = %s, want %s", strings.Join(got, " "), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"example.com/store"
+)
+
+// exportProgressInterval is how often the export command reports its progress
+const exportProgressInterval = 2 * time.Second
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// ExportLogs writes every log matching q, oldest first, as NDJSON to path, or to stdout
+// for "-", gzipped when compress is set. A file is written under a .partial name and
+// renamed once complete, so an interrupted export can't pass for a whole one. Progress
+// goes to stderr.
+func ExportLogs(ctx context.Context, logs store.LogStore, q store.Query, path string, compress bool) error {
+	q.Sort = store.SortOldest
+
+	// The total is only needed for the progress, so an error just leaves it out
+	var total int
+	count := q
+	count.Size = 0
+	if result, err := logs.Query(count); err == nil {
+		total = result.Total
+		if q.Size > 0 && q.Size < total {
+			total = q.Size
+		}
+	}
+
+	var out io.Writer = os.Stdout
+	var file *os.File
+	partial := ""
+	if path != "-" {
+		partial = path + ".partial"
+		var err error
+		if file, err = os.Create(partial); err != nil {
+			return fmt.Errorf("failed to create export file: %w", err)
+		}
+		defer file.Close()
+		out = file
+	}
+	written := &countingWriter{w: out}
+	out = written
+	var zw *gzip.Writer
+	if compress {
+		zw = gzip.NewWriter(out)
+		out = zw
+	}
+
+	start := time.Now()
+	lastReport := start
+	exported := 0
+	report := func(now time.Time) {
+		rate := float64(exported) / now.Sub(start).Seconds()
+		progress := fmt.Sprintf("%d logs", exported)
+		if total > 0 {
+			progress = fmt.Sprintf("%d of %d logs (%.0f%%)", exported, total, 100*float64(exported)/float64(total))
+		}
+		fmt.Fprintf(os.Stderr, "Exported %s, %s, %.0f logs/s\n", progress, formatBytes(written.n), rate)
+	}
+
+	err := logs.Scan(q, func(hit store.Hit) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		data, err := json.Marshal(hit.Doc)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(out, "%s\n", data); err != nil {
+			return err
+		}
+		exported++
+		if now := time.Now(); now.Sub(lastReport) >= exportProgressInterval {
+			report(now)
+			lastReport = now
+		}
+		return nil
+	})
+	if err == nil && zw != nil {
+		err = zw.Close()
+	}
+	// A failed close can lose the end of the file, which then mustn't look complete
+	if err == nil && file != nil {
+		err = file.Close()
+	}
+	if err != nil {
+		if partial != "" {
+			fmt.Fprintf(os.Stderr, "Export incomplete after %d logs, partial output left in %s\n", exported, partial)
+		}
+		return fmt.Errorf("failed to export logs: %w", err)
+	}
+
+	if partial != "" {
+		if err := os.Rename(partial, path); err != nil {
+			return fmt.Errorf("failed to finish export file: %w", err)
+		}
+	}
+	report(time.Now())
+	fmt.Fprintf(os.Stderr, "Export complete in %s\n", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// exportCompressed tells whether an export should be gzipped: when asked to, or when
+// the file name ends in .gz
+func exportCompressed(path string, gzipFlag bool) bool {
+	return gzipFlag || strings.HasSuffix(path, ".gz")
+}
+
+// formatBytes formats a size in bytes with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
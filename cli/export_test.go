@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"example.com/store"
+)
+
+// readExport decodes an exported file, gzipped or not
+func readExport(t *testing.T, path string, compressed bool) []store.Document {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer file.Close()
+	var in io.Reader = file
+	if compressed {
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatalf("gzip.NewReader: %v", err)
+		}
+		in = zr
+	}
+
+	var docs []store.Document
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		var doc store.Document
+		if err := json.Unmarshal(scanner.Bytes(), &doc); err != nil {
+			t.Fatalf("line %d: %v", len(docs)+1, err)
+		}
+		docs = append(docs, doc)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	return docs
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	start := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	var docs []store.Document
+	stored := make(map[string]store.Document)
+	// Stored newest first, exported oldest first
+	for i := 9; i >= 0; i-- {
+		doc := testLog(fmt.Sprintf("m%02d", i), start.Add(time.Duration(i)*time.Second))
+		doc["node_id"] = float64(i % 3)
+		doc["error_details"] = map[string]interface{}{"error_code": fmt.Sprintf("E%d", i)}
+		docs = append(docs, doc)
+		stored[doc.String("message")] = doc
+	}
+
+	tests := []struct {
+		name       string
+		file       string
+		compress   bool
+		query      store.Query
+		exported   int
+		compressed bool
+	}{
+		{"all", "logs.ndjson", false, store.Query{}, 10, false},
+		{"gzip flag", "logs.ndjson", true, store.Query{}, 10, true},
+		{"gz name", "logs.ndjson.gz", false, store.Query{}, 10, true},
+		{"filtered", "logs.ndjson", false, store.Query{Filter: store.Term{Field: "node_id", Values: []string{"1"}}}, 3, false},
+		{"limited", "logs.ndjson", false, store.Query{Size: 4}, 4, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := openTestStore(t, docs...)
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := ExportLogs(context.Background(), logs, tt.query, path, exportCompressed(path, tt.compress)); err != nil {
+				t.Fatalf("ExportLogs: %v", err)
+			}
+			if _, err := os.Stat(path + ".partial"); !os.IsNotExist(err) {
+				t.Errorf("partial file left behind: %v", err)
+			}
+
+			exported := readExport(t, path, tt.compressed)
+			if len(exported) != tt.exported {
+				t.Fatalf("exported %d logs, want %d", len(exported), tt.exported)
+			}
+			for i, doc := range exported {
+				if original := stored[doc.String("message")]; !reflect.DeepEqual(doc, original) {
+					t.Errorf("exported %v, want %v", doc, original)
+				}
+				if i > 0 && exported[i-1].String("message") >= doc.String("message") {
+					t.Errorf("%s exported before %s, want oldest first", exported[i-1].String("message"), doc.String("message"))
+				}
+			}
+
+			// Importing the export into an empty store gives back the same logs
+			imported := openTestStore(t, exported...)
+			again := filepath.Join(t.TempDir(), tt.file)
+			if err := ExportLogs(context.Background(), imported, store.Query{}, again, tt.compressed); err != nil {
+				t.Fatalf("ExportLogs: %v", err)
+			}
+			if reexported := readExport(t, again, tt.compressed); !reflect.DeepEqual(reexported, exported) {
+				t.Errorf("export of the imported logs differs:\n%v\nwant:\n%v", reexported, exported)
+			}
+		})
+	}
+}
+
+func TestExportCanceled(t *testing.T) {
+	logs := openTestStore(t, testLog("m00", time.Now()))
+	path := filepath.Join(t.TempDir(), "logs.ndjson")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := ExportLogs(ctx, logs, store.Query{}, path, false); err == nil {
+		t.Fatalf("ExportLogs succeeded, want the cancellation")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("incomplete export at %s: %v", path, err)
+	}
+	if _, err := os.Stat(path + ".partial"); err != nil {
+		t.Errorf("partial file missing: %v", err)
+	}
+}
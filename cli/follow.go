@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"example.com/store"
+)
+
+// followOverlap is how far behind the newest log it printed follow mode keeps looking:
+// logs are ordered by event time but may only become searchable later (Fluentd
+// buffering, indexing, index refresh)
+const followOverlap = 30 * time.Second
+
+// followPageSize is how many logs follow mode asks for at once
+const followPageSize = 500
+
+// followMaxBackoff caps the wait between attempts while the store can't be reached
+const followMaxBackoff = 30 * time.Second
+
+// followFloor is where following starts: the oldest of the logs shown first, which are
+// oldest first in hits, or since when none were shown. Polls only pass on the logs after
+// it, since those before it were left out on purpose.
+func followFloor(hits []store.Hit, since time.Time) store.Position {
+	if len(hits) > 0 {
+		return store.PositionOf(hits[0])
+	}
+	return store.Position{Time: since}
+}
+
+// followPoll returns the query of a poll for the logs matching q after floor, starting
+// followOverlap before the newest log shown so logs that show up late aren't missed
+func followPoll(q store.Query, floor store.Position, newest time.Time) store.Query {
+	poll := q
+	poll.Sort, poll.Size = store.SortOldest, followPageSize
+	poll.From = newest.Add(-followOverlap)
+	if floor.Time.After(poll.From) {
+		poll.From = floor.Time
+	}
+	if q.From.After(poll.From) {
+		poll.From = q.From
+	}
+	return poll
+}
+
+// afterFloor reports whether a polled log comes after floor, in the order of the store
+func afterFloor(hit store.Hit, floor store.Position) bool {
+	position := store.PositionOf(hit)
+	if !position.Time.Equal(floor.Time) {
+		return position.Time.After(floor.Time)
+	}
+	return position.ID > floor.ID
+}
+
+// FollowLogs prints the last q.Size logs matching q, oldest first, then the new ones as
+// they arrive, until ctx is cancelled; with a q.Size of 0 it only prints new ones. Every
+// poll reads the logs of the last followOverlap again and skips those already printed,
+// so logs that show up late or after a lost connection are neither missed nor printed
+// twice.
+func FollowLogs(ctx context.Context, logs store.LogStore, q store.Query, interval time.Duration, printer *Printer) error {
+	defer printer.Close()
+	refresher, _ := logs.(store.Refresher)
+
+	start := time.Now()
+	result := &store.Result{}
+	if q.Size > 0 {
+		latest := q
+		latest.Sort = store.SortNewest
+		var err error
+		if result, err = logs.Query(latest); err != nil {
+			return fmt.Errorf("failed to retrieve logs: %w", err)
+		}
+	}
+	shown := reversed(result.Hits)
+	floor := followFloor(shown, start)
+
+	seen := make(map[string]time.Time)
+	newest := start
+	if len(shown) > 0 {
+		newest = store.PositionOf(shown[len(shown)-1]).Time
+	}
+	for _, hit := range shown {
+		seen[hit.ID] = store.PositionOf(hit).Time
+		if err := printer.Print(hit.Doc); err != nil {
+			return err
+		}
+	}
+	printer.Flush()
+
+	wait := interval
+	failing := false
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+
+		err := pollLogs(ctx, logs, refresher, followPoll(q, floor, newest), func(hit store.Hit) {
+			t := store.PositionOf(hit).Time
+			if _, printed := seen[hit.ID]; printed || !afterFloor(hit, floor) {
+				return
+			}
+			seen[hit.ID] = t
+			if t.After(newest) {
+				newest = t
+			}
+			printer.Print(hit.Doc)
+		})
+		printer.Flush()
+		if err != nil {
+			if !failing {
+				log.Printf("Lost the log store, retrying: %v", err)
+				failing = true
+			}
+			wait = min(2*wait, followMaxBackoff)
+			continue
+		}
+		if failing {
+			log.Printf("Log store is back, resuming")
+			failing = false
+		}
+		wait = interval
+
+		// Logs older than the next poll's window can't come back
+		for id, t := range seen {
+			if t.Before(newest.Add(-followOverlap)) {
+				delete(seen, id)
+			}
+		}
+	}
+}
+
+// pollLogs hands every log matching q to fn, oldest first, a page at a time
+func pollLogs(ctx context.Context, logs store.LogStore, refresher store.Refresher, q store.Query, fn func(store.Hit)) error {
+	if refresher != nil {
+		if err := refresher.Refresh(); err != nil {
+			return err
+		}
+	}
+	for ctx.Err() == nil {
+		result, err := logs.Query(q)
+		if err != nil {
+			return err
+		}
+		for _, hit := range result.Hits {
+			fn(hit)
+		}
+		if len(result.Hits) < q.Size {
+			return nil
+		}
+		after := store.PositionOf(result.Hits[len(result.Hits)-1])
+		q.After = &after
+	}
+	return nil
+}
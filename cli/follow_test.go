@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/store"
+)
+
+// syncBuffer is a bytes.Buffer safe to read while follow mode writes to it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// testLog builds a log with a message at a time
+func testLog(message string, at time.Time) store.Document {
+	return store.Document{
+		store.FieldEventID:   message,
+		store.FieldTimestamp: at.UTC().Format(time.RFC3339Nano),
+		"service_name":       "cache",
+		"log_level":          "INFO",
+		"message":            message,
+	}
+}
+
+// openTestStore opens a local store in a temporary directory with the given logs
+func openTestStore(t *testing.T, docs ...store.Document) *store.LocalStore {
+	t.Helper()
+	logs, err := store.NewLocalStore(store.LocalConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	t.Cleanup(func() { logs.Close() })
+	if err := logs.Append(docs...); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	return logs
+}
+
+// arrivingStore adds logs right before the first poll of follow mode
+type arrivingStore struct {
+	*store.LocalStore
+	arriving []store.Document
+	once     sync.Once
+}
+
+func (s *arrivingStore) Query(q store.Query) (*store.Result, error) {
+	if q.Sort == store.SortOldest {
+		var err error
+		s.once.Do(func() { err = s.LocalStore.Append(s.arriving...) })
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s.LocalStore.Query(q)
+}
+
+func TestFollowLogs(t *testing.T) {
+	start := time.Now()
+	var stored []store.Document
+	for i := 0; i < 20; i++ {
+		stored = append(stored, testLog(fmt.Sprintf("m%02d", i), start.Add(time.Duration(i-20)*time.Second)))
+	}
+	arriving := []store.Document{
+		testLog("new", start.Add(time.Second)),
+		// Late logs, stamped before the newest one shown
+		testLog("late", start.Add(-1500*time.Millisecond)),
+		testLog("older", start.Add(-10*time.Second)),
+	}
+
+	tests := []struct {
+		name  string
+		limit int
+		want  string
+	}{
+		{"last logs", 3, "m17 m18 m19 late new"},
+		{"new logs only", 0, "new"},
+		{"all logs", 50, "m00 m01 m02 m03 m04 m05 m06 m07 m08 m09 m10 m11 m12 m13 m14 m15 m16 m17 m18 m19 older late new"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := &arrivingStore{LocalStore: openTestStore(t, stored...), arriving: arriving}
+			out := &syncBuffer{}
+			printer, err := NewPrinter(out, OutputLogfmt, []string{"message"})
+			if err != nil {
+				t.Fatalf("NewPrinter: %v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() { done <- FollowLogs(ctx, logs, store.Query{Size: tt.limit}, 10*time.Millisecond, printer) }()
+			// A few polls, so logs printed twice would show
+			time.Sleep(100 * time.Millisecond)
+			cancel()
+			if err := <-done; err != nil {
+				t.Fatalf("FollowLogs: %v", err)
+			}
+
+			got := strings.Join(strings.Fields(strings.ReplaceAll(out.String(), "message=", "")), " ")
+			if got != tt.want {
+				t.Errorf("printed %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"example.com/store"
+
+	"github.com/fatih/color"
+)
+
+// Output formats of the logs and tail commands
+const (
+	OutputText   = "text"
+	OutputJSON   = "json"
+	OutputNDJSON = "ndjson"
+	OutputTable  = "table"
+	OutputCSV    = "csv"
+	OutputLogfmt = "logfmt"
+)
+
+// outputFormats lists the formats in the order the help shows them
+var outputFormats = []string{OutputText, OutputJSON, OutputNDJSON, OutputTable, OutputCSV, OutputLogfmt}
+
+// defaultColumns are the columns of table and csv output without --fields
+var defaultColumns = []string{"@timestamp", "log_level", "message_type", "service_name", "node_id", "message"}
+
+// logfmtFirst are the keys logfmt output starts with, the others follow sorted
+var logfmtFirst = []string{"@timestamp", "log_level", "message_type", "service_name", "node_id", "message"}
+
+// textTimeLayout is how text output shows event times, in local time
+const textTimeLayout = "2006-01-02 15:04:05.000"
+
+// Colors of the text output; fatih/color turns them off when the output isn't a
+// terminal or NO_COLOR is set
+var (
+	timeColor    = color.New(color.FgHiBlack).SprintFunc()
+	infoColor    = color.New(color.FgGreen).SprintFunc()
+	warnColor    = color.New(color.FgYellow).SprintFunc()
+	errorColor   = color.New(color.FgRed, color.Bold).SprintFunc()
+	otherColor   = color.New(color.FgCyan).SprintFunc()
+	serviceColor = color.New(color.FgBlue).SprintFunc()
+	detailColor  = color.New(color.FgMagenta).SprintFunc()
+	markerColor  = color.New(color.FgHiWhite, color.Bold).SprintFunc()
+)
+
+// Printer writes logs in one of the output formats
+type Printer struct {
+	out     io.Writer
+	format  string
+	fields  []string // only these fields, in this order; nil for all of them
+	table   *tabwriter.Writer
+	csv     *csv.Writer
+	printed int
+}
+
+// NewPrinter creates a printer for a format; fields picks the fields, or columns,
+// to show and isn't supported by the text format
+func NewPrinter(out io.Writer, format string, fields []string) (*Printer, error) {
+	known := false
+	for _, f := range outputFormats {
+		known = known || f == format
+	}
+	if !known {
+		return nil, fmt.Errorf("invalid output %q, use %s", format, strings.Join(outputFormats, ", "))
+	}
+	for _, field := range fields {
+		if strings.TrimSpace(field) == "" {
+			return nil, fmt.Errorf("--fields: empty field name")
+		}
+	}
+	if format == OutputText && len(fields) > 0 {
+		return nil, fmt.Errorf("--fields doesn't apply to text output, use --output table, csv, json, ndjson or logfmt")
+	}
+
+	p := &Printer{out: out, format: format, fields: fields}
+	switch format {
+	case OutputTable:
+		p.table = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		p.out = p.table
+	case OutputCSV:
+		p.csv = csv.NewWriter(out)
+	}
+	if (format == OutputTable || format == OutputCSV) && len(p.fields) == 0 {
+		p.fields = defaultColumns
+	}
+	return p, nil
+}
+
+// Print writes one log
+func (p *Printer) Print(doc store.Document) error {
+	defer func() { p.printed++ }()
+	switch p.format {
+	case OutputJSON:
+		data, err := json.MarshalIndent(p.project(doc), "  ", "  ")
+		if err != nil {
+			return err
+		}
+		separator := ",\n  "
+		if p.printed == 0 {
+			separator = "[\n  "
+		}
+		_, err = fmt.Fprintf(p.out, "%s%s", separator, data)
+		return err
+	case OutputNDJSON:
+		data, err := json.Marshal(p.project(doc))
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(p.out, "%s\n", data)
+		return err
+	case OutputTable:
+		if p.printed == 0 {
+			header := make([]string, len(p.fields))
+			for i, field := range p.fields {
+				header[i] = strings.ToUpper(field)
+			}
+			fmt.Fprintln(p.out, strings.Join(header, "\t"))
+		}
+		cells := p.cells(doc)
+		for i, cell := range cells {
+			// A tab or newline would break the alignment
+			cells[i] = strings.NewReplacer("\t", " ", "\n", " ").Replace(cell)
+		}
+		_, err := fmt.Fprintln(p.out, strings.Join(cells, "\t"))
+		return err
+	case OutputCSV:
+		if p.printed == 0 {
+			if err := p.csv.Write(p.fields); err != nil {
+				return err
+			}
+		}
+		return p.csv.Write(p.cells(doc))
+	case OutputLogfmt:
+		_, err := fmt.Fprintf(p.out, "%s\n", formatLogfmt(doc, p.fields))
+		return err
+	}
+	_, err := fmt.Fprint(p.out, formatText(doc))
+	return err
+}
+
+// PrintMarked writes one log of a list where one of them is singled out; the text
+// format points at it with an arrow, the others show it like the rest
+func (p *Printer) PrintMarked(doc store.Document, marked bool) error {
+	if p.format != OutputText {
+		return p.Print(doc)
+	}
+	marker := "  "
+	if marked {
+		marker = markerColor("▶ ")
+	}
+	if _, err := fmt.Fprint(p.out, marker); err != nil {
+		return err
+	}
+	return p.Print(doc)
+}
+
+// Flush writes what the table and csv formats hold back
+func (p *Printer) Flush() error {
+	if p.table != nil {
+		return p.table.Flush()
+	}
+	if p.csv != nil {
+		p.csv.Flush()
+		return p.csv.Error()
+	}
+	return nil
+}
+
+// Close ends the output: the JSON array is closed and everything flushed
+func (p *Printer) Close() error {
+	if p.format == OutputJSON {
+		closing := "\n]\n"
+		if p.printed == 0 {
+			closing = "[]\n"
+		}
+		if _, err := fmt.Fprint(p.out, closing); err != nil {
+			return err
+		}
+	}
+	return p.Flush()
+}
+
+// project keeps the selected fields of a log, keyed by their name as given
+func (p *Printer) project(doc store.Document) map[string]interface{} {
+	if len(p.fields) == 0 {
+		return doc
+	}
+	projected := make(map[string]interface{}, len(p.fields))
+	for _, field := range p.fields {
+		if value, ok := doc.Lookup(field); ok {
+			projected[field] = value
+		}
+	}
+	return projected
+}
+
+// cells returns the selected fields of a log as text
+func (p *Printer) cells(doc store.Document) []string {
+	cells := make([]string, len(p.fields))
+	for i, field := range p.fields {
+		value, _ := doc.Lookup(field)
+		cells[i] = formatField(value)
+	}
+	return cells
+}
+
+// formatField formats a field for the text based formats; objects and lists are JSON
+func formatField(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		data, _ := json.Marshal(value)
+		return string(data)
+	}
+	return store.FormatValue(value)
+}
+
+// formatLogfmt renders a log as key=value pairs, quoting values when needed; without
+// fields, the common ones come first and the others follow sorted
+func formatLogfmt(doc store.Document, fields []string) string {
+	var buf bytes.Buffer
+	write := func(key string, value interface{}) {
+		if buf.Len() > 0 {
+			buf.WriteByte(' ')
+		}
+		text := formatField(value)
+		if text == "" || strings.ContainsAny(text, " =\"\t\n") {
+			text = strconv.Quote(text)
+		}
+		buf.WriteString(key)
+		buf.WriteByte('=')
+		buf.WriteString(text)
+	}
+
+	if len(fields) > 0 {
+		for _, field := range fields {
+			if value, ok := doc.Lookup(field); ok {
+				write(field, value)
+			}
+		}
+		return buf.String()
+	}
+
+	done := make(map[string]bool, len(logfmtFirst))
+	for _, key := range logfmtFirst {
+		if value, ok := doc[key]; ok {
+			write(key, value)
+			done[key] = true
+		}
+	}
+	var rest []string
+	for key := range doc {
+		if !done[key] {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+	for _, key := range rest {
+		write(key, doc[key])
+	}
+	return buf.String()
+}
+
+// formatText renders a log for people: its time, kind and origin, then what matters
+// for its message type, such as the error details of an ERROR log or the response
+// time of a WARN log
+func formatText(doc store.Document) string {
+	when := "-"
+	if t, ok := store.EventTime(doc); ok {
+		when = t.Local().Format(textTimeLayout)
+	}
+	origin := serviceColor(doc.String("service_name"))
+	if node := doc.String("node_id"); node != "" {
+		origin += "[" + node + "]"
+	}
+
+	var kind string
+	var details []string
+	detail := func(name string, field string, suffix string) {
+		if value := doc.String(field); value != "" {
+			details = append(details, detailColor(name+"=")+value+suffix)
+		}
+	}
+	switch doc.String("message_type") {
+	case "LOG":
+		level := doc.String("log_level")
+		switch level {
+		case "INFO":
+			kind = infoColor(fmt.Sprintf("%-5s", level))
+		case "WARN":
+			kind = warnColor(fmt.Sprintf("%-5s", level))
+			detail("response_time", "response_time_ms", "ms")
+			detail("threshold", "threshold_limit_ms", "ms")
+		case "ERROR":
+			kind = errorColor(fmt.Sprintf("%-5s", level))
+			detail("error_code", "error_details.error_code", "")
+			if message := doc.String("error_details.error_message"); message != "" {
+				details = append(details, detailColor("error=")+strconv.Quote(message))
+			}
+		default:
+			kind = fmt.Sprintf("%-5s", level)
+		}
+		details = append([]string{doc.String("message")}, details...)
+	case "HEARTBEAT":
+		kind = otherColor("HEARTBEAT")
+		detail("status", "status", "")
+	case "REGISTRATION":
+		kind = otherColor("REGISTRATION")
+		detail("host", "host", "")
+		detail("version", "version", "")
+		detail("address", "address", "")
+	default:
+		return fmt.Sprintf("%s %s\n", timeColor(when), formatLogfmt(doc, nil))
+	}
+	detail("trace_id", "trace_id", "")
+	return fmt.Sprintf("%s %s %s %s\n", timeColor(when), kind, origin, strings.Join(details, " "))
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"example.com/store"
+	"github.com/fatih/color"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// outputLogs are logs of every message type, as they come back from the store
+func outputLogs() []store.Document {
+	return []store.Document{
+		{
+			"@timestamp": "2026-10-19T08:00:00.125Z", "message_type": "LOG", "log_level": "INFO",
+			"service_name": "cache", "node_id": float64(3), "message": "cache hit", "trace_id": "t-1",
+		},
+		{
+			"@timestamp": "2026-10-19T08:00:01Z", "message_type": "LOG", "log_level": "WARN",
+			"service_name": "router", "node_id": float64(12), "message": `slow "origin", retrying`,
+			"response_time_ms": float64(750), "threshold_limit_ms": float64(500),
+		},
+		{
+			"@timestamp": "2026-10-19T08:00:02Z", "message_type": "LOG", "log_level": "ERROR",
+			"service_name": "origin-server", "node_id": float64(7), "message": "listen failed\ton :7777",
+			"error_details": map[string]interface{}{"error_code": "LISTEN_ERROR", "error_message": "address in use"},
+		},
+		{
+			"@timestamp": "2026-10-19T08:00:03Z", "message_type": "HEARTBEAT",
+			"service_name": "cache", "node_id": float64(3), "status": "UP",
+		},
+		{
+			"@timestamp": "2026-10-19T08:00:04Z", "message_type": "REGISTRATION",
+			"service_name": "cache", "node_id": float64(3), "host": "host-1", "version": "1.2", "address": ":7000",
+		},
+	}
+}
+
+func TestPrinterGolden(t *testing.T) {
+	noColor, local := color.NoColor, time.Local
+	color.NoColor, time.Local = true, time.UTC
+	defer func() { color.NoColor, time.Local = noColor, local }()
+
+	fields := []string{"node_id", "message", "error_details.error_code"}
+	tests := []struct {
+		name   string
+		format string
+		fields []string
+		empty  bool
+	}{
+		{"text", OutputText, nil, false},
+		{"json", OutputJSON, nil, false},
+		{"json-empty", OutputJSON, nil, true},
+		{"ndjson", OutputNDJSON, nil, false},
+		{"table", OutputTable, nil, false},
+		{"csv", OutputCSV, nil, false},
+		{"logfmt", OutputLogfmt, nil, false},
+		{"json-fields", OutputJSON, fields, false},
+		{"ndjson-fields", OutputNDJSON, fields, false},
+		{"table-fields", OutputTable, fields, false},
+		{"csv-fields", OutputCSV, fields, false},
+		{"logfmt-fields", OutputLogfmt, fields, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			printer, err := NewPrinter(&out, tt.format, tt.fields)
+			if err != nil {
+				t.Fatalf("NewPrinter: %v", err)
+			}
+			if !tt.empty {
+				for _, doc := range outputLogs() {
+					if err := printer.Print(doc); err != nil {
+						t.Fatalf("Print: %v", err)
+					}
+				}
+			}
+			if err := printer.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			path := filepath.Join("testdata", "output", tt.name+".golden")
+			if *update {
+				if err := os.WriteFile(path, out.Bytes(), 0o644); err != nil {
+					t.Fatalf("WriteFile: %v", err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("ReadFile: %v", err)
+			}
+			if got := out.String(); got != string(want) {
+				t.Errorf("output differs from %s:\n%s\nwant:\n%s", path, got, want)
+			}
+		})
+	}
+}
+
+func TestNewPrinterErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		fields []string
+		err    string
+	}{
+		{"unknown format", "yaml", nil, `invalid output "yaml", use text, json, ndjson, table, csv, logfmt`},
+		{"empty field", OutputCSV, []string{"message", " "}, "--fields: empty field name"},
+		{"text fields", OutputText, []string{"message"}, "--fields doesn't apply to text output, use --output table, csv, json, ndjson or logfmt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPrinter(&bytes.Buffer{}, tt.format, tt.fields)
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("error = %v, want %s", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/store"
+)
+
+// timeLayouts are the absolute times accepted by --since and --until; those without a
+// zone are local times
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ParseTime reads an absolute time, "now", or a duration meaning that long before now
+// ("15m", "2h", "7d"); an empty value is the zero time
+func ParseTime(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	switch value {
+	case "":
+		return time.Time{}, nil
+	case "now":
+		return now, nil
+	}
+	if d, err := parseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, use a duration like 15m, 2h or 7d, or a time like 2006-01-02T15:04:05Z", value)
+}
+
+// parseDuration is time.ParseDuration with days ("7d", "1d12h")
+func parseDuration(value string) (time.Duration, error) {
+	if i := strings.Index(value, "d"); i > 0 {
+		days, err := strconv.Atoi(value[:i])
+		if err != nil {
+			return 0, err
+		}
+		rest := time.Duration(0)
+		if value[i+1:] != "" {
+			if rest, err = time.ParseDuration(value[i+1:]); err != nil {
+				return 0, err
+			}
+		}
+		return time.Duration(days)*24*time.Hour + rest, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// ParseTimeRange reads the --since and --until values of a command
+func ParseTimeRange(since string, until string, now time.Time) (time.Time, time.Time, error) {
+	from, err := ParseTime(since, now)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("--since: %w", err)
+	}
+	to, err := ParseTime(until, now)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("--until: %w", err)
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("--since (%s) must be before --until (%s)", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+	return from, to, nil
+}
+
+// messageTypes are the message types the services send
+var messageTypes = []string{"LOG", "HEARTBEAT", "REGISTRATION"}
+
+// FilterFlags are the filter flags of the logs command; values starting with "!"
+// exclude logs instead of selecting them
+type FilterFlags struct {
+	Services     []string
+	Nodes        []string
+	ErrorCodes   []string
+	MessageTypes []string
+	Grep         string
+	Regex        string
+}
+
+// Filter validates the flags and combines them into one filter, nil when none is set.
+// Values of one flag select logs matching any of them; flags all have to match.
+func (f FilterFlags) Filter() (store.Filter, error) {
+	var filters store.And
+	terms := []struct {
+		flag   string
+		field  string
+		values []string
+		check  func(string) (string, error)
+	}{
+		{"service", "service_name", f.Services, checkNonEmpty},
+		{"node", "node_id", f.Nodes, checkNodeID},
+		{"error-code", "error_details.error_code", f.ErrorCodes, checkNonEmpty},
+		{"message-type", "message_type", f.MessageTypes, checkMessageType},
+	}
+	for _, term := range terms {
+		filter, err := termFilter(term.field, term.values, term.check)
+		if err != nil {
+			return nil, fmt.Errorf("--%s: %w", term.flag, err)
+		}
+		filters = append(filters, filter...)
+	}
+
+	if f.Grep != "" {
+		text, negated := negation(f.Grep)
+		if strings.TrimSpace(text) == "" {
+			return nil, fmt.Errorf("--grep: empty search")
+		}
+		filters = append(filters, negate(store.Text{Field: "message", Query: text}, negated))
+	}
+	if f.Regex != "" {
+		pattern, negated := negation(f.Regex)
+		if pattern == "" {
+			return nil, fmt.Errorf("--regex: empty pattern")
+		}
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("--regex: %w", err)
+		}
+		filters = append(filters, negate(store.Regexp{Field: "message", Pattern: compiled}, negated))
+	}
+
+	if len(filters) == 0 {
+		return nil, nil
+	}
+	return filters, nil
+}
+
+// termFilter builds the filters of one multi-valued flag: a Term with the selected
+// values and a Not with the excluded ones
+func termFilter(field string, values []string, check func(string) (string, error)) ([]store.Filter, error) {
+	var include, exclude []string
+	for _, value := range values {
+		value, negated := negation(strings.TrimSpace(value))
+		value, err := check(strings.TrimSpace(value))
+		if err != nil {
+			return nil, err
+		}
+		if negated {
+			exclude = append(exclude, value)
+		} else {
+			include = append(include, value)
+		}
+	}
+
+	var filters []store.Filter
+	if len(include) > 0 {
+		filters = append(filters, store.Term{Field: field, Values: include})
+	}
+	if len(exclude) > 0 {
+		filters = append(filters, store.Not{Filter: store.Term{Field: field, Values: exclude}})
+	}
+	return filters, nil
+}
+
+// negation strips the "!" of an excluded value
+func negation(value string) (string, bool) {
+	if strings.HasPrefix(value, "!") {
+		return value[1:], true
+	}
+	return value, false
+}
+
+func negate(filter store.Filter, negated bool) store.Filter {
+	if negated {
+		return store.Not{Filter: filter}
+	}
+	return filter
+}
+
+func checkNonEmpty(value string) (string, error) {
+	if value == "" {
+		return "", fmt.Errorf("empty value")
+	}
+	return value, nil
+}
+
+func checkNodeID(value string) (string, error) {
+	id, err := strconv.Atoi(value)
+	if err != nil || id < 0 {
+		return "", fmt.Errorf("invalid node ID %q, node IDs are positive numbers", value)
+	}
+	return strconv.Itoa(id), nil
+}
+
+func checkMessageType(value string) (string, error) {
+	value = strings.ToUpper(value)
+	for _, messageType := range messageTypes {
+		if value == messageType {
+			return value, nil
+		}
+	}
+	return "", fmt.Errorf("invalid message type %q, use %s", value, strings.Join(messageTypes, ", "))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Time
+		fails bool
+	}{
+		{"", time.Time{}, false},
+		{"now", now, false},
+		{" 15m ", now.Add(-15 * time.Minute), false},
+		{"2h", now.Add(-2 * time.Hour), false},
+		{"7d", now.Add(-7 * 24 * time.Hour), false},
+		{"1d12h", now.Add(-36 * time.Hour), false},
+		{"2026-10-19T08:00:00Z", time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC), false},
+		{"2026-10-19T08:00:00+02:00", time.Date(2026, 10, 19, 6, 0, 0, 0, time.UTC), false},
+		{"2026-10-19 08:00", time.Date(2026, 10, 19, 8, 0, 0, 0, time.Local), false},
+		{"2026-10-19", time.Date(2026, 10, 19, 0, 0, 0, 0, time.Local), false},
+		{"yesterday", time.Time{}, true},
+		{"xd", time.Time{}, true},
+		{"2026-13-01", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseTime(tt.value, now)
+			if tt.fails {
+				if err == nil {
+					t.Errorf("ParseTime = %s, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseTime: %v", err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("ParseTime = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTimeRange(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		since string
+		until string
+		err   string
+	}{
+		{"open", "", "", ""},
+		{"since", "1h", "", ""},
+		{"both", "2h", "1h", ""},
+		{"reversed", "1h", "2h", "--since (2026-10-19T11:00:00Z) must be before --until (2026-10-19T10:00:00Z)"},
+		{"empty range", "now", "now", "--since (2026-10-19T12:00:00Z) must be before --until (2026-10-19T12:00:00Z)"},
+		{"bad since", "soon", "", `--since: invalid time "soon", use a duration like 15m, 2h or 7d, or a time like 2006-01-02T15:04:05Z`},
+		{"bad until", "", "later", `--until: invalid time "later", use a duration like 15m, 2h or 7d, or a time like 2006-01-02T15:04:05Z`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := ParseTimeRange(tt.since, tt.until, now)
+			if tt.err == "" && err != nil {
+				t.Errorf("ParseTimeRange: %v", err)
+			}
+			if tt.err != "" && (err == nil || err.Error() != tt.err) {
+				t.Errorf("error = %v, want %s", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestFilterFlags(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags FilterFlags
+		want  string
+		err   string
+	}{
+		{"none", FilterFlags{}, "<nil>", ""},
+		{"services", FilterFlags{Services: []string{"cache", " router "}}, `service_name:("cache" OR "router")`, ""},
+		{"excluded", FilterFlags{Services: []string{"cache", "!router"}}, `service_name:"cache" AND NOT service_name:"router"`, ""},
+		{"node", FilterFlags{Nodes: []string{"007"}}, `node_id:"7"`, ""},
+		{"message type", FilterFlags{MessageTypes: []string{"log"}}, `message_type:"LOG"`, ""},
+		{"grep", FilterFlags{Grep: "!timed out"}, `NOT message has words "timed out"`, ""},
+		{"regex", FilterFlags{Regex: "time(d )?out"}, "message:/time(d )?out/", ""},
+		{"combined", FilterFlags{ErrorCodes: []string{"E42"}, Grep: "disk"}, `error_details.error_code:"E42" AND message has words "disk"`, ""},
+		{"bad node", FilterFlags{Nodes: []string{"-1"}}, "", `--node: invalid node ID "-1", node IDs are positive numbers`},
+		{"bad type", FilterFlags{MessageTypes: []string{"metric"}}, "", `--message-type: invalid message type "METRIC", use LOG, HEARTBEAT, REGISTRATION`},
+		{"empty service", FilterFlags{Services: []string{"!"}}, "", "--service: empty value"},
+		{"empty grep", FilterFlags{Grep: " "}, "", "--grep: empty search"},
+		{"empty regex", FilterFlags{Regex: "!"}, "", "--regex: empty pattern"},
+		{"bad regex", FilterFlags{Regex: "("}, "", "--regex: error parsing regexp: missing closing ): `(`"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := tt.flags.Filter()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("error = %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Filter: %v", err)
+			}
+			if got := fmt.Sprint(filter); got != tt.want {
+				t.Errorf("filter = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
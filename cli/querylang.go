@@ -0,0 +1,469 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"example.com/store"
+)
+
+// queryFields maps the short field names of the query language to the log fields
+var queryFields = map[string]string{
+	"level":      "log_level",
+	"service":    "service_name",
+	"node":       "node_id",
+	"type":       "message_type",
+	"code":       "error_details.error_code",
+	"error_code": "error_details.error_code",
+	"error":      "error_details.error_message",
+	"trace":      "trace_id",
+	"time":       store.FieldTimestamp,
+}
+
+// timeFields are compared as times by the range operators, the others as numbers
+var timeFields = map[string]bool{store.FieldTimestamp: true, "timestamp": true, "ingested_at": true}
+
+// textFields are the string fields of a log, which have no order to compare with the
+// range operators
+var textFields = map[string]bool{
+	"message":                     true,
+	"log_level":                   true,
+	"service_name":                true,
+	"message_type":                true,
+	"error_details.error_code":    true,
+	"error_details.error_message": true,
+	"trace_id":                    true,
+	store.FieldEventID:            true,
+}
+
+// SyntaxError is a query that can't be parsed, with the position of the problem
+type SyntaxError struct {
+	Query string
+	Pos   int // byte offset in Query
+	Msg   string
+}
+
+// Error shows the query with a caret under the problem
+func (e *SyntaxError) Error() string {
+	column := len([]rune(e.Query[:e.Pos]))
+	return fmt.Sprintf("invalid query: %s at column %d\n  %s\n  %s^", e.Msg, column+1, e.Query, strings.Repeat(" ", column))
+}
+
+// Kinds of query tokens
+const (
+	tokenEnd = iota
+	tokenLeft
+	tokenRight
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenTerm // field, operator and value, or a bare value searched in the message
+)
+
+// queryToken is one token of a query
+type queryToken struct {
+	kind   int
+	pos    int
+	field  string // empty for a bare value
+	op     string // :, >, >=, < or <=
+	value  string
+	quoted bool // the value was in double quotes
+	regexp bool // the value was between slashes
+}
+
+// queryParser parses the query language with recursive descent; NOT binds tighter
+// than AND, which binds tighter than OR, and terms next to each other are ANDed
+type queryParser struct {
+	query string
+	pos   int
+	token queryToken
+	now   time.Time
+}
+
+// ParseQuery compiles a query such as
+//
+//	level:ERROR AND service:cache* AND NOT message:"timeout" AND @timestamp>now-1h
+//
+// into a store filter; times relative to now, such as now-1h, are resolved against now
+func ParseQuery(query string, now time.Time) (store.Filter, error) {
+	p := &queryParser{query: query, now: now}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.token.kind == tokenEnd {
+		return nil, p.errorf(p.token.pos, "empty query")
+	}
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	switch p.token.kind {
+	case tokenEnd:
+		return filter, nil
+	case tokenRight:
+		return nil, p.errorf(p.token.pos, "unexpected )")
+	}
+	return nil, p.errorf(p.token.pos, "unexpected %s", p.describe())
+}
+
+func (p *queryParser) errorf(pos int, format string, args ...interface{}) error {
+	return &SyntaxError{Query: p.query, Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// describe names the current token for error messages
+func (p *queryParser) describe() string {
+	switch p.token.kind {
+	case tokenEnd:
+		return "end of query"
+	case tokenLeft:
+		return "("
+	case tokenRight:
+		return ")"
+	case tokenAnd:
+		return "AND"
+	case tokenOr:
+		return "OR"
+	case tokenNot:
+		return "NOT"
+	}
+	return strconv.Quote(p.query[p.token.pos:p.pos])
+}
+
+func (p *queryParser) parseOr() (store.Filter, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	filters := store.Or{first}
+	for p.token.kind == tokenOr {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		filter, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	if len(filters) == 1 {
+		return first, nil
+	}
+	return filters, nil
+}
+
+func (p *queryParser) parseAnd() (store.Filter, error) {
+	first, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	filters := store.And{first}
+	for {
+		switch p.token.kind {
+		case tokenAnd:
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		case tokenNot, tokenLeft, tokenTerm:
+			// Implicit AND
+		default:
+			if len(filters) == 1 {
+				return first, nil
+			}
+			return filters, nil
+		}
+		filter, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+}
+
+func (p *queryParser) parseUnary() (store.Filter, error) {
+	token := p.token
+	switch token.kind {
+	case tokenNot:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		filter, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return store.Not{Filter: filter}, nil
+	case tokenLeft:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if p.token.kind == tokenRight {
+			return nil, p.errorf(p.token.pos, "empty parentheses")
+		}
+		filter, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.token.kind != tokenRight {
+			return nil, p.errorf(token.pos, "missing ) for this (")
+		}
+		return filter, p.next()
+	case tokenTerm:
+		filter, err := p.compileTerm(token)
+		if err != nil {
+			return nil, err
+		}
+		return filter, p.next()
+	}
+	return nil, p.errorf(token.pos, "expected a term, NOT or ( but found %s", p.describe())
+}
+
+// next reads the next token
+func (p *queryParser) next() error {
+	for p.pos < len(p.query) && unicode.IsSpace(rune(p.query[p.pos])) {
+		p.pos++
+	}
+	start := p.pos
+	p.token = queryToken{pos: start}
+	if p.pos == len(p.query) {
+		p.token.kind = tokenEnd
+		return nil
+	}
+	switch p.query[p.pos] {
+	case '(':
+		p.pos++
+		p.token.kind = tokenLeft
+		return nil
+	case ')':
+		p.pos++
+		p.token.kind = tokenRight
+		return nil
+	case '"':
+		value, err := p.readQuoted()
+		if err != nil {
+			return err
+		}
+		p.token = queryToken{kind: tokenTerm, pos: start, op: ":", value: value, quoted: true}
+		return nil
+	}
+
+	// A field name followed by an operator starts a field term, anything else is a word
+	for p.pos < len(p.query) && isFieldChar(p.query[p.pos]) {
+		p.pos++
+	}
+	if op := p.readOperator(); op != "" {
+		field := p.query[start : p.pos-len(op)]
+		if field == "" {
+			return p.errorf(start, "missing field name before %s", op)
+		}
+		p.token = queryToken{kind: tokenTerm, pos: start, field: field, op: op}
+		return p.readValue()
+	}
+	p.pos = start
+	word := p.readBare()
+	switch word {
+	case "AND":
+		p.token.kind = tokenAnd
+	case "OR":
+		p.token.kind = tokenOr
+	case "NOT":
+		p.token.kind = tokenNot
+	default:
+		p.token = queryToken{kind: tokenTerm, pos: start, op: ":", value: word}
+	}
+	return nil
+}
+
+func isFieldChar(c byte) bool {
+	return c == '_' || c == '.' || c == '@' || c == '-' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// readOperator reads :, >, >=, < or <= if one comes next
+func (p *queryParser) readOperator() string {
+	for _, op := range []string{">=", "<=", ":", ">", "<"} {
+		if strings.HasPrefix(p.query[p.pos:], op) {
+			p.pos += len(op)
+			return op
+		}
+	}
+	return ""
+}
+
+// readValue reads the value of a field term: quoted, between slashes or bare
+func (p *queryParser) readValue() error {
+	if p.pos == len(p.query) || unicode.IsSpace(rune(p.query[p.pos])) || p.query[p.pos] == ')' {
+		return p.errorf(p.pos, "missing value after %s%s", p.token.field, p.token.op)
+	}
+	var err error
+	switch p.query[p.pos] {
+	case '"':
+		p.token.quoted = true
+		p.token.value, err = p.readQuoted()
+	case '/':
+		p.token.regexp = true
+		p.token.value, err = p.readRegexp()
+	case '(':
+		err = p.errorf(p.pos, "groups of values aren't supported, use (%s%sa OR %s%sb)", p.token.field, p.token.op, p.token.field, p.token.op)
+	default:
+		p.token.value = p.readBare()
+	}
+	return err
+}
+
+// readBare reads up to the next space or parenthesis
+func (p *queryParser) readBare() string {
+	start := p.pos
+	for p.pos < len(p.query) && !unicode.IsSpace(rune(p.query[p.pos])) && p.query[p.pos] != '(' && p.query[p.pos] != ')' {
+		p.pos++
+	}
+	return p.query[start:p.pos]
+}
+
+// readQuoted reads a string in double quotes, where \" and \\ are escapes
+func (p *queryParser) readQuoted() (string, error) {
+	start := p.pos
+	var value strings.Builder
+	for p.pos++; p.pos < len(p.query); p.pos++ {
+		switch c := p.query[p.pos]; c {
+		case '"':
+			p.pos++
+			return value.String(), nil
+		case '\\':
+			if p.pos+1 < len(p.query) {
+				p.pos++
+				c = p.query[p.pos]
+			}
+			value.WriteByte(c)
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return "", p.errorf(start, "missing closing \" for this string")
+}
+
+// readRegexp reads a pattern between slashes, where \/ is a slash
+func (p *queryParser) readRegexp() (string, error) {
+	start := p.pos
+	var value strings.Builder
+	for p.pos++; p.pos < len(p.query); p.pos++ {
+		switch c := p.query[p.pos]; {
+		case c == '/':
+			p.pos++
+			return value.String(), nil
+		case c == '\\' && p.pos+1 < len(p.query) && p.query[p.pos+1] == '/':
+			p.pos++
+			value.WriteByte('/')
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return "", p.errorf(start, "missing closing / for this regular expression")
+}
+
+// compileTerm turns a term into a filter. A bare value is a full-text search in the
+// message. Unquoted values with * or ? are wildcards, values between slashes regular
+// expressions; the message is searched by words, other fields must equal the value.
+func (p *queryParser) compileTerm(token queryToken) (store.Filter, error) {
+	field := token.field
+	if alias, ok := queryFields[field]; ok {
+		field = alias
+	}
+	if field == "" {
+		field = "message"
+	}
+	if field == "message_type" || field == "log_level" {
+		token.value = strings.ToUpper(token.value)
+	}
+
+	if token.op != ":" {
+		if textFields[field] {
+			return nil, p.errorf(token.pos, "%s can't be compared with %s, it isn't a number or a time", token.field, token.op)
+		}
+		if timeFields[field] {
+			t, err := p.parseTime(token.value)
+			if err != nil {
+				return nil, p.errorf(token.pos, "%s", err)
+			}
+			return store.Range{Field: field, Op: token.op, Value: t}, nil
+		}
+		number, err := strconv.ParseFloat(token.value, 64)
+		if err != nil {
+			return nil, p.errorf(token.pos, "%s%s needs a number, not %q", token.field, token.op, token.value)
+		}
+		return store.Range{Field: field, Op: token.op, Value: number}, nil
+	}
+
+	switch {
+	case token.regexp:
+		pattern, err := regexp.Compile(token.value)
+		if err != nil {
+			return nil, p.errorf(token.pos, "invalid regular expression: %s", err)
+		}
+		return store.Regexp{Field: field, Pattern: pattern}, nil
+	case !token.quoted && strings.ContainsAny(token.value, "*?"):
+		return store.Wildcard{Field: field, Pattern: token.value}, nil
+	case timeFields[field]:
+		return nil, p.errorf(token.pos, "compare %s with >, >=, < or <=", token.field)
+	case field == "message":
+		if len(store.Words(token.value)) == 0 {
+			return nil, p.errorf(token.pos, "%q has no words to search for", token.value)
+		}
+		return store.Text{Field: field, Query: token.value}, nil
+	case field == "node_id":
+		id, err := checkNodeID(token.value)
+		if err != nil {
+			return nil, p.errorf(token.pos, "%s", err)
+		}
+		return store.Term{Field: field, Values: []string{id}}, nil
+	case field == "message_type":
+		if _, err := checkMessageType(token.value); err != nil {
+			return nil, p.errorf(token.pos, "%s", err)
+		}
+	}
+	return store.Term{Field: field, Values: []string{token.value}}, nil
+}
+
+// parseTime parses the time of a range: now, now-1h, now+1h, or any --since value
+func (p *queryParser) parseTime(value string) (time.Time, error) {
+	if rest, ok := strings.CutPrefix(value, "now"); ok && rest != "" {
+		d, err := parseDuration(rest[1:])
+		if err != nil || (rest[0] != '-' && rest[0] != '+') {
+			return time.Time{}, fmt.Errorf("invalid relative time %q, use now-15m or now+1h", value)
+		}
+		if rest[0] == '-' {
+			d = -d
+		}
+		return p.now.Add(d), nil
+	}
+	return ParseTime(value, p.now)
+}
+
+// ExplainQuery prints the filter and time range of q, and the Elasticsearch search
+// request it becomes, without running it
+func ExplainQuery(out io.Writer, q store.Query) error {
+	filter := "*"
+	if q.Filter != nil {
+		filter = fmt.Sprint(q.Filter)
+	}
+	fmt.Fprintf(out, "Filter: %s\n", filter)
+	from, to := "-", "-"
+	if !q.From.IsZero() {
+		from = q.From.Format(time.RFC3339Nano)
+	}
+	if !q.To.IsZero() {
+		to = q.To.Format(time.RFC3339Nano)
+	}
+	fmt.Fprintf(out, "Time range: %s to %s\n", from, to)
+
+	data, err := json.MarshalIndent(store.ElasticSearchBody(q), "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Elasticsearch request:\n%s\n", data)
+	return nil
+}
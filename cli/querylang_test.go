@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"level:error", `log_level:"ERROR"`},
+		{"timeout", `message has words "timeout"`},
+		{`"disk full"`, `message has words "disk full"`},
+		{"service:cache*", "service_name:cache*"},
+		{`service:"cache*"`, `service_name:"cache*"`},
+		{`message:/time(d )?out/`, "message:/time(d )?out/"},
+		{"node:03", `node_id:"3"`},
+		{"type:log", `message_type:"LOG"`},
+		{"response_time_ms>=250", "response_time_ms>=250"},
+		{"@timestamp>now-1h", "@timestamp>2026-10-19T11:00:00Z"},
+		{"time<=now+5m", "@timestamp<=2026-10-19T12:05:00Z"},
+		{"level:ERROR service:cache", `log_level:"ERROR" AND service_name:"cache"`},
+		{"a OR b c", `message has words "a" OR (message has words "b" AND message has words "c")`},
+		{"(a OR b) c", `(message has words "a" OR message has words "b") AND message has words "c"`},
+		{"NOT level:INFO AND NOT NOT x", `NOT log_level:"INFO" AND NOT NOT message has words "x"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			filter, err := ParseQuery(tt.query, now)
+			if err != nil {
+				t.Fatalf("ParseQuery: %v", err)
+			}
+			if got := fmt.Sprint(filter); got != tt.want {
+				t.Errorf("filter = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseQuerySyntaxErrors(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		query string
+		pos   int
+		msg   string
+	}{
+		{"", 0, "empty query"},
+		{"   ", 3, "empty query"},
+		{"level:", 6, "missing value after level:"},
+		{"level: ERROR", 6, "missing value after level:"},
+		{":ERROR", 0, "missing field name before :"},
+		{"(level:ERROR", 0, "missing ) for this ("},
+		{"level:ERROR)", 11, "unexpected )"},
+		{"()", 1, "empty parentheses"},
+		{"level:ERROR AND", 15, "expected a term, NOT or ( but found end of query"},
+		{"OR level:ERROR", 0, "expected a term, NOT or ( but found OR"},
+		{`message:"timeout`, 8, `missing closing " for this string`},
+		{"message:/time", 8, "missing closing / for this regular expression"},
+		{"message:/(/", 0, "invalid regular expression: error parsing regexp: missing closing ): `(`"},
+		{"service:(a b)", 8, "groups of values aren't supported, use (service:a OR service:b)"},
+		{"node:x", 0, `invalid node ID "x", node IDs are positive numbers`},
+		{"type:metric", 0, `invalid message type "METRIC", use LOG, HEARTBEAT, REGISTRATION`},
+		{"response_time_ms>slow", 0, `response_time_ms> needs a number, not "slow"`},
+		{"message>5", 0, "message can't be compared with >, it isn't a number or a time"},
+		{"level<=WARN", 0, "level can't be compared with <=, it isn't a number or a time"},
+		{"a service>=x", 2, "service can't be compared with >=, it isn't a number or a time"},
+		{"time:now", 0, "compare time with >, >=, < or <="},
+		{"@timestamp>now*2", 0, `invalid relative time "now*2", use now-15m or now+1h`},
+		{`"--"`, 0, `"--" has no words to search for`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := ParseQuery(tt.query, now)
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("error = %v, want a syntax error", err)
+			}
+			if syntaxErr.Msg != tt.msg || syntaxErr.Pos != tt.pos {
+				t.Errorf("error = %q at %d, want %q at %d", syntaxErr.Msg, syntaxErr.Pos, tt.msg, tt.pos)
+			}
+		})
+	}
+}
+
+func TestSyntaxErrorCaret(t *testing.T) {
+	err := &SyntaxError{Query: "level:ERROR)", Pos: 11, Msg: "unexpected )"}
+	want := "invalid query: unexpected ) at column 12\n  level:ERROR)\n             ^"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
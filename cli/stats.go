@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/store"
+)
+
+// statsGroups maps the groups of the stats command to the fields they count by
+var statsGroups = map[string]string{
+	"level":        "log_level",
+	"service":      "service_name",
+	"node":         "node_id",
+	"error-code":   "error_details.error_code",
+	"message-type": "message_type",
+}
+
+// defaultStatsGroups are the groups shown without --by, in order
+var defaultStatsGroups = []string{"level", "service", "node", "error-code"}
+
+// histogramIntervals are the intervals the stats command picks from for its histogram
+var histogramIntervals = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 10 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+	time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour, 24 * time.Hour, 7 * 24 * time.Hour,
+}
+
+// histogramBuckets is how many intervals an automatic histogram aims for
+const histogramBuckets = 60
+
+// maxHistogramBuckets is the most intervals a histogram may have
+const maxHistogramBuckets = 1000
+
+// barWidth is the width of the longest bar of a bar chart
+const barWidth = 40
+
+// sparks are the bars of a sparkline, from the lowest to the highest
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// StatsOptions selects what the stats command reports
+type StatsOptions struct {
+	Groups       []string      // names from statsGroups
+	Interval     time.Duration // histogram interval, 0 to pick one
+	Top          int           // groups and error messages to show
+	LatencyField string
+	Percents     []float64
+}
+
+// StatsGroup counts the logs by the values of one field
+type StatsGroup struct {
+	Name    string         `json:"name"`
+	Field   string         `json:"field"`
+	Buckets []store.Bucket `json:"buckets"`
+	Other   int            `json:"other"` // logs in smaller groups or without the field
+}
+
+// Stats is what the stats command reports
+type Stats struct {
+	From      time.Time          `json:"from"`
+	To        time.Time          `json:"to"`
+	Total     int                `json:"total"`
+	Interval  string             `json:"interval"`
+	Histogram []store.TimeBucket `json:"histogram"`
+	Groups    []StatsGroup       `json:"groups"`
+	Latency   struct {
+		Field    string             `json:"field"`
+		Percents []float64          `json:"percents"`
+		Values   store.Distribution `json:"values"`
+	} `json:"latency"`
+	TopErrors []store.Bucket `json:"top_errors"`
+}
+
+// HistogramInterval picks the smallest of histogramIntervals giving at most
+// histogramBuckets intervals over [from, to)
+func HistogramInterval(from time.Time, to time.Time) time.Duration {
+	span := to.Sub(from)
+	for _, interval := range histogramIntervals {
+		if span/interval <= histogramBuckets {
+			return interval
+		}
+	}
+	return histogramIntervals[len(histogramIntervals)-1]
+}
+
+// ParsePercents parses percentiles such as 50 or 99.9
+func ParsePercents(values []string) ([]float64, error) {
+	var percents []float64
+	for _, value := range values {
+		percent, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return nil, fmt.Errorf("invalid percentile %q, use a number from 0 to 100", value)
+		}
+		percents = append(percents, percent)
+	}
+	return percents, nil
+}
+
+// CollectStats runs the aggregations of the stats command over the logs matching q,
+// which must have both bounds set
+func CollectStats(logs store.LogStore, q store.Query, opts StatsOptions) (*Stats, error) {
+	stats := &Stats{From: q.From, To: q.To}
+	count := q
+	count.Size = 0
+	result, err := logs.Query(count)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count logs: %w", err)
+	}
+	stats.Total = result.Total
+
+	interval := opts.Interval
+	if interval == 0 {
+		interval = HistogramInterval(q.From, q.To)
+	}
+	if q.To.Sub(q.From)/interval > maxHistogramBuckets {
+		return nil, fmt.Errorf("--interval %s gives more than %d bars, use a longer one", interval, maxHistogramBuckets)
+	}
+	stats.Interval = formatInterval(interval)
+	if stats.Histogram, err = logs.Histogram(q, interval); err != nil {
+		return nil, fmt.Errorf("failed to build histogram: %w", err)
+	}
+
+	for _, name := range opts.Groups {
+		group := StatsGroup{Name: name, Field: statsGroups[name]}
+		if group.Buckets, err = logs.Aggregate(q, group.Field, opts.Top); err != nil {
+			return nil, fmt.Errorf("failed to count logs by %s: %w", name, err)
+		}
+		group.Other = stats.Total
+		for _, bucket := range group.Buckets {
+			group.Other -= bucket.Count
+		}
+		stats.Groups = append(stats.Groups, group)
+	}
+
+	stats.Latency.Field, stats.Latency.Percents = opts.LatencyField, opts.Percents
+	if stats.Latency.Values, err = logs.Distribution(q, opts.LatencyField, opts.Percents); err != nil {
+		return nil, fmt.Errorf("failed to compute %s percentiles: %w", opts.LatencyField, err)
+	}
+
+	errors := q
+	errors.Filter = store.And{q.Filter, store.Term{Field: "log_level", Values: []string{"ERROR"}}}
+	if q.Filter == nil {
+		errors.Filter = store.Term{Field: "log_level", Values: []string{"ERROR"}}
+	}
+	if stats.TopErrors, err = logs.Aggregate(errors, "error_details.error_message", opts.Top); err != nil {
+		return nil, fmt.Errorf("failed to count error messages: %w", err)
+	}
+	return stats, nil
+}
+
+// PrintStats writes stats as JSON, or for people with the histogram as a sparkline,
+// or as one bar per interval when bars is set
+func PrintStats(out io.Writer, stats *Stats, format string, bars bool) error {
+	if format == OutputJSON {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(stats)
+	}
+
+	fmt.Fprintf(out, "%d logs from %s to %s\n", stats.Total,
+		stats.From.Local().Format("2006-01-02 15:04:05"), stats.To.Local().Format("2006-01-02 15:04:05"))
+	if stats.Total == 0 {
+		return nil
+	}
+
+	peak := 0
+	for _, bucket := range stats.Histogram {
+		peak = max(peak, bucket.Count)
+	}
+	fmt.Fprintf(out, "\n%s\n", detailColor(fmt.Sprintf("Logs per %s (peak %d)", stats.Interval, peak)))
+	if bars {
+		rows := make([]barRow, len(stats.Histogram))
+		for i, bucket := range stats.Histogram {
+			rows[i] = barRow{label: bucket.Start.Local().Format("2006-01-02 15:04:05"), count: bucket.Count}
+		}
+		printBars(out, rows, 0)
+	} else if len(stats.Histogram) > 0 {
+		counts := make([]int, len(stats.Histogram))
+		for i, bucket := range stats.Histogram {
+			counts[i] = bucket.Count
+		}
+		fmt.Fprintf(out, "  %s\n", sparkline(counts))
+		first, last := stats.Histogram[0].Start, stats.Histogram[len(stats.Histogram)-1].Start
+		fmt.Fprintf(out, "  %s … %s\n", first.Local().Format("2006-01-02 15:04"), last.Local().Format("2006-01-02 15:04"))
+	}
+
+	for _, group := range stats.Groups {
+		fmt.Fprintf(out, "\n%s\n", detailColor("By "+group.Name))
+		rows := make([]barRow, 0, len(group.Buckets)+1)
+		for _, bucket := range group.Buckets {
+			rows = append(rows, barRow{label: bucket.Key, count: bucket.Count})
+		}
+		if group.Other > 0 {
+			rows = append(rows, barRow{label: "(other or none)", count: group.Other})
+		}
+		printBars(out, rows, stats.Total)
+	}
+
+	latency := stats.Latency
+	fmt.Fprintf(out, "\n%s\n", detailColor("Latency ("+latency.Field+")"))
+	if latency.Values.Count == 0 {
+		fmt.Fprintf(out, "  no values\n")
+	} else {
+		fmt.Fprintf(out, "  %d values, min %s, avg %s, max %s\n", latency.Values.Count,
+			formatNumber(latency.Values.Min), formatNumber(latency.Values.Avg), formatNumber(latency.Values.Max))
+		var percentiles []string
+		for i, value := range latency.Values.Percentiles {
+			percentiles = append(percentiles, fmt.Sprintf("p%s %s", formatNumber(latency.Percents[i]), formatNumber(value)))
+		}
+		if len(percentiles) > 0 {
+			fmt.Fprintf(out, "  %s\n", strings.Join(percentiles, ", "))
+		}
+	}
+
+	fmt.Fprintf(out, "\n%s\n", detailColor("Top error messages"))
+	if len(stats.TopErrors) == 0 {
+		fmt.Fprintf(out, "  none\n")
+	}
+	width := 0
+	for _, bucket := range stats.TopErrors {
+		width = max(width, len(strconv.Itoa(bucket.Count)))
+	}
+	for _, bucket := range stats.TopErrors {
+		fmt.Fprintf(out, "  %*d  %s\n", width, bucket.Count, bucket.Key)
+	}
+	return nil
+}
+
+// barRow is one line of a bar chart
+type barRow struct {
+	label string
+	count int
+}
+
+// printBars draws a horizontal bar chart scaled to its largest row; with a total,
+// every row also shows its share of it
+func printBars(out io.Writer, rows []barRow, total int) {
+	labelWidth, countWidth, peak := 0, 0, 0
+	for _, row := range rows {
+		labelWidth = max(labelWidth, len([]rune(row.label)))
+		countWidth = max(countWidth, len(strconv.Itoa(row.count)))
+		peak = max(peak, row.count)
+	}
+	for _, row := range rows {
+		share := ""
+		if total > 0 {
+			share = fmt.Sprintf("  %5.1f%%", 100*float64(row.count)/float64(total))
+		}
+		bar := ""
+		if peak > 0 {
+			bar = strings.Repeat("█", int(math.Ceil(float64(barWidth*row.count)/float64(peak))))
+		}
+		line := fmt.Sprintf("  %-*s  %*d%s  %s", labelWidth, row.label, countWidth, row.count, share, bar)
+		fmt.Fprintln(out, strings.TrimRight(line, " "))
+	}
+}
+
+// sparkline draws counts as one bar character each, scaled to the largest; any
+// count above zero is taller than an empty interval
+func sparkline(counts []int) string {
+	peak := 0
+	for _, count := range counts {
+		peak = max(peak, count)
+	}
+	line := make([]rune, len(counts))
+	for i, count := range counts {
+		level := 0
+		if peak > 0 {
+			level = int(math.Ceil(float64(count) / float64(peak) * float64(len(sparks)-1)))
+		}
+		line[i] = sparks[level]
+	}
+	return string(line)
+}
+
+// formatInterval formats a duration without its zero minutes and seconds, 5m rather
+// than 5m0s
+func formatInterval(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// formatNumber formats a number with at most two decimals
+func formatNumber(value float64) string {
+	return strconv.FormatFloat(math.Round(value*100)/100, 'f', -1, 64)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// TailFilter is the server-side filter of a tail stream
+type TailFilter struct {
+	Levels   []string
+	Services []string
+	Nodes    []string
+	Contains string
+	TraceID  string
+}
+
+// TailLogs streams logs from the server's /tail endpoint and prints them until the
+// connection closes or the process is interrupted; token is the server's API token, if any
+func TailLogs(server string, token string, printer *Printer, filter TailFilter) error {
+	defer printer.Close()
+	query := url.Values{}
+	for _, level := range filter.Levels {
+		query.Add("level", level)
+	}
+	for _, service := range filter.Services {
+		query.Add("service", service)
+	}
+	for _, node := range filter.Nodes {
+		query.Add("node", node)
+	}
+	if filter.Contains != "" {
+		query.Set("contains", filter.Contains)
+	}
+	if filter.TraceID != "" {
+		query.Set("trace_id", filter.TraceID)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(server, "/")+"/tail?"+query.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("invalid server address: %w", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to connect to server: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		var body strings.Builder
+		bufio.NewReader(res.Body).WriteTo(&body)
+		return fmt.Errorf("server returned %s: %s", res.Status, strings.TrimSpace(body.String()))
+	}
+
+	// Read the Server-Sent Events one line at a time
+	event := ""
+	scanner := bufio.NewScanner(res.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			event = ""
+		case strings.HasPrefix(line, ":"):
+			// Comment / keep-alive
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			switch event {
+			case "dropped":
+				var notice struct {
+					Dropped int `json:"dropped"`
+				}
+				json.Unmarshal([]byte(data), &notice)
+				fmt.Fprintf(os.Stderr, "... %d logs dropped, the terminal is not keeping up ...\n", notice.Dropped)
+			default:
+				var logData map[string]interface{}
+				if err := json.Unmarshal([]byte(data), &logData); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to decode log: %v\n", err)
+					continue
+				}
+				printer.Print(logData)
+				printer.Flush()
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("stream interrupted: %w", err)
+	}
+	return fmt.Errorf("server closed the stream")
+}
@@ -0,0 +1,778 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"example.com/store"
+
+	"github.com/fatih/color"
+	"github.com/gdamore/tcell/v2"
+	"github.com/mattn/go-runewidth"
+)
+
+// tuiPageSize is how many logs the TUI loads at once, and shows around a log
+const tuiPageSize = 500
+
+// tuiMaxLogs is the most logs the TUI keeps; the farthest ones are dropped
+const tuiMaxLogs = 5000
+
+// tuiNodesInterval is how often the node panel asks the server for the registry
+const tuiNodesInterval = 5 * time.Second
+
+// tuiNodesWidth is the width of the node panel
+const tuiNodesWidth = 38
+
+// tuiHelp lists the keys of the TUI
+var tuiHelp = []string{
+	"↑ ↓ j k      select a log; going up past the first loads older logs",
+	"PgUp PgDn    move a page",
+	"Home End g G first and last log; End also resumes the live tail",
+	"/            edit the filter (query language); Enter applies, Esc cancels",
+	"l space      pause or resume the live tail",
+	"c            logs around the selected one, from every service",
+	"s            logs around the selected one, from its service",
+	"o            logs around the selected one, from its node",
+	"Esc          back to the filtered logs",
+	"[ ]          scroll the detail pane",
+	"n            show or hide the node panel",
+	"r            reload",
+	"?            show or hide this help",
+	"q Ctrl-C     quit",
+}
+
+// nodeStatus is a node as the server's GET /nodes reports it
+type nodeStatus struct {
+	NodeID        int       `json:"node_id"`
+	ServiceName   string    `json:"service_name"`
+	State         string    `json:"state"`
+	StateSince    time.Time `json:"state_since"`
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+	Flapping      bool      `json:"flapping"`
+}
+
+// tuiNodes carries a refresh of the node panel to the event loop
+type tuiNodes struct {
+	nodes []nodeStatus
+	err   error
+}
+
+// tuiTick asks the event loop to poll for new logs
+type tuiTick struct{}
+
+// TUI is the state of the interactive log browser. Only the event loop touches it;
+// background goroutines post events to the screen instead.
+type TUI struct {
+	screen    tcell.Screen
+	logs      store.LogStore
+	refresher store.Refresher
+	base      store.Query // what the command line flags select
+	server    string      // server API for the node panel, empty for none
+	token     string      // API token of the server, if it requires one
+	interval  time.Duration
+
+	filterText string
+	filter     store.Filter // the filter bar, compiled
+	editing    bool
+	input      string // the filter bar while editing
+
+	hits     []store.Hit // oldest first
+	seen     map[string]bool
+	loadedAt time.Time // when the list was loaded, where the live tail starts if it was empty
+	selected int
+	top      int // first hit shown in the list
+	detail   int // first line shown in the detail pane
+	live     bool
+	around   string // what the list shows around a log, empty for the filtered logs
+	noOlder  bool   // the oldest matching log is loaded
+
+	showNodes bool
+	showHelp  bool
+	nodes     []nodeStatus
+	nodesErr  error
+	message   string
+}
+
+// RunTUI runs the log browser on screen until the user quits. base holds the filter
+// and time range of the command line flags; the node panel polls server, with token if
+// set, unless server is empty.
+func RunTUI(screen tcell.Screen, logs store.LogStore, base store.Query, server string, token string, interval time.Duration) error {
+	if err := screen.Init(); err != nil {
+		return fmt.Errorf("failed to start the terminal UI: %w", err)
+	}
+	defer screen.Fini()
+	// Lines are styled by the TUI, not by escape codes
+	noColor := color.NoColor
+	color.NoColor = true
+	defer func() { color.NoColor = noColor }()
+
+	refresher, _ := logs.(store.Refresher)
+	t := &TUI{
+		screen:    screen,
+		logs:      logs,
+		refresher: refresher,
+		base:      base,
+		server:    server,
+		token:     token,
+		interval:  interval,
+		live:      base.To.IsZero(),
+		showNodes: server != "",
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go t.tick(ctx)
+	if server != "" {
+		go t.pollNodes(ctx)
+	}
+
+	t.load()
+	for {
+		t.draw()
+		switch ev := screen.PollEvent().(type) {
+		case nil:
+			return nil
+		case *tcell.EventResize:
+			screen.Sync()
+		case *tcell.EventInterrupt:
+			switch data := ev.Data().(type) {
+			case tuiTick:
+				if t.live && t.around == "" {
+					t.pollNew()
+				}
+			case tuiNodes:
+				t.nodes, t.nodesErr = data.nodes, data.err
+			}
+		case *tcell.EventKey:
+			if !t.handleKey(ev) {
+				return nil
+			}
+		}
+	}
+}
+
+// tick asks for a poll every interval
+func (t *TUI) tick(ctx context.Context) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.screen.PostEvent(tcell.NewEventInterrupt(tuiTick{}))
+		}
+	}
+}
+
+// pollNodes fetches the registry from the server every tuiNodesInterval
+func (t *TUI) pollNodes(ctx context.Context) {
+	client := &http.Client{Timeout: 2 * time.Second}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(t.server, "/")+"/nodes", nil)
+	if err != nil {
+		t.screen.PostEvent(tcell.NewEventInterrupt(tuiNodes{err: fmt.Errorf("invalid server address: %w", err)}))
+		return
+	}
+	if t.token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	}
+	for {
+		var response struct {
+			Nodes []nodeStatus `json:"nodes"`
+		}
+		res, err := client.Do(req)
+		if err == nil {
+			if res.StatusCode != http.StatusOK {
+				err = fmt.Errorf("server answered %s", res.Status)
+			} else {
+				err = json.NewDecoder(res.Body).Decode(&response)
+			}
+			res.Body.Close()
+		}
+		t.screen.PostEvent(tcell.NewEventInterrupt(tuiNodes{nodes: response.Nodes, err: err}))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(tuiNodesInterval):
+		}
+	}
+}
+
+// query returns what the list shows: the flags and the filter bar
+func (t *TUI) query() store.Query {
+	q := t.base
+	if t.filter != nil {
+		if q.Filter == nil {
+			q.Filter = t.filter
+		} else {
+			q.Filter = store.And{q.Filter, t.filter}
+		}
+	}
+	return q
+}
+
+// load replaces the list with the latest matching logs
+func (t *TUI) load() {
+	q := t.query()
+	q.Sort, q.Size = store.SortNewest, tuiPageSize
+	if t.refresher != nil {
+		t.refresher.Refresh()
+	}
+	t.loadedAt = time.Now()
+	result, err := t.logs.Query(q)
+	if err != nil {
+		t.message = fmt.Sprintf("Failed to retrieve logs: %v", err)
+		return
+	}
+	t.around, t.noOlder = "", len(result.Hits) < tuiPageSize
+	t.setHits(reversed(result.Hits), len(result.Hits)-1)
+	t.message = ""
+}
+
+// loadOlder adds the page of logs before the first one shown
+func (t *TUI) loadOlder() {
+	if len(t.hits) == 0 || t.noOlder || t.around != "" {
+		return
+	}
+	q := t.query()
+	first := store.PositionOf(t.hits[0])
+	q.Sort, q.Size, q.After = store.SortNewest, tuiPageSize, &first
+	result, err := t.logs.Query(q)
+	if err != nil {
+		t.message = fmt.Sprintf("Failed to retrieve logs: %v", err)
+		return
+	}
+	t.noOlder = len(result.Hits) < tuiPageSize
+	older := reversed(result.Hits)
+	hits := append(older, t.hits...)
+	if len(hits) > tuiMaxLogs {
+		// Keep the older end, where the user is looking
+		hits = hits[:tuiMaxLogs]
+		t.live = false
+	}
+	t.setHits(hits, t.selected+len(older))
+	t.top += len(older)
+}
+
+// pollNew adds the logs that arrived since the last poll, as follow mode does
+func (t *TUI) pollNew() {
+	// Logs before the oldest one listed are loaded by scrolling, not by the live tail
+	floor := followFloor(t.hits, t.loadedAt)
+	newest := t.loadedAt
+	if len(t.hits) > 0 {
+		newest = store.PositionOf(t.hits[len(t.hits)-1]).Time
+	}
+
+	var added []store.Hit
+	err := pollLogs(context.Background(), t.logs, t.refresher, followPoll(t.query(), floor, newest), func(hit store.Hit) {
+		if !t.seen[hit.ID] && afterFloor(hit, floor) {
+			added = append(added, hit)
+		}
+	})
+	if err != nil {
+		t.message = fmt.Sprintf("Lost the log store, retrying: %v", err)
+		return
+	}
+	if strings.HasPrefix(t.message, "Lost the log store") {
+		t.message = ""
+	}
+	if len(added) == 0 {
+		return
+	}
+
+	atBottom := t.selected == len(t.hits)-1
+	selectedID := ""
+	if t.selected >= 0 && t.selected < len(t.hits) {
+		selectedID = t.hits[t.selected].ID
+	}
+	hits := append(t.hits, added...)
+	// Late logs belong before some of those shown
+	sort.SliceStable(hits, func(i, j int) bool {
+		a, b := store.PositionOf(hits[i]), store.PositionOf(hits[j])
+		if !a.Time.Equal(b.Time) {
+			return a.Time.Before(b.Time)
+		}
+		return a.ID < b.ID
+	})
+	if len(hits) > tuiMaxLogs {
+		hits = hits[len(hits)-tuiMaxLogs:]
+		t.noOlder = false
+	}
+	selected := len(hits) - 1
+	if !atBottom {
+		for i, hit := range hits {
+			if hit.ID == selectedID {
+				selected = i
+			}
+		}
+	}
+	t.setHits(hits, selected)
+}
+
+// showAround replaces the list with the logs around the selected one that match
+// filter, pausing the live tail
+func (t *TUI) showAround(filter store.Filter, label string) {
+	if len(t.hits) == 0 {
+		return
+	}
+	hit := t.hits[t.selected]
+	position := store.PositionOf(hit)
+	before := store.Query{Filter: filter, Sort: store.SortNewest, After: &position, Size: tuiPageSize / 2}
+	after := store.Query{Filter: filter, Sort: store.SortOldest, After: &position, Size: tuiPageSize / 2}
+	older, err := t.logs.Query(before)
+	if err != nil {
+		t.message = fmt.Sprintf("Failed to retrieve logs: %v", err)
+		return
+	}
+	newer, err := t.logs.Query(after)
+	if err != nil {
+		t.message = fmt.Sprintf("Failed to retrieve logs: %v", err)
+		return
+	}
+
+	hits := reversed(older.Hits)
+	selected := len(hits)
+	hits = append(hits, hit)
+	hits = append(hits, newer.Hits...)
+	t.live = false
+	t.around = fmt.Sprintf("around %s, %s", formatTUITime(hit.Doc), label)
+	t.setHits(hits, selected)
+	t.top = max(0, selected-t.listHeight()/2)
+}
+
+// setHits replaces the list and selects one of its logs
+func (t *TUI) setHits(hits []store.Hit, selected int) {
+	t.hits = hits
+	t.seen = make(map[string]bool, len(hits))
+	for _, hit := range hits {
+		t.seen[hit.ID] = true
+	}
+	t.selected = min(max(selected, 0), len(hits)-1)
+	t.detail = 0
+}
+
+// handleKey reacts to a key and tells whether to go on
+func (t *TUI) handleKey(ev *tcell.EventKey) bool {
+	if ev.Key() == tcell.KeyCtrlC {
+		return false
+	}
+	if t.editing {
+		t.editFilter(ev)
+		return true
+	}
+	if t.showHelp {
+		t.showHelp = false
+		return true
+	}
+
+	page := max(t.listHeight()-1, 1)
+	switch ev.Key() {
+	case tcell.KeyUp:
+		t.move(-1)
+	case tcell.KeyDown:
+		t.move(1)
+	case tcell.KeyPgUp:
+		t.move(-page)
+	case tcell.KeyPgDn:
+		t.move(page)
+	case tcell.KeyHome:
+		t.move(-len(t.hits))
+	case tcell.KeyEnd:
+		t.jumpToEnd()
+	case tcell.KeyEscape:
+		if t.around != "" {
+			t.live = t.base.To.IsZero()
+			t.load()
+		}
+	case tcell.KeyRune:
+		switch ev.Rune() {
+		case 'q':
+			return false
+		case 'k':
+			t.move(-1)
+		case 'j':
+			t.move(1)
+		case 'g':
+			t.move(-len(t.hits))
+		case 'G':
+			t.jumpToEnd()
+		case '/':
+			t.editing, t.input = true, t.filterText
+		case 'l', ' ':
+			t.toggleLive()
+		case 'c':
+			t.showAround(nil, "every service")
+		case 's':
+			if hit, ok := t.current(); ok {
+				service := hit.Doc.String("service_name")
+				t.showAround(store.Term{Field: "service_name", Values: []string{service}}, "service "+service)
+			}
+		case 'o':
+			if hit, ok := t.current(); ok {
+				node := hit.Doc.String("node_id")
+				t.showAround(store.Term{Field: "node_id", Values: []string{node}}, "node "+node)
+			}
+		case '[':
+			t.detail = max(t.detail-1, 0)
+		case ']':
+			t.detail++
+		case 'n':
+			t.showNodes = !t.showNodes && t.server != ""
+		case 'r':
+			t.load()
+		case '?':
+			t.showHelp = true
+		}
+	}
+	return true
+}
+
+// editFilter handles a key while the filter bar is being edited
+func (t *TUI) editFilter(ev *tcell.EventKey) {
+	switch ev.Key() {
+	case tcell.KeyEscape:
+		t.editing, t.message = false, ""
+	case tcell.KeyEnter:
+		var filter store.Filter
+		if strings.TrimSpace(t.input) != "" {
+			var err error
+			if filter, err = ParseQuery(t.input, time.Now()); err != nil {
+				// The first line of a syntax error says what and where
+				t.message, _, _ = strings.Cut(err.Error(), "\n")
+				return
+			}
+		}
+		t.editing = false
+		t.filterText, t.filter = t.input, filter
+		t.load()
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if t.input != "" {
+			runes := []rune(t.input)
+			t.input = string(runes[:len(runes)-1])
+		}
+	case tcell.KeyCtrlU:
+		t.input = ""
+	case tcell.KeyRune:
+		t.input += string(ev.Rune())
+	}
+}
+
+// move changes the selection by delta logs, loading older ones past the first
+func (t *TUI) move(delta int) {
+	if len(t.hits) == 0 {
+		return
+	}
+	if t.selected+delta < 0 && delta > -len(t.hits) {
+		t.loadOlder()
+	}
+	t.selected = min(max(t.selected+delta, 0), len(t.hits)-1)
+	t.detail = 0
+}
+
+// jumpToEnd selects the newest log and resumes the live tail
+func (t *TUI) jumpToEnd() {
+	if t.around == "" && t.base.To.IsZero() {
+		t.live = true
+	}
+	t.move(len(t.hits))
+}
+
+func (t *TUI) toggleLive() {
+	switch {
+	case t.around != "":
+		t.message = "Press Esc to leave the logs around a log before following new ones"
+	case !t.base.To.IsZero():
+		t.message = "The live tail doesn't apply with --until"
+	default:
+		t.live = !t.live
+	}
+}
+
+// current returns the selected log
+func (t *TUI) current() (store.Hit, bool) {
+	if len(t.hits) == 0 {
+		return store.Hit{}, false
+	}
+	return t.hits[t.selected], true
+}
+
+// listHeight is the number of rows of the log list: what the two bars at the top,
+// the detail pane and the key bar leave
+func (t *TUI) listHeight() int {
+	_, height := t.screen.Size()
+	return max(height-3-t.detailHeight(), 1)
+}
+
+func (t *TUI) detailHeight() int {
+	_, height := t.screen.Size()
+	return max(height/3, 3)
+}
+
+// Styles of the TUI
+var (
+	tuiBarStyle      = tcell.StyleDefault.Reverse(true)
+	tuiTimeStyle     = tcell.StyleDefault.Foreground(tcell.ColorGray)
+	tuiErrorStyle    = tcell.StyleDefault.Foreground(tcell.ColorRed).Bold(true)
+	tuiWarnStyle     = tcell.StyleDefault.Foreground(tcell.ColorYellow)
+	tuiInfoStyle     = tcell.StyleDefault.Foreground(tcell.ColorGreen)
+	tuiOtherStyle    = tcell.StyleDefault.Foreground(tcell.ColorDarkCyan)
+	tuiTitleStyle    = tcell.StyleDefault.Bold(true)
+	tuiSelectedStyle = tcell.StyleDefault.Reverse(true)
+)
+
+// nodeStateStyles color the states of the node panel
+var nodeStateStyles = map[string]tcell.Style{
+	"UP":           tuiInfoStyle,
+	"RECOVERED":    tuiInfoStyle,
+	"REGISTERED":   tuiOtherStyle,
+	"SUSPECT":      tuiWarnStyle,
+	"DOWN":         tuiErrorStyle,
+	"DEREGISTERED": tuiTimeStyle,
+}
+
+// draw renders the whole screen
+func (t *TUI) draw() {
+	s := t.screen
+	s.Clear()
+	width, height := s.Size()
+
+	// Status bar
+	state := "PAUSED"
+	if t.live {
+		state = "LIVE"
+	}
+	if t.around != "" {
+		state = t.around
+	}
+	status := fmt.Sprintf(" logctl │ %s │ %d logs │ ? for help", state, len(t.hits))
+	fillRow(s, 0, width, tuiBarStyle)
+	drawText(s, 0, 0, width, tuiBarStyle, status)
+
+	// Filter bar
+	if t.editing {
+		drawText(s, 0, 1, width, tuiTitleStyle, "Filter: "+t.input+"█")
+	} else if t.filterText != "" {
+		drawText(s, 0, 1, width, tcell.StyleDefault, "Filter: "+t.filterText)
+	} else {
+		drawText(s, 0, 1, width, tuiTimeStyle, "Filter: none, press / to set one")
+	}
+
+	// Log list, with the node panel on its right
+	listWidth := width
+	if t.showNodes && width >= tuiNodesWidth+40 {
+		listWidth = width - tuiNodesWidth - 1
+		t.drawNodes(listWidth+1, 2, tuiNodesWidth, t.listHeight())
+	}
+	t.drawList(0, 2, listWidth, t.listHeight())
+
+	// Detail pane
+	y := 2 + t.listHeight()
+	fillRow(s, y, width, tuiBarStyle)
+	title := " Details"
+	if hit, ok := t.current(); ok {
+		title += " │ " + hit.ID
+	}
+	drawText(s, 0, y, width, tuiBarStyle, title)
+	t.drawDetail(0, y+1, width, t.detailHeight()-1)
+
+	// Key bar, or the last message
+	if t.message != "" {
+		drawText(s, 0, height-1, width, tuiWarnStyle, t.message)
+	} else {
+		drawText(s, 0, height-1, width, tuiTimeStyle, "↑↓ select  / filter  l live  c s o around  Esc back  n nodes  ? help  q quit")
+	}
+
+	if t.showHelp {
+		t.drawHelp(width, height)
+	}
+	s.Show()
+}
+
+// drawList draws the logs, scrolled to keep the selected one visible
+func (t *TUI) drawList(x int, y int, width int, height int) {
+	if len(t.hits) == 0 {
+		drawText(t.screen, x, y, width, tuiTimeStyle, "No logs match")
+		return
+	}
+	if t.selected < t.top {
+		t.top = t.selected
+	}
+	if t.selected >= t.top+height {
+		t.top = t.selected - height + 1
+	}
+	t.top = max(min(t.top, len(t.hits)-height), 0)
+
+	for row := 0; row < height && t.top+row < len(t.hits); row++ {
+		i := t.top + row
+		doc := t.hits[i].Doc
+		when, rest := splitTUILine(doc)
+		timeStyle, style := tuiTimeStyle, levelStyle(doc)
+		if i == t.selected {
+			fillRow(t.screen, y+row, x+width, tuiSelectedStyle)
+			timeStyle, style = timeStyle.Reverse(true), style.Reverse(true)
+		}
+		next := drawText(t.screen, x, y+row, width, timeStyle, when+" ")
+		drawText(t.screen, next, y+row, x+width-next, style, rest)
+	}
+}
+
+// drawDetail draws the complete JSON of the selected log
+func (t *TUI) drawDetail(x int, y int, width int, height int) {
+	hit, ok := t.current()
+	if !ok {
+		return
+	}
+	data, err := json.MarshalIndent(hit.Doc, "", "  ")
+	if err != nil {
+		drawText(t.screen, x, y, width, tuiErrorStyle, err.Error())
+		return
+	}
+	lines := strings.Split(string(data), "\n")
+	t.detail = max(min(t.detail, len(lines)-height), 0)
+	for row := 0; row < height && t.detail+row < len(lines); row++ {
+		drawText(t.screen, x, y+row, width, tcell.StyleDefault, lines[t.detail+row])
+	}
+}
+
+// drawNodes draws the node panel
+func (t *TUI) drawNodes(x int, y int, width int, height int) {
+	s := t.screen
+	for row := 0; row < height; row++ {
+		s.SetContent(x-1, y+row, '│', nil, tuiTimeStyle)
+	}
+	drawText(s, x, y, width, tuiTitleStyle, "Nodes")
+	switch {
+	case t.nodesErr != nil:
+		drawText(s, x, y+1, width, tuiErrorStyle, "Server unreachable")
+		drawText(s, x, y+2, width, tuiTimeStyle, t.nodesErr.Error())
+		return
+	case t.nodes == nil:
+		drawText(s, x, y+1, width, tuiTimeStyle, "Loading…")
+		return
+	case len(t.nodes) == 0:
+		drawText(s, x, y+1, width, tuiTimeStyle, "No nodes registered")
+		return
+	}
+	for i, node := range t.nodes {
+		if i+1 >= height {
+			break
+		}
+		line := fmt.Sprintf("%3d %-12.12s", node.NodeID, node.ServiceName)
+		next := drawText(s, x, y+1+i, width, tcell.StyleDefault, line+" ")
+		state := node.State
+		if node.Flapping {
+			state += "~"
+		}
+		next = drawText(s, next, y+1+i, x+width-next, nodeStateStyles[node.State], fmt.Sprintf("%-13s", state))
+		if !node.LastHeartbeat.IsZero() {
+			drawText(s, next, y+1+i, x+width-next, tuiTimeStyle, formatAge(time.Since(node.LastHeartbeat)))
+		}
+	}
+}
+
+// drawHelp draws the key help over the middle of the screen
+func (t *TUI) drawHelp(width int, height int) {
+	boxWidth := 4
+	for _, line := range tuiHelp {
+		boxWidth = max(boxWidth, runewidth.StringWidth(line)+4)
+	}
+	boxWidth = min(boxWidth, width)
+	x := (width - boxWidth) / 2
+	y := max((height-len(tuiHelp)-2)/2, 0)
+	for row := 0; row < len(tuiHelp)+2; row++ {
+		fillRow(t.screen, y+row, x+boxWidth, tcell.StyleDefault.Reverse(true))
+	}
+	for i, line := range tuiHelp {
+		drawText(t.screen, x+2, y+1+i, boxWidth-4, tcell.StyleDefault.Reverse(true), line)
+	}
+}
+
+// splitTUILine renders a log as the text output does, split into its time and the rest
+func splitTUILine(doc store.Document) (string, string) {
+	line := strings.TrimRight(formatText(doc), "\n")
+	when, rest, _ := strings.Cut(line, " ")
+	if when != "-" {
+		// The time layout itself holds a space
+		clock, more, _ := strings.Cut(rest, " ")
+		when, rest = when+" "+clock, more
+	}
+	return when, rest
+}
+
+// formatTUITime formats the event time of a log for the status bar
+func formatTUITime(doc store.Document) string {
+	if t, ok := store.EventTime(doc); ok {
+		return t.Local().Format(textTimeLayout)
+	}
+	return "an undated log"
+}
+
+// levelStyle colors a log line by its level or message type
+func levelStyle(doc store.Document) tcell.Style {
+	switch doc.String("log_level") {
+	case "ERROR":
+		return tuiErrorStyle
+	case "WARN":
+		return tuiWarnStyle
+	case "INFO":
+		return tuiInfoStyle
+	}
+	if doc.String("message_type") != "LOG" {
+		return tuiOtherStyle
+	}
+	return tcell.StyleDefault
+}
+
+// formatAge formats how long ago something happened, in its largest unit
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds ago", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+}
+
+// drawText writes text from (x, y), cut at width cells, and returns the column after it
+func drawText(s tcell.Screen, x int, y int, width int, style tcell.Style, text string) int {
+	end := x + width
+	for _, r := range text {
+		if r == '\t' || r == '\n' {
+			r = ' '
+		}
+		w := runewidth.RuneWidth(r)
+		if w == 0 {
+			continue
+		}
+		if x+w > end {
+			break
+		}
+		s.SetContent(x, y, r, nil, style)
+		x += w
+	}
+	return x
+}
+
+// fillRow paints a row up to column end with the background of style
+func fillRow(s tcell.Screen, y int, end int, style tcell.Style) {
+	for x := 0; x < end; x++ {
+		s.SetContent(x, y, ' ', nil, style)
+	}
+}
+
+// reversed returns hits in the opposite order
+func reversed(hits []store.Hit) []store.Hit {
+	out := make([]store.Hit, len(hits))
+	for i, hit := range hits {
+		out[len(hits)-1-i] = hit
+	}
+	return out
+}
@@ -6,6 +6,7 @@ replace github.com/Shopify/sarama v1.43.3 => github.com/IBM/sarama v1.43.3
 
 require (
 	github.com/IBM/sarama v1.43.3
+	github.com/fluent/fluent-logger-golang v1.9.0
 	github.com/google/uuid v1.6.0
 )
 
@@ -14,7 +15,6 @@ require (
 	github.com/eapache/go-resiliency v1.7.0 // indirect
 	github.com/eapache/go-xerial-snappy v0.0.0-20230731223053-c322873962e3 // indirect
 	github.com/eapache/queue v1.1.0 // indirect
-	github.com/fluent/fluent-logger-golang v1.9.0 // indirect
 	github.com/golang/snappy v0.0.4 // indirect
 	github.com/hashicorp/errwrap v1.0.0 // indirect
 	github.com/hashicorp/go-multierror v1.1.1 // indirect
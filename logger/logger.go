@@ -3,6 +3,7 @@ package logger
 import (
 	"encoding/json"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/IBM/sarama"
@@ -14,6 +15,9 @@ type RegistrationMsg struct {
 	NodeID      int    `json:"node_id"`
 	MessageType string `json:"message_type"`
 	ServiceName string `json:"service_name"`
+	Host        string `json:"host,omitempty"`
+	Version     string `json:"version,omitempty"`
+	Address     string `json:"address,omitempty"`
 	Timestamp   string `json:"timestamp"`
 }
 
@@ -65,6 +69,9 @@ type RegistryMsg struct {
 	NodeID      int    `json:"node_id"`
 	ServiceName string `json:"service_name"`
 	Status      string `json:"status"`
+	Host        string `json:"host,omitempty"`
+	Version     string `json:"version,omitempty"`
+	Address     string `json:"address,omitempty"`
 	Timestamp   string `json:"timestamp"`
 }
 
@@ -72,6 +79,19 @@ var globalBrokers = []string{"localhost:9092"} //change brokerIP here
 var globalTopic = ""
 var globalProducer sarama.SyncProducer = nil
 var globalFluentdLogger *fluent.Fluent = nil
+var globalVersion = ""
+var globalAddress = ""
+
+// SetNodeMetadata sets the version and address sent with registration messages
+func SetNodeMetadata(version string, address string) {
+	globalVersion = version
+	globalAddress = address
+}
+
+func hostname() string {
+	host, _ := os.Hostname()
+	return host
+}
 
 func CHECK(err error) {
 	if err != nil {
@@ -182,6 +202,9 @@ func SendRegistrationMsg(nodeID int, serviceName string) {
 		NodeID:      nodeID,
 		MessageType: "REGISTRATION",
 		ServiceName: serviceName,
+		Host:        hostname(),
+		Version:     globalVersion,
+		Address:     globalAddress,
 		Timestamp:   time.Now().String(),
 	}
 	jsonData, _ := json.Marshal(log)
@@ -275,6 +298,9 @@ func GenerateRegistryMsg(nodeID int, serviceName string, up bool) []byte {
 		NodeID:      nodeID,
 		ServiceName: serviceName,
 		Status:      statusString,
+		Host:        hostname(),
+		Version:     globalVersion,
+		Address:     globalAddress,
 		Timestamp:   time.Now().String(),
 	}
 	jsonData, _ := json.Marshal(registry)
@@ -15,6 +15,10 @@ var dictionary = make(map[int]string)
 var globalNodeID int
 
 const max_key_size = 100_000
+const listenAddress = ":7777"
+
+// version is sent with the registration message; set it with -ldflags "-X main.version=..."
+var version = "dev"
 
 func generateRandomString() string {
 	// Generate a random string of length 10
@@ -40,6 +44,7 @@ func main() {
 	globalNodeID = int(uuid.New().ID())
 
 	log.Println("Starting the origin server with unique ID:", globalNodeID)
+	logger.SetNodeMetadata(version, listenAddress)
 	logger.SendRegistrationMsg(globalNodeID, "origin-server")
 
 	/* Start the heartbeat */
@@ -53,9 +58,9 @@ func main() {
 	}
 	log.Println(dictionary)
 	log.Println("Random strings generated")
-	logger.SendInfoLog(globalNodeID, "origin-server ", "Random strings generated")
+	logger.SendInfoLog(globalNodeID, "origin-server", "Random strings generated")
 
-	pc, err := net.ListenPacket("udp", ":7777") // Listen on port 7777
+	pc, err := net.ListenPacket("udp", listenAddress) // Listen on port 7777
 	log.Println("Listening on port 7777")
 	logger.SendInfoLog(globalNodeID, "origin-server", "Listening on port 7777")
 
@@ -21,6 +21,9 @@ const (
 )
 
 var gloablNodeID int
+
+// version is sent with the registration message; set it with -ldflags "-X main.version=..."
+var version = "dev"
 var cacheServers = []string{}
 
 func populateServers() (error, string) {
@@ -122,6 +125,8 @@ func main() {
 	/* Assign this service a unique ID */
 	gloablNodeID = int(uuid.New().ID())
 
+	/* The router doesn't listen, so it registers without an address */
+	logger.SetNodeMetadata(version, "")
 	logger.SendRegistrationMsg(gloablNodeID, "router")
 
 	/* Start the heartbeat routine */
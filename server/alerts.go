@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/store"
+)
+
+// Rule types understood by the alert engine
+const (
+	RuleErrorCount = "error_count" // more than Threshold logs of Level in Window
+	RuleAbsence    = "absence"     // no log at all for Window
+	RuleLatency    = "latency"     // a response_time_ms above Threshold in Window
+	RuleErrorCode  = "error_code"  // more than Threshold logs with one of ErrorCodes in Window
+)
+
+// Alert states
+const (
+	AlertPending  = "pending"
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// AlertRule is one rule of the alert rules file
+type AlertRule struct {
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`
+	Service    string   `json:"service"`
+	Level      string   `json:"level"`
+	ErrorCodes []string `json:"error_codes"`
+	Window     Duration `json:"window"`
+	Threshold  float64  `json:"threshold"`
+	For        Duration `json:"for"`
+	Severity   string   `json:"severity"`
+	Notify     []string `json:"notify"`
+}
+
+// AlertRules is the layout of the alert rules file
+type AlertRules struct {
+	Rules []AlertRule `json:"rules"`
+}
+
+// Alert is the state of one rule for one service
+type Alert struct {
+	ID         string    `json:"alert_id"`
+	Rule       string    `json:"rule"`
+	Type       string    `json:"type"`
+	Severity   string    `json:"severity"`
+	Service    string    `json:"service_name"`
+	State      string    `json:"state"`
+	Value      float64   `json:"value"`
+	Threshold  float64   `json:"threshold"`
+	Message    string    `json:"message"`
+	ActiveAt   time.Time `json:"active_at"`
+	FiredAt    time.Time `json:"fired_at"`
+	ResolvedAt time.Time `json:"resolved_at"`
+}
+
+// alertSample is one matching log kept for the rule window
+type alertSample struct {
+	at    time.Time
+	value float64
+}
+
+// ruleState is what the engine remembers about a rule between evaluations
+type ruleState struct {
+	rule     AlertRule
+	samples  map[string][]alertSample // by service
+	lastSeen map[string]time.Time     // by service, for absence rules
+	alerts   map[string]*Alert        // active alerts by service
+}
+
+// AlertEngine evaluates alert rules over the incoming log stream
+type AlertEngine struct {
+	mu       sync.Mutex
+	rules    []*ruleState
+	onChange func(Alert)
+}
+
+// LoadAlertRules reads and checks an alert rules file
+func LoadAlertRules(path string) ([]AlertRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read alert rules: %w", err)
+	}
+	var rules AlertRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse alert rules %s: %w", path, err)
+	}
+
+	names := make(map[string]bool)
+	for i := range rules.Rules {
+		rule := &rules.Rules[i]
+		if rule.Name == "" {
+			return nil, fmt.Errorf("alert rule %d has no name", i+1)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("duplicate alert rule %q", rule.Name)
+		}
+		names[rule.Name] = true
+
+		switch rule.Type {
+		case RuleErrorCount:
+			if rule.Level == "" {
+				rule.Level = "ERROR"
+			}
+		case RuleErrorCode:
+			if len(rule.ErrorCodes) == 0 {
+				return nil, fmt.Errorf("alert rule %q needs error_codes", rule.Name)
+			}
+		case RuleAbsence, RuleLatency:
+		default:
+			return nil, fmt.Errorf("alert rule %q has unknown type %q", rule.Name, rule.Type)
+		}
+		if rule.Window <= 0 {
+			return nil, fmt.Errorf("alert rule %q needs a window", rule.Name)
+		}
+		if rule.Severity == "" {
+			rule.Severity = "warning"
+		}
+	}
+	return rules.Rules, nil
+}
+
+// NewAlertEngine creates an engine for the given rules; onChange is called on every state change
+func NewAlertEngine(rules []AlertRule, onChange func(Alert)) *AlertEngine {
+	engine := &AlertEngine{onChange: onChange}
+	for _, rule := range rules {
+		state := &ruleState{
+			rule:     rule,
+			samples:  make(map[string][]alertSample),
+			lastSeen: make(map[string]time.Time),
+			alerts:   make(map[string]*Alert),
+		}
+		// A named service that never logs is absent from the start
+		if rule.Type == RuleAbsence && rule.Service != "" {
+			state.lastSeen[rule.Service] = time.Now()
+		}
+		engine.rules = append(engine.rules, state)
+	}
+	return engine
+}
+
+// errorCode returns the error_details.error_code of an error log
+func errorCode(logData map[string]interface{}) string {
+	details, _ := logData["error_details"].(map[string]interface{})
+	code, _ := details["error_code"].(string)
+	return code
+}
+
+// Observe feeds a LOG message to every rule
+func (e *AlertEngine) Observe(logData map[string]interface{}, now time.Time) {
+	if logData["message_type"] != "LOG" {
+		return
+	}
+	service, _ := logData["service_name"].(string)
+	level, _ := logData["log_level"].(string)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, state := range e.rules {
+		rule := state.rule
+		if rule.Service != "" && rule.Service != service {
+			continue
+		}
+
+		switch rule.Type {
+		case RuleErrorCount:
+			if strings.EqualFold(level, rule.Level) {
+				state.samples[service] = append(state.samples[service], alertSample{at: now, value: 1})
+			}
+		case RuleErrorCode:
+			code := errorCode(logData)
+			for _, wanted := range rule.ErrorCodes {
+				if code == wanted {
+					state.samples[service] = append(state.samples[service], alertSample{at: now, value: 1})
+					break
+				}
+			}
+		case RuleLatency:
+			if latency, ok := store.NumberValue(logData["response_time_ms"]); ok {
+				state.samples[service] = append(state.samples[service], alertSample{at: now, value: latency})
+			}
+		case RuleAbsence:
+			state.lastSeen[service] = now
+			continue
+		}
+		// Only the leader evaluates rules, so followers would otherwise keep every sample
+		state.prune(service, now)
+	}
+}
+
+// prune drops the samples of a service that fell out of the rule window; samples are
+// appended as logs arrive, so the expired ones are at the front
+func (state *ruleState) prune(service string, now time.Time) []alertSample {
+	samples := state.samples[service]
+	window := time.Duration(state.rule.Window)
+	expired := 0
+	for expired < len(samples) && now.Sub(samples[expired].at) > window {
+		expired++
+	}
+	if expired == len(samples) {
+		delete(state.samples, service)
+		return nil
+	}
+	// The next append that outgrows the array moves the samples and frees the expired ones
+	samples = samples[expired:]
+	state.samples[service] = samples
+	return samples
+}
+
+// value computes the current value of a rule for one service and whether the rule holds
+func (state *ruleState) value(service string, now time.Time) (float64, bool) {
+	rule := state.rule
+	window := time.Duration(rule.Window)
+
+	if rule.Type == RuleAbsence {
+		silence := now.Sub(state.lastSeen[service])
+		return silence.Seconds(), silence > window
+	}
+
+	kept := state.prune(service, now)
+	value := 0.0
+	for _, sample := range kept {
+		if rule.Type == RuleLatency {
+			if sample.value > value {
+				value = sample.value
+			}
+		} else {
+			value += sample.value
+		}
+	}
+	return value, value > rule.Threshold
+}
+
+// describe builds the human readable message of an alert
+func (state *ruleState) describe(service string, value float64) string {
+	rule := state.rule
+	window := time.Duration(rule.Window)
+	switch rule.Type {
+	case RuleErrorCount:
+		return fmt.Sprintf("%s: %d %s logs in %s (threshold %g)", service, int(value), rule.Level, window, rule.Threshold)
+	case RuleErrorCode:
+		return fmt.Sprintf("%s: %d logs with error code %s in %s (threshold %g)", service, int(value), strings.Join(rule.ErrorCodes, "/"), window, rule.Threshold)
+	case RuleLatency:
+		return fmt.Sprintf("%s: response time %gms in the last %s (threshold %gms)", service, value, window, rule.Threshold)
+	case RuleAbsence:
+		return fmt.Sprintf("%s: no logs for %s", service, (time.Duration(value) * time.Second).Round(time.Second))
+	}
+	return service
+}
+
+// Evaluate checks every rule and moves alerts between pending, firing and resolved
+func (e *AlertEngine) Evaluate(now time.Time) {
+	var changes []Alert
+
+	e.mu.Lock()
+	for _, state := range e.rules {
+		rule := state.rule
+
+		services := make(map[string]bool)
+		for service := range state.samples {
+			services[service] = true
+		}
+		for service := range state.lastSeen {
+			services[service] = true
+		}
+		for service := range state.alerts {
+			services[service] = true
+		}
+
+		for service := range services {
+			value, active := state.value(service, now)
+			alert, known := state.alerts[service]
+
+			switch {
+			case active && !known:
+				alert = &Alert{
+					ID:        rule.Name + "/" + service,
+					Rule:      rule.Name,
+					Type:      rule.Type,
+					Severity:  rule.Severity,
+					Service:   service,
+					State:     AlertPending,
+					Threshold: rule.Threshold,
+					ActiveAt:  now,
+				}
+				state.alerts[service] = alert
+				alert.Value = value
+				alert.Message = state.describe(service, value)
+				changes = append(changes, *alert)
+				fallthrough
+			case active && alert.State == AlertPending:
+				alert.Value = value
+				alert.Message = state.describe(service, value)
+				if now.Sub(alert.ActiveAt) >= time.Duration(rule.For) {
+					alert.State = AlertFiring
+					alert.FiredAt = now
+					changes = append(changes, *alert)
+				}
+			case active:
+				// Already firing, keep a single alert
+				alert.Value = value
+			case known:
+				delete(state.alerts, service)
+				if alert.State == AlertFiring {
+					alert.State = AlertResolved
+					alert.Value = value
+					alert.ResolvedAt = now
+					changes = append(changes, *alert)
+				}
+			}
+		}
+	}
+	e.mu.Unlock()
+
+	for _, alert := range changes {
+		if e.onChange != nil {
+			e.onChange(alert)
+		}
+	}
+}
+
+// Active returns the pending and firing alerts ordered by ID
+func (e *AlertEngine) Active() []Alert {
+	e.mu.Lock()
+	var alerts []Alert
+	for _, state := range e.rules {
+		for _, alert := range state.alerts {
+			alerts = append(alerts, *alert)
+		}
+	}
+	e.mu.Unlock()
+
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].ID < alerts[j].ID })
+	return alerts
+}
+
+// evaluateAlerts runs the engine every interval
+func evaluateAlerts(ctx context.Context, engine *AlertEngine, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			engine.Evaluate(time.Now())
+		}
+	}
+}
+
+// recordAlert prints an alert state change and stores it in the alert history
+func recordAlert(history store.LogStore) func(Alert) {
+	return func(alert Alert) {
+		fmt.Printf("Alert %s [%s] %s: %s\n", alert.State, alert.Severity, alert.Rule, alert.Message)
+
+		now := time.Now()
+		doc := store.Document{}
+		data, _ := json.Marshal(alert)
+		json.Unmarshal(data, &doc)
+		doc[store.FieldTimestamp] = now.UTC().Format(time.RFC3339Nano)
+		doc[store.FieldEventID] = fmt.Sprintf("%s/%s/%d", alert.ID, alert.State, now.UnixNano())
+		if err := history.Append(doc); err != nil {
+			fmt.Printf("Failed to record alert: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// alertLog builds a LOG message of a service with extra fields
+func alertLog(service string, level string, extra map[string]interface{}) map[string]interface{} {
+	logData := map[string]interface{}{"message_type": "LOG", "service_name": service, "log_level": level}
+	for key, value := range extra {
+		logData[key] = value
+	}
+	return logData
+}
+
+// alertStep feeds a log to the engine at a number of seconds after the start, or
+// evaluates it when log is nil; changes lists the state changes reported, like "cache:firing"
+type alertStep struct {
+	at      int
+	log     map[string]interface{}
+	changes string
+}
+
+func TestAlertEngine(t *testing.T) {
+	errorLog := alertLog("cache", "ERROR", nil)
+	minute := Duration(time.Minute)
+	tests := []struct {
+		name  string
+		rule  AlertRule
+		steps []alertStep
+	}{
+		{"fires and resolves", AlertRule{Type: RuleErrorCount, Level: "ERROR", Window: minute, Threshold: 2}, []alertStep{
+			{0, errorLog, ""}, {1, errorLog, ""},
+			{2, nil, ""},
+			{3, errorLog, ""},
+			{4, nil, "cache:pending cache:firing"},
+			{10, nil, ""},
+			{62, nil, "cache:resolved"},
+			{70, nil, ""},
+		}},
+		{"pending for a while", AlertRule{Type: RuleErrorCount, Level: "ERROR", Window: minute, Threshold: 2, For: Duration(30 * time.Second)}, []alertStep{
+			{0, errorLog, ""}, {1, errorLog, ""}, {2, errorLog, ""},
+			{3, nil, "cache:pending"},
+			{20, nil, ""},
+			{25, errorLog, ""},
+			{33, nil, "cache:firing"},
+		}},
+		{"pending clears quietly", AlertRule{Type: RuleErrorCount, Level: "ERROR", Window: minute, Threshold: 2, For: Duration(30 * time.Second)}, []alertStep{
+			{0, errorLog, ""}, {1, errorLog, ""}, {2, errorLog, ""},
+			{3, nil, "cache:pending"},
+			{70, nil, ""},
+		}},
+		{"other levels", AlertRule{Type: RuleErrorCount, Level: "ERROR", Window: minute}, []alertStep{
+			{0, alertLog("cache", "WARN", nil), ""},
+			{1, nil, ""},
+			{2, alertLog("cache", "error", nil), ""},
+			{3, nil, "cache:pending cache:firing"},
+		}},
+		{"per service", AlertRule{Type: RuleErrorCount, Level: "ERROR", Window: minute}, []alertStep{
+			{0, errorLog, ""},
+			{1, alertLog("router", "ERROR", nil), ""},
+			{2, nil, "cache:pending cache:firing router:pending router:firing"},
+			{30, errorLog, ""},
+			{62, nil, "router:resolved"},
+		}},
+		{"one service", AlertRule{Type: RuleErrorCount, Level: "ERROR", Service: "router", Window: minute}, []alertStep{
+			{0, errorLog, ""},
+			{1, nil, ""},
+		}},
+		{"error code", AlertRule{Type: RuleErrorCode, ErrorCodes: []string{"E42"}, Window: minute}, []alertStep{
+			{0, alertLog("cache", "ERROR", map[string]interface{}{"error_details": map[string]interface{}{"error_code": "E7"}}), ""},
+			{1, nil, ""},
+			{2, alertLog("cache", "ERROR", map[string]interface{}{"error_details": map[string]interface{}{"error_code": "E42"}}), ""},
+			{3, nil, "cache:pending cache:firing"},
+		}},
+		{"latency", AlertRule{Type: RuleLatency, Window: minute, Threshold: 500}, []alertStep{
+			{0, alertLog("cache", "INFO", map[string]interface{}{"response_time_ms": float64(200)}), ""},
+			{1, nil, ""},
+			{2, alertLog("cache", "INFO", map[string]interface{}{"response_time_ms": " 750 "}), ""},
+			{3, nil, "cache:pending cache:firing"},
+			{63, nil, "cache:resolved"},
+		}},
+		{"absence", AlertRule{Type: RuleAbsence, Window: minute}, []alertStep{
+			{0, alertLog("cache", "INFO", nil), ""},
+			{30, nil, ""},
+			{61, nil, "cache:pending cache:firing"},
+			{62, alertLog("cache", "INFO", nil), ""},
+			{63, nil, "cache:resolved"},
+		}},
+	}
+	start := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Name = "rule"
+			var changes []Alert
+			engine := NewAlertEngine([]AlertRule{tt.rule}, func(alert Alert) { changes = append(changes, alert) })
+			for _, step := range tt.steps {
+				now := start.Add(time.Duration(step.at) * time.Second)
+				changes = nil
+				if step.log != nil {
+					engine.Observe(step.log, now)
+				} else {
+					engine.Evaluate(now)
+				}
+
+				// Services are evaluated in any order, the changes of one alert in order
+				sort.SliceStable(changes, func(i, j int) bool { return changes[i].Service < changes[j].Service })
+				var got []string
+				for _, alert := range changes {
+					got = append(got, alert.Service+":"+alert.State)
+				}
+				if strings.Join(got, " ") != step.changes {
+					t.Errorf("at %ds: changes = %q, want %q", step.at, strings.Join(got, " "), step.changes)
+				}
+			}
+		})
+	}
+}
+
+func TestAlertMessage(t *testing.T) {
+	var fired Alert
+	rule := AlertRule{Name: "errors", Type: RuleErrorCount, Level: "ERROR", Window: Duration(5 * time.Minute), Threshold: 1, Severity: "critical"}
+	engine := NewAlertEngine([]AlertRule{rule}, func(alert Alert) { fired = alert })
+	now := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		engine.Observe(alertLog("cache", "ERROR", nil), now)
+	}
+	engine.Evaluate(now)
+
+	if fired.ID != "errors/cache" || fired.State != AlertFiring || fired.Value != 3 || fired.Severity != "critical" {
+		t.Errorf("alert = %+v", fired)
+	}
+	if want := "cache: 3 ERROR logs in 5m0s (threshold 1)"; fired.Message != want {
+		t.Errorf("message = %q, want %q", fired.Message, want)
+	}
+	if active := engine.Active(); len(active) != 1 || active[0].ID != "errors/cache" {
+		t.Errorf("active = %+v", active)
+	}
+}
+
+func TestAlertSamplesPruned(t *testing.T) {
+	rule := AlertRule{Name: "errors", Type: RuleErrorCount, Level: "ERROR", Window: Duration(time.Minute)}
+	engine := NewAlertEngine([]AlertRule{rule}, nil)
+	start := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	// A follower observes without ever evaluating
+	for i := 0; i < 1000; i++ {
+		engine.Observe(alertLog("cache", "ERROR", nil), start.Add(time.Duration(i)*time.Second))
+	}
+	if kept := len(engine.rules[0].samples["cache"]); kept > 61 {
+		t.Errorf("kept %d samples, want at most a window's worth", kept)
+	}
+}
+
+func TestLoadAlertRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		err   string
+	}{
+		{"defaults", `{"name": "errors", "type": "error_count", "window": "5m"}`, ""},
+		{"no name", `{"type": "error_count", "window": "5m"}`, "alert rule 1 has no name"},
+		{"duplicate", `{"name": "a", "type": "absence", "window": "5m"}, {"name": "a", "type": "absence", "window": "5m"}`, `duplicate alert rule "a"`},
+		{"unknown type", `{"name": "a", "type": "rate", "window": "5m"}`, `alert rule "a" has unknown type "rate"`},
+		{"no codes", `{"name": "a", "type": "error_code", "window": "5m"}`, `alert rule "a" needs error_codes`},
+		{"no window", `{"name": "a", "type": "latency"}`, `alert rule "a" needs a window`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rules.json")
+			if err := os.WriteFile(path, []byte(fmt.Sprintf(`{"rules": [%s]}`, tt.rules)), 0o644); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+			rules, err := LoadAlertRules(path)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("error = %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadAlertRules: %v", err)
+			}
+			if rules[0].Level != "ERROR" || rules[0].Severity != "warning" {
+				t.Errorf("rule = %+v, want the default level and severity", rules[0])
+			}
+		})
+	}
+}
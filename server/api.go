@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// API holds what the HTTP endpoints of the server need
+type API struct {
+	Tail       *TailHub
+	Metrics    *Metrics
+	Health     *HealthChecker
+	Leader     *LeaderElector
+	Quotas     *QuotaManager
+	Validation *Validator
+	Registry   *Registry
+	Token      string // bearer token the API requires, if any
+}
+
+// Handler returns the routes of the server API. Every route requires the API token
+// except the health checks, which only tell whether the server is up and probes
+// usually can't send a token.
+func (api *API) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /tail", api.authorize(api.Tail))
+	mux.Handle("GET /metrics", api.authorize(api.Metrics))
+	mux.HandleFunc("GET /healthz", serveHealth)
+	mux.Handle("GET /readyz", api.Health)
+	mux.Handle("GET /leader", api.authorize(api.Leader))
+	mux.Handle("GET /quotas", api.authorize(api.Quotas))
+	mux.Handle("GET /validation", api.authorize(api.Validation))
+	mux.Handle("GET /nodes", api.authorize(api.Registry))
+	return mux
+}
+
+// authorize lets requests through to h only when they bear the API token, if one is set
+func (api *API) authorize(h http.Handler) http.Handler {
+	if api.Token == "" {
+		return h
+	}
+	want := []byte("Bearer " + api.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "missing or wrong API token", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// writeJSON sends v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+// serveAPI listens on address until the listener fails
+func serveAPI(ctx context.Context, address string, api *API) {
+	server := &http.Server{Addr: address, Handler: api.Handler()}
+	go func() {
+		<-ctx.Done()
+		// Live tail streams never end on their own, so don't wait on them for long
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			server.Close()
+		}
+	}()
+
+	log.Printf("API listening on %s", address)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("API stopped: %v", err)
+	}
+}
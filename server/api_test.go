@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIRequiresToken(t *testing.T) {
+	api := &API{Token: "secret"}
+	handler := api.Handler()
+	tests := []struct {
+		path          string
+		authorization string
+		status        int
+	}{
+		{"/tail", "", http.StatusUnauthorized},
+		{"/tail", "Bearer wrong", http.StatusUnauthorized},
+		{"/metrics", "", http.StatusUnauthorized},
+		{"/leader", "", http.StatusUnauthorized},
+		{"/quotas", "", http.StatusUnauthorized},
+		{"/validation", "", http.StatusUnauthorized},
+		{"/nodes", "", http.StatusUnauthorized},
+		{"/healthz", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path+" "+tt.authorization, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestAPIWithToken(t *testing.T) {
+	registry := NewRegistry(RegistryConfig{}, nil)
+	handler := (&API{Registry: registry, Token: "secret"}).Handler()
+	req := httptest.NewRequest(http.MethodGet, "/nodes", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,619 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"example.com/store"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// How archive files are partitioned
+const (
+	PartitionHour = "hour"
+	PartitionDay  = "day"
+)
+
+// manifestKey is where the list of archive files is kept, next to them
+const manifestKey = "manifest.json"
+
+// archiveDeleteBatch is how many archived logs are deleted from the store at once
+const archiveDeleteBatch = 1000
+
+// ArchiveConfig controls the cold archive of old logs
+type ArchiveConfig struct {
+	Enabled   bool     `json:"enabled"`    // archive periodically on the leader
+	OlderThan Duration `json:"older_than"` // logs older than this are archived
+	Interval  Duration `json:"interval"`
+	Partition string   `json:"partition"`  // hour or day, one file per partition and run
+	Delete    bool     `json:"delete"`     // remove archived logs from the store
+	BatchSize int      `json:"batch_size"` // logs per write when restoring
+	Dir       string   `json:"dir"`        // used when no S3 bucket is set
+	S3        S3Config `json:"s3"`
+}
+
+// S3Config says how to reach an S3-compatible bucket (AWS, MinIO, ...)
+type S3Config struct {
+	Endpoint  string `json:"endpoint"` // host[:port], without scheme
+	Bucket    string `json:"bucket"`
+	Prefix    string `json:"prefix"`
+	Region    string `json:"region"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	UseSSL    bool   `json:"use_ssl"`
+}
+
+// ArchiveFile is one compressed NDJSON file of the archive
+type ArchiveFile struct {
+	Key        string    `json:"key"`
+	Partition  time.Time `json:"partition"` // start of the hour or day it holds
+	From       time.Time `json:"from"`      // time of its oldest log
+	To         time.Time `json:"to"`        // time of its newest log
+	Count      int       `json:"count"`
+	Bytes      int64     `json:"bytes"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// ArchiveManifest lists the archive files, oldest partition first
+type ArchiveManifest struct {
+	Files []ArchiveFile `json:"files"`
+}
+
+// Select returns the files that may hold logs of [from, to); zero means unbounded
+func (m *ArchiveManifest) Select(from time.Time, to time.Time) []ArchiveFile {
+	var files []ArchiveFile
+	for _, file := range m.Files {
+		if (from.IsZero() || !file.To.Before(from)) && (to.IsZero() || file.From.Before(to)) {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// ArchiveStorage is where archive files and their manifest are kept
+type ArchiveStorage interface {
+	// Put stores an object, replacing any object with the same key
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get opens an object; a missing one is reported as os.ErrNotExist
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// OpenArchiveStorage returns the S3 bucket of cfg, or its local directory without one
+func OpenArchiveStorage(cfg ArchiveConfig) (ArchiveStorage, error) {
+	if cfg.S3.Bucket != "" {
+		return newS3Storage(cfg.S3)
+	}
+	if cfg.Dir == "" {
+		return nil, errors.New("no archive directory or S3 bucket configured")
+	}
+	return dirStorage(cfg.Dir), nil
+}
+
+// dirStorage keeps the archive in a local directory, keys being relative paths
+type dirStorage string
+
+func (d dirStorage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	name := filepath.Join(string(d), filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	tmp := name + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+func (d dirStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(d), filepath.FromSlash(key)))
+}
+
+// s3Storage keeps the archive in an S3-compatible bucket
+type s3Storage struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func newS3Storage(cfg S3Config) (*s3Storage, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+	return &s3Storage{client: client, bucket: cfg.Bucket, prefix: cfg.Prefix}, nil
+}
+
+func (s *s3Storage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	_, err := s.client.PutObject(ctx, s.bucket, path.Join(s.prefix, key), r, size, minio.PutObjectOptions{})
+	return err
+}
+
+func (s *s3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, path.Join(s.prefix, key), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// Errors only show up once the object is read
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, fmt.Errorf("%s: %w", key, os.ErrNotExist)
+		}
+		return nil, err
+	}
+	return object, nil
+}
+
+// LoadManifest reads the manifest of an archive; an archive without one is empty
+func LoadManifest(ctx context.Context, storage ArchiveStorage) (*ArchiveManifest, error) {
+	manifest := &ArchiveManifest{}
+	r, err := storage.Get(ctx, manifestKey)
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	defer r.Close()
+	if err := json.NewDecoder(r).Decode(manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// saveManifest writes the manifest of an archive
+func saveManifest(ctx context.Context, storage ArchiveStorage, manifest *ArchiveManifest) error {
+	sort.SliceStable(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Partition.Before(manifest.Files[j].Partition)
+	})
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := storage.Put(ctx, manifestKey, bytes.NewReader(data), int64(len(data))); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// ArchiveStats counts what an archive or restore run did
+type ArchiveStats struct {
+	Files   int
+	Logs    int
+	Bytes   int64
+	Deleted int
+}
+
+// Archiver moves old logs from the store to the archive and loads them back
+type Archiver struct {
+	cfg     ArchiveConfig
+	logs    store.LogStore
+	storage ArchiveStorage
+}
+
+// NewArchiver creates an archiver for the logs of a store
+func NewArchiver(cfg ArchiveConfig, logs store.LogStore) (*Archiver, error) {
+	switch cfg.Partition {
+	case "":
+		cfg.Partition = PartitionDay
+	case PartitionHour, PartitionDay:
+	default:
+		return nil, fmt.Errorf("unknown archive partition %q, use %q or %q", cfg.Partition, PartitionHour, PartitionDay)
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 1000
+	}
+	storage, err := OpenArchiveStorage(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Archiver{cfg: cfg, logs: logs, storage: storage}, nil
+}
+
+// partitionOf returns the start of the partition holding t
+func (a *Archiver) partitionOf(t time.Time) time.Time {
+	t = t.UTC()
+	if a.cfg.Partition == PartitionHour {
+		return t.Truncate(time.Hour)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// partitionEnd returns the start of the partition after the one starting at start
+func (a *Archiver) partitionEnd(start time.Time) time.Time {
+	if a.cfg.Partition == PartitionHour {
+		return start.Add(time.Hour)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// Archive moves the logs older than the configured age, whole partitions at a time,
+// to the archive; every partition is written, listed in the manifest and only then
+// deleted from the store, so an interrupted run loses nothing
+func (a *Archiver) Archive(ctx context.Context, now time.Time) (ArchiveStats, error) {
+	var stats ArchiveStats
+	// Only partitions that are entirely older than the threshold
+	cutoff := a.partitionOf(now.Add(-time.Duration(a.cfg.OlderThan)))
+
+	manifest, err := LoadManifest(ctx, a.storage)
+	if err != nil {
+		return stats, err
+	}
+	// The partitions already done are kept when the logs stay in the store, or each
+	// run would archive them again
+	after := time.Time{}
+	if !a.cfg.Delete {
+		for _, file := range manifest.Files {
+			if end := a.partitionEnd(file.Partition); end.After(after) {
+				after = end
+			}
+		}
+	}
+
+	for ctx.Err() == nil {
+		oldest, err := a.logs.Query(store.Query{From: after, To: cutoff, Sort: store.SortOldest, Size: 1})
+		if err != nil {
+			return stats, fmt.Errorf("failed to find the oldest log: %w", err)
+		}
+		if len(oldest.Hits) == 0 {
+			return stats, nil
+		}
+		t, ok := store.EventTime(oldest.Hits[0].Doc)
+		if !ok {
+			return stats, fmt.Errorf("log %s has no event time", oldest.Hits[0].ID)
+		}
+		start := a.partitionOf(t)
+
+		file, ids, err := a.archivePartition(ctx, start, now)
+		if err != nil {
+			return stats, fmt.Errorf("failed to archive %s: %w", start.Format(time.RFC3339), err)
+		}
+		manifest.Files = append(manifest.Files, file)
+		if err := saveManifest(ctx, a.storage, manifest); err != nil {
+			return stats, err
+		}
+		stats.Files++
+		stats.Logs += file.Count
+		stats.Bytes += file.Bytes
+		fmt.Printf("Archived %d logs of %s to %s (%d bytes)\n", file.Count, start.Format(time.RFC3339), file.Key, file.Bytes)
+
+		if !a.cfg.Delete {
+			after = a.partitionEnd(start)
+			continue
+		}
+		// Only what was archived: logs replayed into the partition meanwhile stay. Logs
+		// left behind would be found again and archive the partition once more, forever.
+		for len(ids) > 0 {
+			batch := ids[:min(len(ids), archiveDeleteBatch)]
+			ids = ids[len(batch):]
+			deleted, err := a.logs.Delete(store.Query{
+				Filter: store.IDs(batch),
+				From:   start,
+				To:     a.partitionEnd(start),
+			})
+			stats.Deleted += deleted
+			if err != nil {
+				return stats, fmt.Errorf("failed to delete archived logs: %w", err)
+			}
+			if deleted < len(batch) {
+				return stats, fmt.Errorf("deleted only %d of %d archived logs of %s, stopping so it isn't archived again",
+					deleted, len(batch), start.Format(time.RFC3339))
+			}
+		}
+	}
+	return stats, ctx.Err()
+}
+
+// archivePartition writes the logs of one partition to a compressed NDJSON file,
+// uploads it and returns it with the IDs of the logs it holds
+func (a *Archiver) archivePartition(ctx context.Context, start time.Time, now time.Time) (ArchiveFile, []string, error) {
+	file := ArchiveFile{Partition: start, ArchivedAt: now.UTC()}
+	// Runs may archive the same partition again, when logs arrive late
+	layout := "2006/01/02"
+	if a.cfg.Partition == PartitionHour {
+		layout = "2006/01/02/15"
+	}
+	file.Key = fmt.Sprintf("logs/%s-%s.ndjson.gz", start.Format(layout), file.ArchivedAt.Format("20060102T150405Z"))
+
+	tmp, err := os.CreateTemp("", "archive-*.ndjson.gz")
+	if err != nil {
+		return file, nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	compressed := gzip.NewWriter(tmp)
+	buffered := bufio.NewWriter(compressed)
+	encoder := json.NewEncoder(buffered)
+	var ids []string
+	err = a.logs.Scan(store.Query{From: start, To: a.partitionEnd(start), Sort: store.SortOldest}, func(hit store.Hit) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		t, _ := store.EventTime(hit.Doc)
+		if file.Count == 0 {
+			file.From = t
+		}
+		file.To = t
+		file.Count++
+		ids = append(ids, hit.ID)
+		// Logs indexed without an event_id keep their document ID, so restoring them
+		// twice replaces them instead of duplicating them
+		if hit.Doc.String(store.FieldEventID) == "" && hit.ID != "" {
+			hit.Doc[store.FieldEventID] = hit.ID
+		}
+		return encoder.Encode(hit.Doc)
+	})
+	if err != nil {
+		return file, nil, err
+	}
+	if err := buffered.Flush(); err != nil {
+		return file, nil, err
+	}
+	if err := compressed.Close(); err != nil {
+		return file, nil, err
+	}
+
+	if file.Bytes, err = tmp.Seek(0, io.SeekCurrent); err != nil {
+		return file, nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return file, nil, err
+	}
+	if err := a.storage.Put(ctx, file.Key, tmp, file.Bytes); err != nil {
+		return file, nil, fmt.Errorf("failed to upload %s: %w", file.Key, err)
+	}
+	return file, ids, nil
+}
+
+// Restore loads the archived logs of [from, to) into a store; zero means unbounded.
+// Logs keep their event_id, which archiving sets to the document ID of logs without
+// one, so restoring twice doesn't duplicate them.
+func (a *Archiver) Restore(ctx context.Context, from time.Time, to time.Time, target store.LogStore) (ArchiveStats, error) {
+	var stats ArchiveStats
+	manifest, err := LoadManifest(ctx, a.storage)
+	if err != nil {
+		return stats, err
+	}
+	for _, file := range manifest.Select(from, to) {
+		count, err := a.restoreFile(ctx, file, from, to, target)
+		stats.Logs += count
+		if err != nil {
+			return stats, fmt.Errorf("failed to restore %s: %w", file.Key, err)
+		}
+		stats.Files++
+		stats.Bytes += file.Bytes
+		fmt.Printf("Restored %d logs from %s\n", count, file.Key)
+	}
+	return stats, nil
+}
+
+// restoreFile appends the logs of one archive file that fall in [from, to)
+func (a *Archiver) restoreFile(ctx context.Context, file ArchiveFile, from time.Time, to time.Time, target store.LogStore) (int, error) {
+	r, err := a.storage.Get(ctx, file.Key)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	decompressed, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	defer decompressed.Close()
+
+	restored := 0
+	batch := make([]store.Document, 0, a.cfg.BatchSize)
+	flush := func() error {
+		if err := target.Append(batch...); err != nil {
+			return err
+		}
+		restored += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	decoder := json.NewDecoder(decompressed)
+	for {
+		if err := ctx.Err(); err != nil {
+			return restored, err
+		}
+		var doc store.Document
+		if err := decoder.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return restored, fmt.Errorf("failed to decode: %w", err)
+		}
+		t, _ := store.EventTime(doc)
+		if (!from.IsZero() && t.Before(from)) || (!to.IsZero() && !t.Before(to)) {
+			continue
+		}
+		if batch = append(batch, doc); len(batch) == cap(batch) {
+			if err := flush(); err != nil {
+				return restored, err
+			}
+		}
+	}
+	return restored, flush()
+}
+
+// archiveLogs archives on every interval until ctx is cancelled
+func archiveLogs(ctx context.Context, archiver *Archiver, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		stats, err := archiver.Archive(ctx, time.Now())
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to archive logs: %v", err)
+		}
+		if stats.Files > 0 {
+			fmt.Printf("Archived %d logs in %d files, %d deleted from the store\n", stats.Logs, stats.Files, stats.Deleted)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runArchive implements the archive subcommand and returns the exit code
+func runArchive(args []string) int {
+	flags := flag.NewFlagSet("archive", flag.ExitOnError)
+	configPath := flags.String("config", "", "Path to the JSON server config file")
+	olderThan := flags.Duration("older-than", 0, "Archive logs older than this (default the configured age)")
+	keep := flags.Bool("keep", false, "Keep the archived logs in the store")
+	flags.Parse(args)
+
+	cfg, err := LoadConfig(*configPath)
+	if err != nil {
+		log.Printf("Failed to load config: %v", err)
+		return 1
+	}
+	if *olderThan > 0 {
+		cfg.Archive.OlderThan = Duration(*olderThan)
+	}
+	if *keep {
+		cfg.Archive.Delete = false
+	}
+
+	logs, err := store.Open(cfg.Store)
+	if err != nil {
+		log.Printf("Failed to open log store: %v", err)
+		return 1
+	}
+	defer logs.Close()
+	archiver, err := NewArchiver(cfg.Archive, logs)
+	if err != nil {
+		log.Printf("Failed to configure the archive: %v", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stats, err := archiver.Archive(ctx, time.Now())
+	fmt.Printf("Archived %d logs in %d files (%d bytes), %d deleted from the store\n", stats.Logs, stats.Files, stats.Bytes, stats.Deleted)
+	if err != nil {
+		log.Printf("Archive failed: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// runRestore implements the restore subcommand and returns the exit code
+func runRestore(args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	configPath := flags.String("config", "", "Path to the JSON server config file")
+	since := flags.String("since", "", "Restore logs from this time on (RFC 3339 or a duration like 720h)")
+	until := flags.String("until", "", "Restore logs before this time (RFC 3339 or a duration like 24h)")
+	index := flags.String("index", "", "Elasticsearch index, or local store subdirectory, to restore to (default the configured one)")
+	backend := flags.String("backend", "", "Store to restore to: elasticsearch or local (default the configured one)")
+	dataDir := flags.String("data-dir", "", "Directory of the local store (default the configured one)")
+	list := flags.Bool("list", false, "Only list the archive files holding the time range")
+	flags.Parse(args)
+
+	cfg, err := LoadConfig(*configPath)
+	if err != nil {
+		log.Printf("Failed to load config: %v", err)
+		return 1
+	}
+	now := time.Now()
+	from, err := parseReplayTime(*since, now)
+	if err != nil {
+		log.Printf("Invalid -since: %v", err)
+		return 1
+	}
+	to, err := parseReplayTime(*until, now)
+	if err != nil {
+		log.Printf("Invalid -until: %v", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *list {
+		storage, err := OpenArchiveStorage(cfg.Archive)
+		if err != nil {
+			log.Printf("Failed to open the archive: %v", err)
+			return 1
+		}
+		manifest, err := LoadManifest(ctx, storage)
+		if err != nil {
+			log.Printf("Failed to read the archive: %v", err)
+			return 1
+		}
+		for _, file := range manifest.Select(from, to) {
+			fmt.Printf("%s  %s to %s  %d logs  %d bytes\n", file.Key, file.From.Format(time.RFC3339), file.To.Format(time.RFC3339), file.Count, file.Bytes)
+		}
+		return 0
+	}
+
+	// The target store is the configured one unless the flags say otherwise
+	if *backend != "" {
+		cfg.Store.Backend = *backend
+	}
+	if *dataDir != "" {
+		cfg.Store.Local.Dir = *dataDir
+	}
+	if *index != "" {
+		cfg.Store.Elasticsearch.Index = *index
+		cfg.Store.Local.Dir = filepath.Join(cfg.Store.Local.Dir, *index)
+	}
+	target, err := store.Open(cfg.Store)
+	if err != nil {
+		log.Printf("Failed to open log store: %v", err)
+		return 1
+	}
+	defer target.Close()
+	if es, ok := target.(*store.ElasticStore); ok {
+		if err := es.EnsureIndex(); err != nil {
+			log.Printf("Failed to create index: %v", err)
+			return 1
+		}
+	}
+	archiver, err := NewArchiver(cfg.Archive, target)
+	if err != nil {
+		log.Printf("Failed to configure the archive: %v", err)
+		return 1
+	}
+
+	stats, err := archiver.Restore(ctx, from, to, target)
+	fmt.Printf("Restored %d logs from %d files\n", stats.Logs, stats.Files)
+	if err != nil {
+		log.Printf("Restore failed: %v", err)
+		return 1
+	}
+	return 0
+}
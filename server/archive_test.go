@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"example.com/store"
+)
+
+// archiveNow is the time the archive tests run at
+var archiveNow = time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+
+// newArchiveStore returns a local store holding count logs an hour apart, the newest
+// one an hour before archiveNow
+func newArchiveStore(t *testing.T, count int) store.LogStore {
+	t.Helper()
+	logs, err := store.NewLocalStore(store.LocalConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	t.Cleanup(func() { logs.Close() })
+	for i := 1; i <= count; i++ {
+		doc := store.Document{
+			store.FieldEventID:   fmt.Sprintf("log-%03d", i),
+			store.FieldTimestamp: archiveNow.Add(-time.Duration(i) * time.Hour).Format(time.RFC3339Nano),
+			"message":            fmt.Sprintf("log %d", i),
+		}
+		if err := logs.Append(doc); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	return logs
+}
+
+// countLogs returns how many logs a store holds
+func countLogs(t *testing.T, logs store.LogStore) int {
+	t.Helper()
+	result, err := logs.Query(store.Query{Size: 1})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	return result.Total
+}
+
+func TestArchiveAndRestore(t *testing.T) {
+	tests := []struct {
+		name      string
+		partition string
+		delete    bool
+		files     int
+		left      int
+	}{
+		// 72 logs over the 3 days before archiveNow, the last day too recent to archive
+		{"daily with delete", PartitionDay, true, 2, 24},
+		{"daily keeping logs", PartitionDay, false, 2, 72},
+		{"hourly with delete", PartitionHour, true, 48, 24},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := newArchiveStore(t, 72)
+			cfg := ArchiveConfig{OlderThan: Duration(24 * time.Hour), Partition: tt.partition, Delete: tt.delete, Dir: t.TempDir()}
+			archiver, err := NewArchiver(cfg, logs)
+			if err != nil {
+				t.Fatalf("NewArchiver: %v", err)
+			}
+
+			stats, err := archiver.Archive(context.Background(), archiveNow)
+			if err != nil {
+				t.Fatalf("Archive: %v", err)
+			}
+			if stats.Files != tt.files || stats.Logs != 48 {
+				t.Errorf("archived %d logs in %d files, want 48 in %d", stats.Logs, stats.Files, tt.files)
+			}
+			if got := countLogs(t, logs); got != tt.left {
+				t.Errorf("%d logs left in the store, want %d", got, tt.left)
+			}
+
+			// A second run has nothing left to archive
+			stats, err = archiver.Archive(context.Background(), archiveNow)
+			if err != nil {
+				t.Fatalf("second Archive: %v", err)
+			}
+			if stats.Files != 0 {
+				t.Errorf("second run archived %d files, want none", stats.Files)
+			}
+			manifest, err := LoadManifest(context.Background(), archiver.storage)
+			if err != nil {
+				t.Fatalf("LoadManifest: %v", err)
+			}
+			if len(manifest.Files) != tt.files {
+				t.Errorf("manifest lists %d files, want %d", len(manifest.Files), tt.files)
+			}
+
+			// Restoring twice doesn't duplicate logs
+			target := newArchiveStore(t, 0)
+			for i := 0; i < 2; i++ {
+				stats, err = archiver.Restore(context.Background(), time.Time{}, time.Time{}, target)
+				if err != nil {
+					t.Fatalf("Restore: %v", err)
+				}
+				if stats.Logs != 48 {
+					t.Errorf("restored %d logs, want 48", stats.Logs)
+				}
+			}
+			if got := countLogs(t, target); got != 48 {
+				t.Errorf("target holds %d logs, want 48", got)
+			}
+		})
+	}
+}
+
+func TestRestoreRange(t *testing.T) {
+	logs := newArchiveStore(t, 48)
+	archiver, err := NewArchiver(ArchiveConfig{OlderThan: Duration(time.Hour), Delete: true, Dir: t.TempDir()}, logs)
+	if err != nil {
+		t.Fatalf("NewArchiver: %v", err)
+	}
+	if _, err := archiver.Archive(context.Background(), archiveNow); err != nil {
+		t.Fatalf("Archive: %v", err)
+	}
+
+	target := newArchiveStore(t, 0)
+	from := archiveNow.Add(-30 * time.Hour)
+	to := archiveNow.Add(-20 * time.Hour)
+	stats, err := archiver.Restore(context.Background(), from, to, target)
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	// Only the first day is archived, up to its 18:00 log
+	if stats.Logs != 6 {
+		t.Errorf("restored %d logs, want 6", stats.Logs)
+	}
+	result, err := target.Query(store.Query{Sort: store.SortOldest, Size: 1})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(result.Hits) == 0 || result.Hits[0].ID != "log-030" {
+		t.Errorf("oldest restored log is %v, want log-030", result.Hits)
+	}
+}
+
+// undeletableStore is a store whose Delete never removes anything
+type undeletableStore struct {
+	store.LogStore
+}
+
+func (s undeletableStore) Delete(q store.Query) (int, error) {
+	return 0, nil
+}
+
+func TestArchiveStopsWhenDeleteFails(t *testing.T) {
+	logs := undeletableStore{newArchiveStore(t, 48)}
+	archiver, err := NewArchiver(ArchiveConfig{OlderThan: Duration(time.Hour), Delete: true, Dir: t.TempDir()}, logs)
+	if err != nil {
+		t.Fatalf("NewArchiver: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stats, err := archiver.Archive(ctx, archiveNow)
+	if err == nil || ctx.Err() != nil {
+		t.Fatalf("Archive returned %v, want an error before the timeout", err)
+	}
+	if stats.Files != 1 {
+		t.Errorf("archived %d files, want to stop after the first", stats.Files)
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"example.com/store"
+)
+
+// Duration is a time.Duration that is written as a string ("30s", "5m") in config files
+type Duration time.Duration
+
+// UnmarshalJSON accepts either a duration string or a number of nanoseconds
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case float64:
+		*d = Duration(time.Duration(value))
+	case string:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: %w", value, err)
+		}
+		*d = Duration(parsed)
+	default:
+		return fmt.Errorf("invalid duration: %s", string(b))
+	}
+	return nil
+}
+
+// MarshalJSON writes the duration in its string form
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// ServiceTimeouts controls how long a node of a service may stay silent
+type ServiceTimeouts struct {
+	SuspectAfter Duration `json:"suspect_after"`
+	DownAfter    Duration `json:"down_after"`
+}
+
+// RegistryConfig holds the settings of the node lifecycle monitor
+type RegistryConfig struct {
+	CheckInterval    Duration                   `json:"check_interval"`
+	Default          ServiceTimeouts            `json:"default"`
+	Services         map[string]ServiceTimeouts `json:"services"`
+	DeregisterAfter  Duration                   `json:"deregister_after"`
+	FlapWindow       Duration                   `json:"flap_window"`
+	FlapThreshold    int                        `json:"flap_threshold"`
+	AutoRegister     bool                       `json:"auto_register"`
+	UnknownService   string                     `json:"unknown_service"`
+	AnnounceRecovery bool                       `json:"announce_recovery"`
+}
+
+// PersistenceConfig controls how the registry survives restarts
+type PersistenceConfig struct {
+	SnapshotPath     string   `json:"snapshot_path"`
+	SnapshotInterval Duration `json:"snapshot_interval"`
+	ReplayTopic      string   `json:"replay_topic"`
+	ReplayLookback   Duration `json:"replay_lookback"`
+}
+
+// AlertingConfig controls the alert rule engine
+type AlertingConfig struct {
+	RulesFile        string   `json:"rules_file"`
+	EvaluateInterval Duration `json:"evaluate_interval"`
+	HistoryIndex     string   `json:"history_index"`
+}
+
+// IndexingConfig controls how logs are batched on their way to the store
+type IndexingConfig struct {
+	QueueSize     int      `json:"queue_size"`
+	BatchSize     int      `json:"batch_size"`
+	FlushInterval Duration `json:"flush_interval"`
+}
+
+// Config is the server configuration, read from the file given with -config
+type Config struct {
+	Brokers         []string            `json:"brokers"`
+	Topics          []string            `json:"topics"`
+	Store           store.Config        `json:"store"`
+	Indexing        IndexingConfig      `json:"indexing"`
+	ConsumerGroup   string              `json:"consumer_group"`
+	ShutdownTimeout Duration            `json:"shutdown_timeout"`
+	FluentdHost     string              `json:"fluentd_host"`
+	FluentdPort     int                 `json:"fluentd_port"`
+	APIAddress      string              `json:"api_address"`
+	APIToken        string              `json:"api_token"`
+	TailBuffer      int                 `json:"tail_buffer"`
+	Console         ConsoleConfig       `json:"console"`
+	Enrich          EnrichConfig        `json:"enrich"`
+	Registry        RegistryConfig      `json:"registry"`
+	Persistence     PersistenceConfig   `json:"persistence"`
+	Alerting        AlertingConfig      `json:"alerting"`
+	Notifications   NotificationsConfig `json:"notifications"`
+	HA              HAConfig            `json:"ha"`
+	Validation      ValidationConfig    `json:"validation"`
+	Quotas          QuotaConfig         `json:"quotas"`
+	Archive         ArchiveConfig       `json:"archive"`
+}
+
+// DefaultConfig returns the configuration the server used before it was configurable
+func DefaultConfig() *Config {
+	return &Config{
+		Brokers: []string{"localhost:9092"},
+		Topics:  []string{"logs", "critical_logs"},
+		Store: store.Config{
+			Backend: "elasticsearch",
+			Elasticsearch: store.ElasticConfig{
+				Addresses: []string{"http://localhost:9200"},
+				Index:     "kafka-logs",
+			},
+			Local: store.LocalConfig{
+				Dir: "data",
+			},
+		},
+		Indexing: IndexingConfig{
+			QueueSize:     1024,
+			BatchSize:     200,
+			FlushInterval: Duration(time.Second),
+		},
+		ConsumerGroup:   "logserver",
+		ShutdownTimeout: Duration(30 * time.Second),
+		FluentdHost:     "localhost",
+		FluentdPort:     24225,
+		APIAddress:      "127.0.0.1:8090",
+		TailBuffer:      256,
+		Console: ConsoleConfig{
+			Format: ConsoleColor,
+		},
+		Registry: RegistryConfig{
+			CheckInterval: Duration(5 * time.Second),
+			Default: ServiceTimeouts{
+				SuspectAfter: Duration(20 * time.Second),
+				DownAfter:    Duration(30 * time.Second),
+			},
+			Services:         map[string]ServiceTimeouts{},
+			DeregisterAfter:  Duration(24 * time.Hour),
+			FlapWindow:       Duration(5 * time.Minute),
+			FlapThreshold:    3,
+			AutoRegister:     true,
+			UnknownService:   "unknown",
+			AnnounceRecovery: true,
+		},
+		Persistence: PersistenceConfig{
+			SnapshotPath:     "registry-snapshot.json",
+			SnapshotInterval: Duration(10 * time.Second),
+			ReplayTopic:      "critical_logs",
+			ReplayLookback:   Duration(10 * time.Minute),
+		},
+		Alerting: AlertingConfig{
+			EvaluateInterval: Duration(10 * time.Second),
+			HistoryIndex:     "kafka-alerts",
+		},
+		Enrich: EnrichConfig{
+			NormalizeCase: true,
+			NodeMetadata:  true,
+			IngestDelay:   true,
+		},
+		Validation: ValidationConfig{
+			Mode:            ValidationWarn,
+			DeadLetterTopic: "dead_letters",
+		},
+		Quotas: QuotaConfig{
+			OverQuota:         OverQuotaDrop,
+			SampleRate:        0.1,
+			ViolationInterval: Duration(time.Minute),
+		},
+		Archive: ArchiveConfig{
+			OlderThan: Duration(30 * 24 * time.Hour),
+			Interval:  Duration(time.Hour),
+			Partition: PartitionDay,
+			Delete:    true,
+			BatchSize: 1000,
+			Dir:       "archive",
+		},
+		HA: HAConfig{
+			Group:          "logserver-leader",
+			Topic:          "logserver-leader",
+			SessionTimeout: Duration(10 * time.Second),
+			Replication:    1,
+		},
+	}
+}
+
+// Environment variables with the Elasticsearch credentials, used over the config file's
+const (
+	envElasticUsername = "ELASTICSEARCH_USERNAME"
+	envElasticPassword = "ELASTICSEARCH_PASSWORD"
+)
+
+// LoadConfig reads a JSON config file on top of the defaults; an empty path only reads the
+// credentials from the environment
+func LoadConfig(path string) (*Config, error) {
+	cfg := DefaultConfig()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config: %w", err)
+		}
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+		}
+	}
+	if username := os.Getenv(envElasticUsername); username != "" {
+		cfg.Store.Elasticsearch.Username = username
+	}
+	if password := os.Getenv(envElasticPassword); password != "" {
+		cfg.Store.Elasticsearch.Password = password
+	}
+	if err := cfg.validate(); err != nil {
+		if path == "" {
+			return nil, fmt.Errorf("invalid config: %w", err)
+		}
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// validate rejects intervals, timeouts and sizes that aren't positive, as tickers would
+// panic at startup and an empty indexing queue or batch would stall, and an Elasticsearch
+// store without credentials
+func (cfg *Config) validate() error {
+	elastic := cfg.Store.Elasticsearch
+	if (cfg.Store.Backend == "" || cfg.Store.Backend == "elasticsearch") && (elastic.Username == "" || elastic.Password == "") {
+		return fmt.Errorf("store.elasticsearch needs a username and password, set them in the config file or with %s and %s", envElasticUsername, envElasticPassword)
+	}
+	intervals := []struct {
+		name  string
+		value Duration
+	}{
+		{"indexing.flush_interval", cfg.Indexing.FlushInterval},
+		{"registry.check_interval", cfg.Registry.CheckInterval},
+		{"persistence.snapshot_interval", cfg.Persistence.SnapshotInterval},
+		{"alerting.evaluate_interval", cfg.Alerting.EvaluateInterval},
+		{"archive.interval", cfg.Archive.Interval},
+		{"ha.session_timeout", cfg.HA.SessionTimeout},
+		{"quotas.violation_interval", cfg.Quotas.ViolationInterval},
+	}
+	for _, interval := range intervals {
+		if interval.value <= 0 {
+			return fmt.Errorf("%s must be positive, got %s", interval.name, time.Duration(interval.value))
+		}
+	}
+	sizes := []struct {
+		name  string
+		value int
+	}{
+		{"indexing.queue_size", cfg.Indexing.QueueSize},
+		{"indexing.batch_size", cfg.Indexing.BatchSize},
+	}
+	for _, size := range sizes {
+		if size.value <= 0 {
+			return fmt.Errorf("%s must be positive, got %d", size.name, size.value)
+		}
+	}
+	return nil
+}
+
+// Timeouts returns the timeouts that apply to nodes of the given service
+func (rc *RegistryConfig) Timeouts(service string) ServiceTimeouts {
+	timeouts := rc.Default
+	if override, ok := rc.Services[service]; ok {
+		if override.SuspectAfter > 0 {
+			timeouts.SuspectAfter = override.SuspectAfter
+		}
+		if override.DownAfter > 0 {
+			timeouts.DownAfter = override.DownAfter
+		}
+	}
+	return timeouts
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		password string // ELASTICSEARCH_PASSWORD
+		err      string
+	}{
+		{"file credentials", `{"store": {"elasticsearch": {"username": "elastic", "password": "secret"}}}`, "", ""},
+		{"environment credentials", `{"store": {"elasticsearch": {"username": "elastic"}}}`, "secret", ""},
+		{"no password", `{"store": {"elasticsearch": {"username": "elastic"}}}`, "", "store.elasticsearch needs a username and password, set them in the config file or with ELASTICSEARCH_USERNAME and ELASTICSEARCH_PASSWORD"},
+		{"local store", `{"store": {"backend": "local"}}`, "", ""},
+		{"bad interval", `{"store": {"backend": "local"}, "archive": {"interval": "0s"}}`, "", "archive.interval must be positive, got 0s"},
+		{"bad session timeout", `{"store": {"backend": "local"}, "ha": {"session_timeout": "-1s"}}`, "", "ha.session_timeout must be positive, got -1s"},
+		{"bad violation interval", `{"store": {"backend": "local"}, "quotas": {"violation_interval": "0s"}}`, "", "quotas.violation_interval must be positive, got 0s"},
+		{"bad queue size", `{"store": {"backend": "local"}, "indexing": {"queue_size": 0}}`, "", "indexing.queue_size must be positive, got 0"},
+		{"bad batch size", `{"store": {"backend": "local"}, "indexing": {"batch_size": -5}}`, "", "indexing.batch_size must be positive, got -5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(envElasticUsername, "")
+			t.Setenv(envElasticPassword, tt.password)
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0o644); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+			cfg, err := LoadConfig(path)
+			if tt.err != "" {
+				if want := "invalid config " + path + ": " + tt.err; err == nil || err.Error() != want {
+					t.Errorf("error = %v, want %s", err, want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if tt.password != "" && cfg.Store.Elasticsearch.Password != tt.password {
+				t.Errorf("password = %q, want the environment's", cfg.Store.Elasticsearch.Password)
+			}
+		})
+	}
+}
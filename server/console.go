@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/store"
+
+	"github.com/fatih/color"
+)
+
+// Console output formats
+const (
+	ConsoleColor  = "color"
+	ConsoleLogfmt = "logfmt"
+	ConsoleJSON   = "json"
+	ConsoleNone   = "none"
+)
+
+// consoleTimeLayout is how event times are shown in the colored format
+const consoleTimeLayout = "2006-01-02 15:04:05.000"
+
+// ConsoleConfig controls what the server echoes to its standard output
+type ConsoleConfig struct {
+	Format   string   `json:"format"` // color, logfmt, json or none
+	Levels   []string `json:"levels"`
+	Services []string `json:"services"`
+	Nodes    []int    `json:"nodes"`
+	Contains string   `json:"contains"`
+}
+
+// Colors of the colored format, built once
+var (
+	infoColor    = color.New(color.FgGreen).SprintFunc()
+	warnColor    = color.New(color.FgYellow).SprintFunc()
+	errorColor   = color.New(color.FgRed).SprintFunc()
+	otherColor   = color.New(color.FgBlue).SprintFunc()
+	messageColor = color.New(color.FgWhite).SprintFunc()
+	timeColor    = color.New(color.FgHiWhite).SprintFunc()
+	serviceColor = color.New(color.FgCyan).SprintFunc()
+)
+
+// logfmtFirst are the fields logfmt lines start with, the rest follow in name order
+var logfmtFirst = []string{store.FieldTimestamp, "log_level", "message_type", "service_name", "node_id", "message"}
+
+// Console renders processed logs to the terminal
+type Console struct {
+	mu     sync.Mutex
+	out    io.Writer
+	format string
+	filter TailFilter
+}
+
+// NewConsole creates a console writing to standard output
+func NewConsole(cfg ConsoleConfig) (*Console, error) {
+	switch cfg.Format {
+	case "":
+		cfg.Format = ConsoleColor
+	case ConsoleColor, ConsoleLogfmt, ConsoleJSON, ConsoleNone:
+	default:
+		return nil, fmt.Errorf("unknown console format %q", cfg.Format)
+	}
+	return &Console{
+		out:    os.Stdout,
+		format: cfg.Format,
+		filter: TailFilter{Levels: cfg.Levels, Services: cfg.Services, Nodes: cfg.Nodes, Contains: cfg.Contains},
+	}, nil
+}
+
+// Print renders a log if it passes the console filter; with the none format it costs nothing
+func (c *Console) Print(logData map[string]interface{}) {
+	if c == nil || c.format == ConsoleNone || !c.filter.Match(logData) {
+		return
+	}
+
+	var line []byte
+	switch c.format {
+	case ConsoleJSON:
+		data, err := json.Marshal(logData)
+		if err != nil {
+			return
+		}
+		line = append(data, '\n')
+	case ConsoleLogfmt:
+		line = formatLogfmt(logData)
+	default:
+		line = []byte(formatColor(logData))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.out.Write(line)
+}
+
+// formatColor renders a log as a colored line showing when the event happened
+func formatColor(logData map[string]interface{}) string {
+	when := time.Now()
+	if eventTime, ok := store.EventTime(logData); ok {
+		when = eventTime.Local()
+	}
+	timestamp := timeColor(when.Format(consoleTimeLayout))
+	nodeID := store.Document(logData).String("node_id")
+
+	switch {
+	case logData["log_level"] == "INFO":
+		return fmt.Sprintf("  %s - %s [%s] - %s\n", infoColor(logData["log_level"]), messageColor(logData["message"]), serviceColor(logData["service_name"]), timestamp)
+	case logData["log_level"] == "WARN":
+		return fmt.Sprintf("  %s - %s [%s] - %s\n", warnColor(logData["log_level"]), messageColor(logData["message"]), serviceColor(logData["service_name"]), timestamp)
+	case logData["log_level"] == "ERROR":
+		return fmt.Sprintf("  %s - %s [%s] - %s\n", errorColor(logData["log_level"]), messageColor(logData["message"]), serviceColor(logData["service_name"]), timestamp)
+	case logData["message_type"] == "REGISTRATION":
+		return fmt.Sprintf("  %s - %s [%s] - %s\n", otherColor(logData["message_type"]), messageColor(logData["service_name"]), serviceColor(nodeID), timestamp)
+	case logData["message_type"] == "HEARTBEAT":
+		return fmt.Sprintf("  %s - %s [%s] - %s\n", otherColor(logData["message_type"]), messageColor(logData["status"]), serviceColor(nodeID), timestamp)
+	default:
+		return fmt.Sprintf("%s %s\n", logData, timestamp)
+	}
+}
+
+// formatLogfmt renders a log as key=value pairs, quoting values when needed
+func formatLogfmt(logData map[string]interface{}) []byte {
+	var buf bytes.Buffer
+	write := func(key string, value interface{}) {
+		if buf.Len() > 0 {
+			buf.WriteByte(' ')
+		}
+		var text string
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			data, _ := json.Marshal(value)
+			text = string(data)
+		default:
+			text = store.FormatValue(value)
+		}
+		buf.WriteString(key)
+		buf.WriteByte('=')
+		if text == "" || strings.ContainsAny(text, " =\"\t\n") {
+			text = strconv.Quote(text)
+		}
+		buf.WriteString(text)
+	}
+
+	done := make(map[string]bool, len(logfmtFirst))
+	for _, key := range logfmtFirst {
+		if value, ok := logData[key]; ok {
+			write(key, value)
+			done[key] = true
+		}
+	}
+	var rest []string
+	for key := range logData {
+		if !done[key] {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+	for _, key := range rest {
+		write(key, logData[key])
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
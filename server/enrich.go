@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"example.com/store"
+)
+
+// EnrichConfig controls what the server adds to logs before storing them
+type EnrichConfig struct {
+	NormalizeCase bool              `json:"normalize_case"` // upper-case levels and types, lower-case services
+	NodeMetadata  bool              `json:"node_metadata"`  // add what the registry knows about the node
+	IngestDelay   bool              `json:"ingest_delay"`   // add the time between the event and its ingestion
+	Tags          map[string]string `json:"tags"`           // added to every log under "tags"
+}
+
+// Enricher adds registry metadata, ingestion delay and static tags to logs
+type Enricher struct {
+	cfg      EnrichConfig
+	registry *Registry
+}
+
+// NewEnricher creates an enricher reading node metadata from the registry
+func NewEnricher(cfg EnrichConfig, registry *Registry) *Enricher {
+	return &Enricher{cfg: cfg, registry: registry}
+}
+
+// Normalize fixes the casing of the level, message type and service of a log, so the
+// same service isn't tracked, filtered and counted under several names
+func (e *Enricher) Normalize(logData map[string]interface{}) {
+	if !e.cfg.NormalizeCase {
+		return
+	}
+	for _, field := range []string{"log_level", "message_type"} {
+		if value, ok := logData[field].(string); ok {
+			logData[field] = strings.ToUpper(strings.TrimSpace(value))
+		}
+	}
+	if service, ok := logData["service_name"].(string); ok {
+		logData["service_name"] = strings.ToLower(strings.TrimSpace(service))
+	}
+}
+
+// Enrich joins a log with what the registry knows about its node, adds the ingestion
+// delay and the static tags; fields the log already has are never overwritten
+func (e *Enricher) Enrich(logData map[string]interface{}) {
+	if e.cfg.NodeMetadata {
+		if nodeID, ok := logData["node_id"].(float64); ok {
+			if node, known := e.registry.Get(int(nodeID)); known {
+				// Heartbeats and some services only send the node ID
+				if service, _ := logData["service_name"].(string); service == "" && node.ServiceName != "" {
+					logData["service_name"] = node.ServiceName
+				}
+				metadata := map[string]interface{}{
+					"service":       node.ServiceName,
+					"state":         string(node.State),
+					"registered_at": node.RegisteredAt.UTC().Format(time.RFC3339Nano),
+				}
+				if node.Host != "" {
+					metadata["host"] = node.Host
+				}
+				if node.Version != "" {
+					metadata["version"] = node.Version
+				}
+				if node.Address != "" {
+					metadata["address"] = node.Address
+				}
+				if _, exists := logData["node"]; !exists {
+					logData["node"] = metadata
+				}
+			}
+		}
+	}
+
+	if e.cfg.IngestDelay {
+		// Only the time the producer stamped counts; @timestamp falls back to the ingestion time
+		produced, _ := logData["timestamp"].(string)
+		ingested, _ := logData["ingested_at"].(string)
+		eventTime, err := store.ParseTimestamp(produced)
+		ingestedAt, ingestedErr := time.Parse(time.RFC3339Nano, ingested)
+		if err == nil && ingestedErr == nil {
+			logData["ingest_delay_ms"] = ingestedAt.Sub(eventTime).Milliseconds()
+		}
+	}
+
+	if len(e.cfg.Tags) > 0 {
+		tags, isObject := logData["tags"].(map[string]interface{})
+		if _, exists := logData["tags"]; !exists {
+			tags, isObject = make(map[string]interface{}, len(e.cfg.Tags)), true
+		}
+		// Tags the log brought in some other shape are left alone
+		if isObject {
+			for key, value := range e.cfg.Tags {
+				if _, exists := tags[key]; !exists {
+					tags[key] = value
+				}
+			}
+			logData["tags"] = tags
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"example.com/store"
+)
+
+// indexItem is a log waiting to be stored; ack is called once the store has seen it.
+// An item without a log only acknowledges a skipped message, in order with the others.
+type indexItem struct {
+	doc store.Document
+	ack func()
+}
+
+// Indexer stores logs in batches from a bounded queue, so consumers don't wait on the
+// store for every message and shutdown knows exactly what is still in flight
+type Indexer struct {
+	store         store.LogStore
+	metrics       *Metrics
+	queue         chan indexItem
+	batchSize     int
+	flushInterval time.Duration
+	retryBackoff  time.Duration
+	done          chan struct{}
+	stop          chan struct{}
+	gaveUp        bool
+}
+
+// Backoff between attempts to store a batch, doubling up to the maximum
+const (
+	indexRetryBackoff    = time.Second
+	indexMaxRetryBackoff = time.Minute
+)
+
+// NewIndexer creates an indexer; call Run to start it and Drain to stop it
+func NewIndexer(s store.LogStore, metrics *Metrics, cfg IndexingConfig) *Indexer {
+	return &Indexer{
+		store:         s,
+		metrics:       metrics,
+		queue:         make(chan indexItem, cfg.QueueSize),
+		batchSize:     cfg.BatchSize,
+		flushInterval: time.Duration(cfg.FlushInterval),
+		retryBackoff:  indexRetryBackoff,
+		done:          make(chan struct{}),
+		stop:          make(chan struct{}),
+	}
+}
+
+// Enqueue adds a log to the queue, blocking while the queue is full
+func (ix *Indexer) Enqueue(doc store.Document, ack func()) {
+	ix.queue <- indexItem{doc: doc, ack: ack}
+}
+
+// Skip queues the acknowledgement of a message that isn't stored, so its offset is only
+// marked once the logs consumed before it are stored
+func (ix *Indexer) Skip(ack func()) {
+	ix.queue <- indexItem{ack: ack}
+}
+
+// Run stores queued logs until the queue is closed and empty
+func (ix *Indexer) Run() {
+	defer close(ix.done)
+	ticker := time.NewTicker(ix.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]indexItem, 0, ix.batchSize)
+	for {
+		select {
+		case item, ok := <-ix.queue:
+			if !ok {
+				ix.flush(batch)
+				return
+			}
+			batch = append(batch, item)
+			if len(batch) >= ix.batchSize {
+				ix.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			ix.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// flush stores a batch and acknowledges every log in it. A failed batch is retried with
+// backoff, holding back the consumers once the queue fills up; if Drain gives up on it, it
+// and everything after it stay unacknowledged, so they are consumed again after a restart
+func (ix *Indexer) flush(batch []indexItem) {
+	if len(batch) == 0 {
+		return
+	}
+	docs := make([]store.Document, 0, len(batch))
+	for _, item := range batch {
+		if item.doc != nil {
+			docs = append(docs, item.doc)
+		}
+	}
+
+	var took time.Duration
+	backoff := ix.retryBackoff
+	for len(docs) > 0 && !ix.gaveUp {
+		start := time.Now()
+		err := ix.store.Append(docs...)
+		took = time.Since(start)
+		if err == nil {
+			break
+		}
+		fmt.Printf("Failed to index %d logs, retrying in %s: %v\n", len(docs), backoff, err)
+		select {
+		case <-time.After(backoff):
+			backoff = min(2*backoff, indexMaxRetryBackoff)
+		case <-ix.stop:
+			ix.gaveUp = true
+		}
+	}
+	if ix.gaveUp {
+		err := fmt.Errorf("gave up storing %d logs", len(docs))
+		for _, doc := range docs {
+			ix.metrics.Indexed(doc, took, err)
+		}
+		return
+	}
+
+	for _, item := range batch {
+		if item.doc != nil {
+			ix.metrics.Indexed(item.doc, took, nil)
+		}
+		if item.ack != nil {
+			item.ack()
+		}
+	}
+}
+
+// Drain stops accepting logs and waits for the queue to be stored, giving up after timeout
+func (ix *Indexer) Drain(timeout time.Duration) error {
+	close(ix.queue)
+	select {
+	case <-ix.done:
+		return nil
+	case <-time.After(timeout):
+		close(ix.stop)
+		return fmt.Errorf("timed out after %s with %d logs still queued", timeout, len(ix.queue))
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/store"
+)
+
+// failingStore fails the first appends, then stores documents in memory
+type failingStore struct {
+	store.LogStore
+	mu       sync.Mutex
+	failures int
+	attempts int
+	docs     []store.Document
+}
+
+func (s *failingStore) Append(docs ...store.Document) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts++
+	if s.failures < 0 || s.attempts <= s.failures {
+		return errors.New("store unavailable")
+	}
+	s.docs = append(s.docs, docs...)
+	return nil
+}
+
+func TestIndexerRetriesFailedBatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int // -1 fails every time
+		acked    string
+		stored   int
+		drained  bool
+	}{
+		{"stored", 0, "abc", 2, true},
+		{"retried", 2, "abc", 2, true},
+		{"given up", -1, "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := &failingStore{failures: tt.failures}
+			ix := NewIndexer(logs, NewMetrics(NewRegistry(RegistryConfig{}, nil)), IndexingConfig{QueueSize: 10, BatchSize: 2, FlushInterval: Duration(time.Hour)})
+			ix.retryBackoff = time.Millisecond
+			go ix.Run()
+
+			var mu sync.Mutex
+			acked := ""
+			ack := func(name string) func() {
+				return func() {
+					mu.Lock()
+					defer mu.Unlock()
+					acked += name
+				}
+			}
+			ix.Enqueue(store.Document{"message": "a"}, ack("a"))
+			ix.Skip(ack("b"))
+			ix.Enqueue(store.Document{"message": "c"}, ack("c"))
+
+			if err := ix.Drain(100 * time.Millisecond); (err == nil) != tt.drained {
+				t.Errorf("Drain = %v", err)
+			}
+			<-ix.done
+			if acked != tt.acked {
+				t.Errorf("acknowledged %q, want %q", acked, tt.acked)
+			}
+			if len(logs.docs) != tt.stored {
+				t.Errorf("stored %d logs, want %d", len(logs.docs), tt.stored)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// HAConfig controls leader election between server instances
+type HAConfig struct {
+	Enabled        bool     `json:"enabled"`
+	InstanceID     string   `json:"instance_id"` // defaults to the host name
+	Group          string   `json:"group"`
+	Topic          string   `json:"topic"`
+	SessionTimeout Duration `json:"session_timeout"`
+	Replication    int16    `json:"replication_factor"` // of the election topic, when it is created
+}
+
+// leaderPartition is the partition of the election topic whose owner is the leader
+const leaderPartition = 0
+
+// LeaderElector elects one leader among the server instances with a Kafka consumer
+// group: every instance joins the group on the election topic and the one the group
+// coordinator assigns leaderPartition to is the leader until it leaves or stops
+// answering, at which point the partition, and the leadership, moves to another one
+type LeaderElector struct {
+	mu         sync.RWMutex
+	cfg        HAConfig
+	brokers    []string
+	admin      sarama.ClusterAdmin
+	leader     bool
+	since      time.Time
+	onElected  func(ctx context.Context)
+	lastChange time.Time
+}
+
+// LeaderStatus is what the status endpoint reports
+type LeaderStatus struct {
+	InstanceID    string     `json:"instance_id"`
+	Leader        bool       `json:"leader"`
+	LeaderSince   *time.Time `json:"leader_since,omitempty"`
+	CurrentLeader string     `json:"current_leader"`
+	LastChange    *time.Time `json:"last_change,omitempty"`
+	Error         string     `json:"error,omitempty"`
+}
+
+// NewLeaderElector creates an elector; onElected is called with a context that is
+// cancelled when the leadership is lost
+func NewLeaderElector(cfg HAConfig, brokers []string, client sarama.Client, onElected func(ctx context.Context)) (*LeaderElector, error) {
+	if cfg.InstanceID == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get host name for the instance ID: %w", err)
+		}
+		cfg.InstanceID = host
+	}
+	// The admin shares the client, so it is never closed on its own
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cluster admin: %w", err)
+	}
+
+	// Only partition 0 matters, so the topic doesn't need more
+	err = admin.CreateTopic(cfg.Topic, &sarama.TopicDetail{NumPartitions: 1, ReplicationFactor: cfg.Replication}, false)
+	if err != nil && !errors.Is(err, sarama.ErrTopicAlreadyExists) {
+		return nil, fmt.Errorf("failed to create election topic %s: %w", cfg.Topic, err)
+	}
+	return &LeaderElector{cfg: cfg, brokers: brokers, admin: admin, onElected: onElected}, nil
+}
+
+// InstanceID returns the name this instance goes by in the election
+func (le *LeaderElector) InstanceID() string {
+	return le.cfg.InstanceID
+}
+
+// IsLeader reports whether this instance leads; without election (nil) it always does
+func (le *LeaderElector) IsLeader() bool {
+	if le == nil {
+		return true
+	}
+	le.mu.RLock()
+	defer le.mu.RUnlock()
+	return le.leader
+}
+
+// Run takes part in the election until ctx is cancelled
+func (le *LeaderElector) Run(ctx context.Context) {
+	config := sarama.NewConfig()
+	config.ClientID = "logserver"
+	config.Consumer.Offsets.Initial = sarama.OffsetNewest
+	config.Consumer.Group.Session.Timeout = time.Duration(le.cfg.SessionTimeout)
+	config.Consumer.Group.Heartbeat.Interval = time.Duration(le.cfg.SessionTimeout) / 3
+	// The instance ID travels with the group metadata so any instance can tell who leads
+	config.Consumer.Group.Member.UserData = []byte(le.cfg.InstanceID)
+
+	group, err := sarama.NewConsumerGroup(le.brokers, le.cfg.Group, config)
+	if err != nil {
+		log.Printf("Failed to join the leader election: %v", err)
+		return
+	}
+	defer group.Close()
+
+	fmt.Printf("Instance %s joined the leader election\n", le.cfg.InstanceID)
+	for {
+		// Consume returns at every rebalance; join again until we shut down
+		if err := group.Consume(ctx, []string{le.cfg.Topic}, le); err != nil {
+			log.Printf("Leader election failed: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// Setup is called by the consumer group at the start of a session
+func (le *LeaderElector) Setup(sarama.ConsumerGroupSession) error { return nil }
+
+// Cleanup is called by the consumer group at the end of a session
+func (le *LeaderElector) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+
+// ConsumeClaim holds a partition for the length of the session; holding leaderPartition
+// is being the leader
+func (le *LeaderElector) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	if claim.Partition() == leaderPartition {
+		le.setLeader(true)
+		defer le.setLeader(false)
+		if le.onElected != nil {
+			le.onElected(session.Context())
+		}
+	}
+	// Nothing is ever published to the topic, so just wait for the session to end
+	for range claim.Messages() {
+	}
+	<-session.Context().Done()
+	return nil
+}
+
+func (le *LeaderElector) setLeader(leader bool) {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	le.leader = leader
+	le.lastChange = time.Now()
+	if leader {
+		le.since = le.lastChange
+		fmt.Printf("Instance %s is now the leader\n", le.cfg.InstanceID)
+	} else {
+		le.since = time.Time{}
+		fmt.Printf("Instance %s is no longer the leader\n", le.cfg.InstanceID)
+	}
+}
+
+// CurrentLeader asks the group coordinator which instance holds leaderPartition
+func (le *LeaderElector) CurrentLeader() (string, error) {
+	groups, err := le.admin.DescribeConsumerGroups([]string{le.cfg.Group})
+	if err != nil {
+		return "", fmt.Errorf("failed to describe group: %w", err)
+	}
+	for _, group := range groups {
+		for _, member := range group.Members {
+			assignment, err := member.GetMemberAssignment()
+			if err != nil || assignment == nil {
+				continue
+			}
+			for _, partition := range assignment.Topics[le.cfg.Topic] {
+				if partition != leaderPartition {
+					continue
+				}
+				metadata, err := member.GetMemberMetadata()
+				if err != nil || metadata == nil {
+					return member.ClientHost, nil
+				}
+				return string(metadata.UserData), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// Status returns this instance's view of the election
+func (le *LeaderElector) Status() LeaderStatus {
+	le.mu.RLock()
+	status := LeaderStatus{InstanceID: le.cfg.InstanceID, Leader: le.leader}
+	if le.leader {
+		since := le.since
+		status.LeaderSince = &since
+	}
+	if !le.lastChange.IsZero() {
+		lastChange := le.lastChange
+		status.LastChange = &lastChange
+	}
+	le.mu.RUnlock()
+
+	current, err := le.CurrentLeader()
+	if err != nil {
+		status.Error = err.Error()
+	}
+	status.CurrentLeader = current
+	return status
+}
+
+// ServeHTTP reports the election status as JSON
+func (le *LeaderElector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if le == nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": false, "leader": true})
+		return
+	}
+	writeJSON(w, http.StatusOK, le.Status())
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/store"
+
+	"github.com/IBM/sarama"
+)
+
+// indexLatencyBuckets are the upper bounds, in seconds, of the indexing latency histogram
+var indexLatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// counterVec is a set of counters keyed by their label values
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+	values map[string]float64
+}
+
+func newCounterVec(name string, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+// histogram counts observations into cumulative buckets
+type histogram struct {
+	name    string
+	help    string
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(name string, help string, buckets []float64) *histogram {
+	return &histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// partitionProgress remembers how far a consumer got in one partition
+type partitionProgress struct {
+	consumer sarama.PartitionConsumer
+	offsets  sarama.PartitionOffsetManager
+	offset   int64
+}
+
+// Metrics collects the server's Prometheus metrics
+type Metrics struct {
+	mu         sync.Mutex
+	consumed   *counterVec
+	indexed    *counterVec
+	failed     *counterVec
+	dropped    *counterVec
+	latency    *histogram
+	partitions map[string]*partitionProgress // "topic/partition"
+	registry   *Registry
+	start      time.Time
+}
+
+// NewMetrics creates the server metrics; node counts are read from the registry when scraped
+func NewMetrics(registry *Registry) *Metrics {
+	return &Metrics{
+		consumed:   newCounterVec("logserver_messages_consumed_total", "Messages read from Kafka.", "topic"),
+		indexed:    newCounterVec("logserver_messages_indexed_total", "Messages stored in the log store.", "type", "level"),
+		failed:     newCounterVec("logserver_messages_failed_total", "Messages that could not be decoded or stored.", "type", "level", "stage"),
+		dropped:    newCounterVec("logserver_messages_dropped_total", "Messages dropped for going over a quota.", "type", "level", "scope"),
+		latency:    newHistogram("logserver_index_duration_seconds", "Time taken to store messages in the log store.", indexLatencyBuckets),
+		partitions: make(map[string]*partitionProgress),
+		registry:   registry,
+		start:      time.Now(),
+	}
+}
+
+// labelKey joins label values into a map key
+func labelKey(values ...string) string {
+	return strings.Join(values, "\x00")
+}
+
+func (c *counterVec) add(v float64, values ...string) {
+	c.values[labelKey(values...)] += v
+}
+
+// messageLabels returns the type and level labels of a message
+func messageLabels(logData map[string]interface{}) (string, string) {
+	messageType, _ := logData["message_type"].(string)
+	level, _ := logData["log_level"].(string)
+	if messageType == "" {
+		messageType = "unknown"
+	}
+	return messageType, level
+}
+
+// TrackPartition registers a partition consumer and the manager of its committed offset
+// so its lag can be reported
+func (m *Metrics) TrackPartition(topic string, partition int32, consumer sarama.PartitionConsumer, offsets sarama.PartitionOffsetManager) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.partitions[fmt.Sprintf("%s/%d", topic, partition)] = &partitionProgress{consumer: consumer, offsets: offsets, offset: -1}
+}
+
+// lag returns how many messages of the partition aren't stored yet: everything after the
+// committed offset, which only moves once a log is stored. Before the first commit, it
+// counts from the last message consumed, or is 0 when nothing was consumed either,
+// since the consumer then started at the newest offset.
+func (progress *partitionProgress) lag() int64 {
+	next := int64(-1)
+	if progress.offsets != nil {
+		next, _ = progress.offsets.NextOffset()
+	}
+	if next < 0 {
+		if progress.offset < 0 {
+			return 0
+		}
+		next = progress.offset + 1
+	}
+	if lag := progress.consumer.HighWaterMarkOffset() - next; lag > 0 {
+		return lag
+	}
+	return 0
+}
+
+// Consumed records a message read from Kafka
+func (m *Metrics) Consumed(message *sarama.ConsumerMessage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.consumed.add(1, message.Topic)
+	if progress, ok := m.partitions[fmt.Sprintf("%s/%d", message.Topic, message.Partition)]; ok {
+		progress.offset = message.Offset
+	}
+}
+
+// Indexed records a message stored, or not, in the log store and how long it took
+func (m *Metrics) Indexed(logData map[string]interface{}, took time.Duration, err error) {
+	messageType, level := messageLabels(logData)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latency.observe(took.Seconds())
+	if err != nil {
+		m.failed.add(1, messageType, level, "index")
+	} else {
+		m.indexed.add(1, messageType, level)
+	}
+}
+
+// Failed records a message dropped before indexing, e.g. because it could not be decoded
+func (m *Metrics) Failed(logData map[string]interface{}, stage string) {
+	messageType, level := messageLabels(logData)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failed.add(1, messageType, level, stage)
+}
+
+// Dropped records a message dropped for going over the quota of its node or service
+func (m *Metrics) Dropped(logData map[string]interface{}, scope string) {
+	messageType, level := messageLabels(logData)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dropped.add(1, messageType, level, scope)
+}
+
+// formatLabels renders label names and values as {a="x",b="y"}
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	parts := make([]string, len(names))
+	for i, name := range names {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(values[i])
+		parts[i] = fmt.Sprintf(`%s="%s"`, name, value)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// formatValue renders a sample value
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprintf("%g", v)
+}
+
+func (c *counterVec) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, strings.Split(key, "\x00")), formatValue(c.values[key]))
+	}
+}
+
+func (h *histogram) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatValue(bound), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", h.name, formatValue(h.sum), h.name, h.count)
+}
+
+// writeGauge writes a single gauge sample with its header
+func writeGauge(w io.Writer, name string, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, formatValue(value))
+}
+
+// ServeHTTP writes all metrics in the Prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	m.mu.Lock()
+	m.consumed.write(w)
+	m.indexed.write(w)
+	m.failed.write(w)
+	m.dropped.write(w)
+	m.latency.write(w)
+
+	fmt.Fprintf(w, "# HELP logserver_consumer_lag Messages in the partition not stored yet.\n# TYPE logserver_consumer_lag gauge\n")
+	keys := make([]string, 0, len(m.partitions))
+	for key := range m.partitions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		topic, partition, _ := strings.Cut(key, "/")
+		fmt.Fprintf(w, "logserver_consumer_lag%s %d\n", formatLabels([]string{"topic", "partition"}, []string{topic, partition}), m.partitions[key].lag())
+	}
+	m.mu.Unlock()
+
+	counts := make(map[NodeState]int)
+	for _, node := range m.registry.Nodes() {
+		counts[node.State]++
+	}
+	fmt.Fprintf(w, "# HELP logserver_nodes Known nodes by lifecycle state.\n# TYPE logserver_nodes gauge\n")
+	for _, state := range nodeStates {
+		fmt.Fprintf(w, "logserver_nodes{status=\"%s\"} %d\n", state, counts[state])
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	writeGauge(w, "go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+	writeGauge(w, "go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", float64(mem.Alloc))
+	writeGauge(w, "go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", float64(mem.HeapInuse))
+	writeGauge(w, "go_memstats_sys_bytes", "Number of bytes obtained from the system.", float64(mem.Sys))
+	fmt.Fprintf(w, "# HELP go_gc_cycles_total Number of completed GC cycles.\n# TYPE go_gc_cycles_total counter\ngo_gc_cycles_total %d\n", mem.NumGC)
+	writeGauge(w, "go_gc_pause_last_seconds", "Duration of the last GC pause.", float64(mem.PauseNs[(mem.NumGC+255)%256])/1e9)
+	writeGauge(w, "process_start_time_seconds", "Start time of the process since the unix epoch.", float64(m.start.Unix()))
+}
+
+// HealthChecker reports whether the services the server depends on are reachable
+type HealthChecker struct {
+	Kafka   sarama.Client
+	Store   store.LogStore
+	Timeout time.Duration
+}
+
+// Check returns the error of every dependency that is not reachable
+func (h *HealthChecker) Check() map[string]string {
+	problems := make(map[string]string)
+
+	if h.Kafka == nil {
+		problems["kafka"] = "no client"
+	} else if err := h.probe(func() error { return h.Kafka.RefreshMetadata() }); err != nil {
+		problems["kafka"] = err.Error()
+	}
+	if err := h.probe(h.Store.Ping); err != nil {
+		problems["store"] = err.Error()
+	}
+	return problems
+}
+
+// probe runs a check, giving up on it after the timeout
+func (h *HealthChecker) probe(check func() error) error {
+	result := make(chan error, 1)
+	go func() { result <- check() }()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(h.Timeout):
+		return errors.New("timed out")
+	}
+}
+
+// serveHealth always answers, it only tells that the process is alive
+func serveHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// ServeHTTP answers the readiness probe: 503 when Kafka or the log store is unreachable
+func (h *HealthChecker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	problems := h.Check()
+	if len(problems) > 0 {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"status": "unavailable", "problems": problems})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
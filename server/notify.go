@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Notification channel types
+const (
+	ChannelWebhook = "webhook"
+	ChannelSlack   = "slack"
+	ChannelEmail   = "email"
+	ChannelCommand = "command"
+)
+
+// defaultTemplate is used by channels that don't set their own
+const defaultTemplate = `[{{upper .State}}] {{.Rule}} ({{.Severity}}): {{.Message}}`
+
+// ChannelConfig describes one notification channel
+type ChannelConfig struct {
+	Name         string            `json:"name"`
+	Type         string            `json:"type"`
+	URL          string            `json:"url"`
+	Headers      map[string]string `json:"headers"`
+	SMTPHost     string            `json:"smtp_host"`
+	SMTPPort     int               `json:"smtp_port"`
+	Username     string            `json:"username"`
+	Password     string            `json:"password"`
+	From         string            `json:"from"`
+	To           []string          `json:"to"`
+	Subject      string            `json:"subject"`
+	Command      string            `json:"command"`
+	Args         []string          `json:"args"`
+	Template     string            `json:"template"`
+	Retries      int               `json:"retries"`
+	RetryBackoff Duration          `json:"retry_backoff"`
+	Timeout      Duration          `json:"timeout"`
+	SendResolved *bool             `json:"send_resolved"`
+}
+
+// NotificationsConfig lists the channels and the ones used by rules that don't pick their own
+type NotificationsConfig struct {
+	Channels []ChannelConfig `json:"channels"`
+	Default  []string        `json:"default"`
+}
+
+// channel is a configured channel with its templates parsed
+type channel struct {
+	cfg     ChannelConfig
+	body    *template.Template
+	subject *template.Template
+	client  *http.Client
+}
+
+// Notifier routes alert state changes to notification channels
+type Notifier struct {
+	channels map[string]*channel
+	defaults []string
+	routes   map[string][]string // rule name -> channel names
+}
+
+var templateFuncs = template.FuncMap{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"time":  func(t time.Time) string { return t.Format("2006-01-02 15:04:05") },
+}
+
+// NewNotifier checks the channel configuration and the channels referenced by the rules
+func NewNotifier(cfg NotificationsConfig, rules []AlertRule) (*Notifier, error) {
+	n := &Notifier{
+		channels: make(map[string]*channel),
+		defaults: cfg.Default,
+		routes:   make(map[string][]string),
+	}
+
+	for _, channelCfg := range cfg.Channels {
+		if channelCfg.Name == "" {
+			return nil, fmt.Errorf("notification channel without a name")
+		}
+		if _, exists := n.channels[channelCfg.Name]; exists {
+			return nil, fmt.Errorf("duplicate notification channel %q", channelCfg.Name)
+		}
+
+		switch channelCfg.Type {
+		case ChannelWebhook, ChannelSlack:
+			if channelCfg.URL == "" {
+				return nil, fmt.Errorf("channel %q needs a url", channelCfg.Name)
+			}
+		case ChannelEmail:
+			if channelCfg.SMTPHost == "" || channelCfg.From == "" || len(channelCfg.To) == 0 {
+				return nil, fmt.Errorf("channel %q needs smtp_host, from and to", channelCfg.Name)
+			}
+			if channelCfg.SMTPPort == 0 {
+				channelCfg.SMTPPort = 25
+			}
+		case ChannelCommand:
+			if channelCfg.Command == "" {
+				return nil, fmt.Errorf("channel %q needs a command", channelCfg.Name)
+			}
+		default:
+			return nil, fmt.Errorf("channel %q has unknown type %q", channelCfg.Name, channelCfg.Type)
+		}
+
+		if channelCfg.Template == "" {
+			channelCfg.Template = defaultTemplate
+		}
+		if channelCfg.Subject == "" {
+			channelCfg.Subject = `[{{upper .State}}] {{.Rule}}`
+		}
+		if channelCfg.RetryBackoff <= 0 {
+			channelCfg.RetryBackoff = Duration(time.Second)
+		}
+		if channelCfg.Timeout <= 0 {
+			channelCfg.Timeout = Duration(10 * time.Second)
+		}
+
+		body, err := template.New(channelCfg.Name).Funcs(templateFuncs).Parse(channelCfg.Template)
+		if err != nil {
+			return nil, fmt.Errorf("channel %q has an invalid template: %w", channelCfg.Name, err)
+		}
+		subject, err := template.New(channelCfg.Name + "-subject").Funcs(templateFuncs).Parse(channelCfg.Subject)
+		if err != nil {
+			return nil, fmt.Errorf("channel %q has an invalid subject: %w", channelCfg.Name, err)
+		}
+
+		n.channels[channelCfg.Name] = &channel{
+			cfg:     channelCfg,
+			body:    body,
+			subject: subject,
+			client:  &http.Client{Timeout: time.Duration(channelCfg.Timeout)},
+		}
+	}
+
+	for _, name := range n.defaults {
+		if _, ok := n.channels[name]; !ok {
+			return nil, fmt.Errorf("unknown default notification channel %q", name)
+		}
+	}
+	for _, rule := range rules {
+		for _, name := range rule.Notify {
+			if _, ok := n.channels[name]; !ok {
+				return nil, fmt.Errorf("alert rule %q uses unknown notification channel %q", rule.Name, name)
+			}
+		}
+		if len(rule.Notify) > 0 {
+			n.routes[rule.Name] = rule.Notify
+		}
+	}
+	return n, nil
+}
+
+// Notify sends a firing or resolved alert to the channels of its rule without blocking the caller
+func (n *Notifier) Notify(alert Alert) {
+	if alert.State == AlertPending {
+		return
+	}
+	names, ok := n.routes[alert.Rule]
+	if !ok {
+		names = n.defaults
+	}
+
+	for _, name := range names {
+		ch := n.channels[name]
+		if alert.State == AlertResolved && ch.cfg.SendResolved != nil && !*ch.cfg.SendResolved {
+			continue
+		}
+		go func() {
+			if err := ch.sendWithRetries(alert); err != nil {
+				log.Printf("Failed to notify %s about %s: %v", ch.cfg.Name, alert.ID, err)
+			}
+		}()
+	}
+}
+
+// Test sends a made-up firing alert to one channel, or to all of them when name is empty,
+// and waits for the results
+func (n *Notifier) Test(name string) error {
+	now := time.Now()
+	alert := Alert{
+		ID:        "test/server",
+		Rule:      "test",
+		Type:      RuleErrorCount,
+		Severity:  "info",
+		Service:   "server",
+		State:     AlertFiring,
+		Value:     1,
+		Threshold: 0,
+		Message:   "This is a test notification from the logging server",
+		ActiveAt:  now,
+		FiredAt:   now,
+	}
+
+	var names []string
+	if name != "" {
+		if _, ok := n.channels[name]; !ok {
+			return fmt.Errorf("unknown notification channel %q", name)
+		}
+		names = []string{name}
+	} else {
+		for channelName := range n.channels {
+			names = append(names, channelName)
+		}
+		sort.Strings(names)
+	}
+
+	var failed []string
+	for _, channelName := range names {
+		ch := n.channels[channelName]
+		if err := ch.sendWithRetries(alert); err != nil {
+			fmt.Printf("  %s (%s): FAILED - %v\n", channelName, ch.cfg.Type, err)
+			failed = append(failed, channelName)
+		} else {
+			fmt.Printf("  %s (%s): OK\n", channelName, ch.cfg.Type)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to notify %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// sendWithRetries sends an alert, retrying with a doubling backoff
+func (ch *channel) sendWithRetries(alert Alert) error {
+	backoff := time.Duration(ch.cfg.RetryBackoff)
+	var err error
+	for attempt := 0; attempt <= ch.cfg.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = ch.send(alert); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// render executes a template against an alert
+func render(tmpl *template.Template, alert Alert) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, alert); err != nil {
+		return "", fmt.Errorf("failed to render template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// send delivers an alert once
+func (ch *channel) send(alert Alert) error {
+	text, err := render(ch.body, alert)
+	if err != nil {
+		return err
+	}
+
+	switch ch.cfg.Type {
+	case ChannelWebhook:
+		return ch.post(map[string]interface{}{"alert": alert, "text": text})
+	case ChannelSlack:
+		return ch.post(slackPayload(alert, text))
+	case ChannelEmail:
+		subject, err := render(ch.subject, alert)
+		if err != nil {
+			return err
+		}
+		return ch.mail(subject, text)
+	case ChannelCommand:
+		return ch.run(alert, text)
+	}
+	return fmt.Errorf("unknown channel type %q", ch.cfg.Type)
+}
+
+// slackPayload builds a Slack incoming webhook message
+func slackPayload(alert Alert, text string) map[string]interface{} {
+	color := "warning"
+	switch {
+	case alert.State == AlertResolved:
+		color = "good"
+	case alert.Severity == "critical":
+		color = "danger"
+	}
+	return map[string]interface{}{
+		"text": text,
+		"attachments": []map[string]interface{}{
+			{
+				"color": color,
+				"fields": []map[string]interface{}{
+					{"title": "Rule", "value": alert.Rule, "short": true},
+					{"title": "Service", "value": alert.Service, "short": true},
+					{"title": "State", "value": alert.State, "short": true},
+					{"title": "Value", "value": strconv.FormatFloat(alert.Value, 'g', -1, 64), "short": true},
+				},
+			},
+		},
+	}
+}
+
+// post sends a JSON payload to the channel URL
+func (ch *channel) post(payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, ch.cfg.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range ch.cfg.Headers {
+		req.Header.Set(key, value)
+	}
+
+	res, err := ch.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", res.Status)
+	}
+	return nil
+}
+
+// mail sends the alert as a plain text email
+func (ch *channel) mail(subject string, text string) error {
+	addr := net.JoinHostPort(ch.cfg.SMTPHost, strconv.Itoa(ch.cfg.SMTPPort))
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", ch.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(ch.cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", headerText(subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(text, "\n", "\r\n"))
+	msg.WriteString("\r\n")
+
+	var auth smtp.Auth
+	if ch.cfg.Username != "" {
+		auth = smtp.PlainAuth("", ch.cfg.Username, ch.cfg.Password, ch.cfg.SMTPHost)
+	}
+	return smtp.SendMail(addr, auth, ch.cfg.From, ch.cfg.To, msg.Bytes())
+}
+
+// headerText makes text safe for a mail header: line breaks, which would start new
+// headers, become spaces and non-ASCII text is Q-encoded
+func headerText(text string) string {
+	text = strings.Join(strings.FieldsFunc(text, func(r rune) bool { return r == '\r' || r == '\n' }), " ")
+	return mime.QEncoding.Encode("utf-8", text)
+}
+
+// run executes the channel command with the rendered text on stdin and the alert in ALERT_* variables
+func (ch *channel) run(alert Alert, text string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(ch.cfg.Timeout))
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, ch.cfg.Command, ch.cfg.Args...)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Env = append(os.Environ(),
+		"ALERT_ID="+alert.ID,
+		"ALERT_RULE="+alert.Rule,
+		"ALERT_STATE="+alert.State,
+		"ALERT_SEVERITY="+alert.Severity,
+		"ALERT_SERVICE="+alert.Service,
+		"ALERT_VALUE="+strconv.FormatFloat(alert.Value, 'g', -1, 64),
+		"ALERT_MESSAGE="+alert.Message,
+	)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", ch.cfg.Command, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testAlert() Alert {
+	at := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	return Alert{
+		ID:        "errors/cache",
+		Rule:      "errors",
+		Type:      RuleErrorCount,
+		Severity:  "critical",
+		Service:   "cache",
+		State:     AlertFiring,
+		Value:     12,
+		Threshold: 10,
+		Message:   "12 ERROR logs in 5m",
+		ActiveAt:  at,
+		FiredAt:   at,
+	}
+}
+
+// newTestNotifier creates a notifier with one channel, failing the test on error
+func newTestNotifier(t *testing.T, cfg ChannelConfig) *Notifier {
+	t.Helper()
+	cfg.Name = "test"
+	cfg.RetryBackoff = Duration(time.Millisecond)
+	n, err := NewNotifier(NotificationsConfig{Channels: []ChannelConfig{cfg}}, nil)
+	if err != nil {
+		t.Fatalf("NewNotifier: %v", err)
+	}
+	return n
+}
+
+func TestNotifyWebhooks(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     ChannelConfig
+		alert   func(*Alert)
+		status  int
+		retries int
+		check   func(t *testing.T, payload map[string]interface{})
+		fails   bool
+	}{
+		{
+			name:  "webhook",
+			cfg:   ChannelConfig{Type: ChannelWebhook, Headers: map[string]string{"X-Token": "secret"}},
+			alert: func(*Alert) {},
+			check: func(t *testing.T, payload map[string]interface{}) {
+				if payload["text"] != "[FIRING] errors (critical): 12 ERROR logs in 5m" {
+					t.Errorf("text = %v", payload["text"])
+				}
+				if alert, _ := payload["alert"].(map[string]interface{}); alert["alert_id"] != "errors/cache" {
+					t.Errorf("alert = %v", payload["alert"])
+				}
+			},
+		},
+		{
+			name:  "custom template",
+			cfg:   ChannelConfig{Type: ChannelWebhook, Template: "{{.Service}} at {{time .FiredAt}}"},
+			alert: func(*Alert) {},
+			check: func(t *testing.T, payload map[string]interface{}) {
+				if payload["text"] != "cache at 2026-10-19 08:00:00" {
+					t.Errorf("text = %v", payload["text"])
+				}
+			},
+		},
+		{
+			name:  "slack firing",
+			cfg:   ChannelConfig{Type: ChannelSlack},
+			alert: func(*Alert) {},
+			check: func(t *testing.T, payload map[string]interface{}) {
+				attachments, _ := payload["attachments"].([]interface{})
+				if len(attachments) != 1 || attachments[0].(map[string]interface{})["color"] != "danger" {
+					t.Errorf("attachments = %v", payload["attachments"])
+				}
+			},
+		},
+		{
+			name:  "slack resolved",
+			cfg:   ChannelConfig{Type: ChannelSlack},
+			alert: func(a *Alert) { a.State = AlertResolved },
+			check: func(t *testing.T, payload map[string]interface{}) {
+				attachments, _ := payload["attachments"].([]interface{})
+				if len(attachments) != 1 || attachments[0].(map[string]interface{})["color"] != "good" {
+					t.Errorf("attachments = %v", payload["attachments"])
+				}
+			},
+		},
+		{
+			name:    "retried",
+			cfg:     ChannelConfig{Type: ChannelWebhook, Retries: 2},
+			alert:   func(*Alert) {},
+			status:  http.StatusBadGateway,
+			retries: 2,
+			fails:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				if r.Header.Get("Content-Type") != "application/json" {
+					t.Errorf("Content-Type = %q", r.Header.Get("Content-Type"))
+				}
+				for key, value := range tt.cfg.Headers {
+					if r.Header.Get(key) != value {
+						t.Errorf("%s = %q, want %q", key, r.Header.Get(key), value)
+					}
+				}
+				var payload map[string]interface{}
+				if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+					t.Errorf("invalid payload: %v", err)
+				}
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+					return
+				}
+				tt.check(t, payload)
+			}))
+			defer server.Close()
+
+			tt.cfg.URL = server.URL
+			ch := newTestNotifier(t, tt.cfg).channels["test"]
+			alert := testAlert()
+			tt.alert(&alert)
+			err := ch.sendWithRetries(alert)
+			if (err != nil) != tt.fails {
+				t.Errorf("sendWithRetries = %v", err)
+			}
+			if got, want := int(requests.Load()), tt.retries+1; got != want {
+				t.Errorf("got %d requests, want %d", got, want)
+			}
+		})
+	}
+}
+
+// fakeSMTP accepts one mail on a local port and returns it through the channel
+func fakeSMTP(t *testing.T) (string, int, <-chan string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	mails := make(chan string, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		text := textproto.NewConn(conn)
+		text.PrintfLine("220 localhost ready")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			switch verb, _, _ := strings.Cut(line, " "); strings.ToUpper(verb) {
+			case "DATA":
+				text.PrintfLine("354 go ahead")
+				data, err := text.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				mails <- string(data)
+				text.PrintfLine("250 queued")
+			case "QUIT":
+				text.PrintfLine("221 bye")
+				return
+			default:
+				text.PrintfLine("250 ok")
+			}
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	return host, portNumber, mails
+}
+
+func TestNotifyEmail(t *testing.T) {
+	tests := []struct {
+		name    string
+		subject string
+		want    string
+	}{
+		{"default subject", "", "Subject: [FIRING] errors"},
+		{"line breaks", "{{.Rule}}\r\nBcc: victim@example.com", "Subject: errors Bcc: victim@example.com"},
+		{"non-ASCII", "{{.Rule}} → {{.Service}}", "Subject: =?utf-8?q?errors_=E2=86=92_cache?="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, port, mails := fakeSMTP(t)
+			ch := newTestNotifier(t, ChannelConfig{
+				Type:     ChannelEmail,
+				SMTPHost: host,
+				SMTPPort: port,
+				From:     "alerts@example.com",
+				To:       []string{"ops@example.com"},
+				Subject:  tt.subject,
+			}).channels["test"]
+			if err := ch.send(testAlert()); err != nil {
+				t.Fatalf("send: %v", err)
+			}
+
+			var mail string
+			select {
+			case mail = <-mails:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("no mail received")
+			}
+			headers, body, _ := strings.Cut(mail, "\n\n")
+			var subjects []string
+			scanner := bufio.NewScanner(strings.NewReader(headers))
+			for scanner.Scan() {
+				if strings.HasPrefix(scanner.Text(), "Subject:") {
+					subjects = append(subjects, scanner.Text())
+				}
+				if strings.HasPrefix(scanner.Text(), "Bcc:") {
+					t.Errorf("subject injected a header: %q", scanner.Text())
+				}
+			}
+			if len(subjects) != 1 || subjects[0] != tt.want {
+				t.Errorf("subject = %q, want %q", subjects, tt.want)
+			}
+			if !strings.Contains(headers, "To: ops@example.com") {
+				t.Errorf("headers = %q", headers)
+			}
+			if strings.TrimSpace(body) != "[FIRING] errors (critical): 12 ERROR logs in 5m" {
+				t.Errorf("body = %q", body)
+			}
+		})
+	}
+}
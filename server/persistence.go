@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// replayIdleTimeout is how long a replay waits for the next message before giving up on a partition
+const replayIdleTimeout = 5 * time.Second
+
+// RegistrySnapshot is the on-disk form of the registry
+type RegistrySnapshot struct {
+	SavedAt time.Time  `json:"saved_at"`
+	Nodes   []NodeInfo `json:"nodes"`
+}
+
+// saveSnapshot writes the registry to path, replacing the previous snapshot atomically
+func saveSnapshot(path string, registry *Registry) error {
+	snapshot := RegistrySnapshot{SavedAt: time.Now(), Nodes: registry.Nodes()}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadSnapshot reads a snapshot written by saveSnapshot; a missing file is not an error
+func loadSnapshot(path string) (*RegistrySnapshot, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	var snapshot RegistrySnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot %s: %w", path, err)
+	}
+	return &snapshot, nil
+}
+
+// snapshotRegistry saves the registry every interval
+func snapshotRegistry(ctx context.Context, path string, interval time.Duration, registry *Registry) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+		if err := saveSnapshot(path, registry); err != nil {
+			log.Printf("Failed to save registry snapshot: %v", err)
+		}
+		// The last snapshot is taken on the way out
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// replayRegistry feeds the REGISTRATION and HEARTBEAT messages published since the given
+// time back into the registry, using the Kafka timestamps as the time they were seen
+func replayRegistry(brokers []string, topic string, since time.Time, registry *Registry, enricher *Enricher) (int, error) {
+	client, err := sarama.NewClient(brokers, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create client: %w", err)
+	}
+	defer client.Close()
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create consumer: %w", err)
+	}
+	defer consumer.Close()
+
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list partitions of %s: %w", topic, err)
+	}
+
+	replayed := 0
+	for _, partition := range partitions {
+		start, err := client.GetOffset(topic, partition, since.UnixMilli())
+		if err != nil {
+			return replayed, fmt.Errorf("failed to find offset in %s/%d: %w", topic, partition, err)
+		}
+		end, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return replayed, fmt.Errorf("failed to find newest offset in %s/%d: %w", topic, partition, err)
+		}
+		// No message after the given time
+		if start == sarama.OffsetNewest || start < 0 || start >= end {
+			continue
+		}
+
+		partitionConsumer, err := consumer.ConsumePartition(topic, partition, start)
+		if err != nil {
+			return replayed, fmt.Errorf("failed to consume %s/%d: %w", topic, partition, err)
+		}
+	consume:
+		for {
+			select {
+			case message := <-partitionConsumer.Messages():
+				var logData map[string]interface{}
+				if err := json.Unmarshal(message.Value, &logData); err == nil {
+					seen := message.Timestamp
+					if seen.IsZero() {
+						seen = time.Now()
+					}
+					enricher.Normalize(logData)
+					if trackNode(registry, logData, seen) {
+						replayed++
+					}
+				}
+				if message.Offset >= end-1 {
+					break consume
+				}
+			case <-time.After(replayIdleTimeout):
+				// The last offsets may not hold messages (e.g. transaction markers)
+				break consume
+			}
+		}
+		partitionConsumer.Close()
+	}
+	return replayed, nil
+}
+
+// restoreRegistry rebuilds the registry from the last snapshot and the recent Kafka history
+func restoreRegistry(cfg *Config, registry *Registry) {
+	persistence := cfg.Persistence
+	since := time.Now().Add(-time.Duration(persistence.ReplayLookback))
+
+	snapshot, err := loadSnapshot(persistence.SnapshotPath)
+	if err != nil {
+		log.Printf("Ignoring registry snapshot: %v", err)
+	} else if snapshot != nil {
+		registry.Restore(snapshot.Nodes)
+		fmt.Printf("Restored %d nodes from %s (saved %s)\n", len(snapshot.Nodes), persistence.SnapshotPath, snapshot.SavedAt.Format("2006-01-02 15:04:05"))
+		// Only replay what happened after the snapshot, with some overlap
+		if snapshot.SavedAt.After(since) {
+			since = snapshot.SavedAt.Add(-time.Duration(persistence.SnapshotInterval))
+		}
+	}
+
+	if persistence.ReplayTopic == "" {
+		return
+	}
+	replayed, err := replayRegistry(cfg.Brokers, persistence.ReplayTopic, since, registry, NewEnricher(cfg.Enrich, registry))
+	if err != nil {
+		log.Printf("Failed to replay %s: %v", persistence.ReplayTopic, err)
+	}
+	fmt.Printf("Replayed %d registry messages from %s since %s\n", replayed, persistence.ReplayTopic, since.Format("2006-01-02 15:04:05"))
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// What happens to INFO logs over quota
+const (
+	OverQuotaDrop   = "drop"
+	OverQuotaSample = "sample"
+)
+
+// overQuotaGrace is how long a node or service counts as over quota after its last refused log
+const overQuotaGrace = 2 * time.Second
+
+// Quota limits how fast one node or service may send; zero means no limit
+type Quota struct {
+	MessagesPerSec float64 `json:"messages_per_sec"`
+	BytesPerSec    float64 `json:"bytes_per_sec"`
+}
+
+// QuotaConfig sets the ingestion quotas; Node and Service apply to every node and
+// service without an entry of its own in Nodes (by node ID) or Services
+type QuotaConfig struct {
+	Node              Quota            `json:"node"`
+	Service           Quota            `json:"service"`
+	Nodes             map[string]Quota `json:"nodes"`
+	Services          map[string]Quota `json:"services"`
+	OverQuota         string           `json:"over_quota"`  // drop or sample
+	SampleRate        float64          `json:"sample_rate"` // share of INFO logs kept over quota when sampling
+	ViolationInterval Duration         `json:"violation_interval"`
+}
+
+// QuotaViolation is reported when a node or service goes over its quota
+type QuotaViolation struct {
+	Scope   string // node or service
+	Key     string
+	NodeID  int // the node whose log went over
+	Limit   string
+	Dropped int64 // INFO logs dropped since the previous violation of this quota
+}
+
+// bucket is a token bucket holding at most one second worth of its rate
+type bucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// take removes n tokens and reports whether there were enough; forced takes always
+// succeed and may leave the bucket in debt, down to one second worth
+func (b *bucket) take(n float64, force bool, now time.Time) bool {
+	if b.rate <= 0 {
+		return true
+	}
+	if b.last.IsZero() {
+		b.tokens = b.rate
+	} else {
+		b.tokens = min(b.rate, b.tokens+b.rate*now.Sub(b.last).Seconds())
+	}
+	b.last = now
+
+	if b.tokens >= n || (n > b.rate && b.tokens >= b.rate) {
+		b.tokens -= n
+		return true
+	}
+	if force {
+		b.tokens = max(b.tokens-n, -b.rate)
+		return true
+	}
+	return false
+}
+
+// quotaUsage is the quota state of one node or service
+type quotaUsage struct {
+	scope         string
+	key           string
+	limit         Quota
+	messages      bucket
+	bytes         bucket
+	accepted      int64
+	dropped       int64
+	sampled       int64
+	overSince     time.Time
+	lastOver      time.Time
+	lastViolation time.Time
+	droppedSince  int64 // dropped since the last violation report
+
+	// Rates over the last full second
+	second         time.Time
+	secondMessages int64
+	secondBytes    int64
+	messageRate    int64
+	byteRate       int64
+}
+
+// QuotaUsage is the quota state of one node or service as reported by the API
+type QuotaUsage struct {
+	Scope          string     `json:"scope"`
+	Key            string     `json:"key"`
+	Limit          Quota      `json:"limit"`
+	MessagesPerSec int64      `json:"messages_per_sec"`
+	BytesPerSec    int64      `json:"bytes_per_sec"`
+	Accepted       int64      `json:"accepted"`
+	Dropped        int64      `json:"dropped"`
+	Sampled        int64      `json:"sampled"`
+	OverQuota      bool       `json:"over_quota"`
+	OverSince      *time.Time `json:"over_since,omitempty"`
+}
+
+// QuotaManager enforces the ingestion quotas
+type QuotaManager struct {
+	mu          sync.Mutex
+	cfg         QuotaConfig
+	usage       map[string]*quotaUsage // "scope/key"
+	onViolation func(QuotaViolation)
+}
+
+// NewQuotaManager creates a quota manager; onViolation is called, at most once per
+// violation interval and quota, when INFO logs start being dropped or sampled
+func NewQuotaManager(cfg QuotaConfig, onViolation func(QuotaViolation)) (*QuotaManager, error) {
+	switch cfg.OverQuota {
+	case "":
+		cfg.OverQuota = OverQuotaDrop
+	case OverQuotaDrop, OverQuotaSample:
+	default:
+		return nil, fmt.Errorf("unknown over_quota action %q, use %q or %q", cfg.OverQuota, OverQuotaDrop, OverQuotaSample)
+	}
+	if cfg.OverQuota == OverQuotaSample && (cfg.SampleRate <= 0 || cfg.SampleRate > 1) {
+		return nil, fmt.Errorf("sample_rate must be between 0 and 1, got %g", cfg.SampleRate)
+	}
+	return &QuotaManager{cfg: cfg, usage: make(map[string]*quotaUsage), onViolation: onViolation}, nil
+}
+
+// limitFor returns the quota of a node or service
+func (qm *QuotaManager) limitFor(scope string, key string) Quota {
+	if scope == "node" {
+		if quota, ok := qm.cfg.Nodes[key]; ok {
+			return quota
+		}
+		return qm.cfg.Node
+	}
+	if quota, ok := qm.cfg.Services[key]; ok {
+		return quota
+	}
+	return qm.cfg.Service
+}
+
+func (qm *QuotaManager) usageOf(scope string, key string) *quotaUsage {
+	id := scope + "/" + key
+	usage, ok := qm.usage[id]
+	if !ok {
+		limit := qm.limitFor(scope, key)
+		usage = &quotaUsage{
+			scope:    scope,
+			key:      key,
+			limit:    limit,
+			messages: bucket{rate: limit.MessagesPerSec},
+			bytes:    bucket{rate: limit.BytesPerSec},
+		}
+		qm.usage[id] = usage
+	}
+	return usage
+}
+
+// admit charges a log of size bytes to a quota and reports whether it is within it
+func (u *quotaUsage) admit(size int, force bool, now time.Time) bool {
+	if second := now.Truncate(time.Second); !second.Equal(u.second) {
+		if second.Sub(u.second) == time.Second {
+			u.messageRate, u.byteRate = u.secondMessages, u.secondBytes
+		} else {
+			u.messageRate, u.byteRate = 0, 0
+		}
+		u.second, u.secondMessages, u.secondBytes = second, 0, 0
+	}
+	u.secondMessages++
+	u.secondBytes += int64(size)
+
+	// Both buckets are charged even when the first one already refuses
+	withinMessages := u.messages.take(1, force, now)
+	withinBytes := u.bytes.take(float64(size), force, now)
+	return withinMessages && withinBytes
+}
+
+// Allow charges a log to the quotas of its node and service and reports whether it
+// should be kept; only INFO logs are ever refused, everything else is always kept but
+// still counts against the quotas
+func (qm *QuotaManager) Allow(logData map[string]interface{}, size int, now time.Time) bool {
+	level, _ := logData["log_level"].(string)
+	messageType, _ := logData["message_type"].(string)
+	keep := messageType != "LOG" || level != "INFO"
+
+	nodeID, _ := logData["node_id"].(float64)
+	service, _ := logData["service_name"].(string)
+
+	qm.mu.Lock()
+	var within, over []*quotaUsage
+	subjects := []*quotaUsage{qm.usageOf("node", strconv.Itoa(int(nodeID)))}
+	if service != "" {
+		subjects = append(subjects, qm.usageOf("service", service))
+	}
+	for _, usage := range subjects {
+		if usage.admit(size, keep, now) {
+			within = append(within, usage)
+		} else {
+			over = append(over, usage)
+		}
+	}
+
+	// Over quota: sampling keeps a steady share of the INFO logs
+	allowed := len(over) == 0
+	if !allowed && qm.cfg.OverQuota == OverQuotaSample {
+		seen := over[0].sampled + over[0].dropped + 1
+		allowed = float64(over[0].sampled+1) <= float64(seen)*qm.cfg.SampleRate
+	}
+
+	for _, usage := range within {
+		// Kept WARN and ERROR logs say nothing about whether the quota is still exceeded
+		if !keep {
+			usage.overSince = time.Time{}
+		}
+		if allowed {
+			usage.accepted++
+		}
+	}
+	var violations []QuotaViolation
+	for _, usage := range over {
+		if allowed {
+			usage.sampled++
+		} else {
+			usage.dropped++
+			usage.droppedSince++
+		}
+		if usage.overSince.IsZero() || now.Sub(usage.lastOver) > overQuotaGrace {
+			usage.overSince = now
+		}
+		usage.lastOver = now
+		if now.Sub(usage.lastViolation) >= time.Duration(qm.cfg.ViolationInterval) {
+			violations = append(violations, QuotaViolation{
+				Scope:   usage.scope,
+				Key:     usage.key,
+				NodeID:  int(nodeID),
+				Limit:   describeQuota(usage.limit),
+				Dropped: usage.droppedSince,
+			})
+			usage.lastViolation = now
+			usage.droppedSince = 0
+		}
+	}
+	qm.mu.Unlock()
+
+	if qm.onViolation != nil {
+		for _, violation := range violations {
+			qm.onViolation(violation)
+		}
+	}
+	return allowed
+}
+
+// describeQuota formats a quota for messages
+func describeQuota(quota Quota) string {
+	var parts []string
+	if quota.MessagesPerSec > 0 {
+		parts = append(parts, fmt.Sprintf("%g msgs/s", quota.MessagesPerSec))
+	}
+	if quota.BytesPerSec > 0 {
+		parts = append(parts, fmt.Sprintf("%g bytes/s", quota.BytesPerSec))
+	}
+	if len(parts) == 0 {
+		return "unlimited"
+	}
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	return parts[0] + ", " + parts[1]
+}
+
+// Usage returns the quota state of every node and service seen, sorted by scope and key
+func (qm *QuotaManager) Usage(now time.Time) []QuotaUsage {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	usages := make([]QuotaUsage, 0, len(qm.usage))
+	for _, usage := range qm.usage {
+		report := QuotaUsage{
+			Scope:    usage.scope,
+			Key:      usage.key,
+			Limit:    usage.limit,
+			Accepted: usage.accepted,
+			Dropped:  usage.dropped,
+			Sampled:  usage.sampled,
+		}
+		// Rates are only current if the last full second is the one that just ended
+		if now.Truncate(time.Second).Sub(usage.second) <= time.Second {
+			report.MessagesPerSec, report.BytesPerSec = usage.messageRate, usage.byteRate
+			if now.Truncate(time.Second).Equal(usage.second) {
+				report.MessagesPerSec = max(report.MessagesPerSec, usage.secondMessages)
+				report.BytesPerSec = max(report.BytesPerSec, usage.secondBytes)
+			}
+		}
+		if !usage.overSince.IsZero() && now.Sub(usage.lastOver) <= overQuotaGrace {
+			overSince := usage.overSince
+			report.OverQuota = true
+			report.OverSince = &overSince
+		}
+		usages = append(usages, report)
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		if usages[i].Scope != usages[j].Scope {
+			return usages[i].Scope < usages[j].Scope
+		}
+		return usages[i].Key < usages[j].Key
+	})
+	return usages
+}
+
+// ServeHTTP reports quota usage; scope and key narrow it down to some nodes or services
+func (qm *QuotaManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	scope := r.URL.Query().Get("scope")
+	key := r.URL.Query().Get("key")
+	usages := []QuotaUsage{}
+	for _, usage := range qm.Usage(time.Now()) {
+		if (scope == "" || usage.Scope == scope) && (key == "" || usage.Key == key) {
+			usages = append(usages, usage)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"over_quota":  qm.cfg.OverQuota,
+		"sample_rate": qm.cfg.SampleRate,
+		"usage":       usages,
+	})
+}
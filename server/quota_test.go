@@ -0,0 +1,194 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketTake(t *testing.T) {
+	type take struct {
+		at     int // milliseconds after the start
+		n      float64
+		force  bool
+		ok     bool
+		tokens float64 // left afterwards
+	}
+	tests := []struct {
+		name  string
+		rate  float64
+		takes []take
+	}{
+		{"unlimited", 0, []take{
+			{0, 100, false, true, 0},
+			{0, 100, false, true, 0},
+		}},
+		{"burst of one second", 2, []take{
+			{0, 1, false, true, 1},
+			{0, 1, false, true, 0},
+			{0, 1, false, false, 0},
+		}},
+		{"refill", 2, []take{
+			{0, 2, false, true, 0},
+			{250, 1, false, false, 0.5},
+			{500, 1, false, true, 0},
+			{5000, 2, false, true, 0},
+			{5000, 1, false, false, 0},
+		}},
+		{"more than the rate", 2, []take{
+			{0, 5, false, true, -3},
+			{1000, 1, false, false, -1},
+			{2000, 1, false, true, 0},
+		}},
+		{"forced", 2, []take{
+			{0, 2, false, true, 0},
+			{0, 1, true, true, -1},
+			{0, 5, true, true, -2},
+			{1000, 1, false, false, 0},
+			{1500, 1, false, true, 0},
+		}},
+	}
+	start := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := bucket{rate: tt.rate}
+			for i, step := range tt.takes {
+				now := start.Add(time.Duration(step.at) * time.Millisecond)
+				if ok := b.take(step.n, step.force, now); ok != step.ok {
+					t.Errorf("take %d: take = %v, want %v", i, ok, step.ok)
+				}
+				if b.rate > 0 && b.tokens != step.tokens {
+					t.Errorf("take %d: tokens = %g, want %g", i, b.tokens, step.tokens)
+				}
+			}
+		})
+	}
+}
+
+// quotaLog is a log of a node and service sent at a number of milliseconds after the start
+type quotaLog struct {
+	at      int
+	node    float64
+	service string
+	level   string // empty for a HEARTBEAT
+	size    int
+}
+
+func TestQuotaManagerAllow(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  QuotaConfig
+		logs []quotaLog
+		kept string // y or n for each log
+	}{
+		{"no quota", QuotaConfig{}, []quotaLog{
+			{0, 1, "cache", "INFO", 10}, {0, 1, "cache", "INFO", 10}, {0, 1, "cache", "INFO", 10},
+		}, "yyy"},
+		{"node messages", QuotaConfig{Node: Quota{MessagesPerSec: 2}}, []quotaLog{
+			{0, 1, "cache", "INFO", 10}, {0, 1, "cache", "INFO", 10},
+			{0, 1, "cache", "INFO", 10},
+			{0, 2, "cache", "INFO", 10},
+			{1000, 1, "cache", "INFO", 10},
+		}, "yynyy"},
+		{"warnings and errors are kept", QuotaConfig{Node: Quota{MessagesPerSec: 1}}, []quotaLog{
+			{0, 1, "cache", "INFO", 10},
+			{0, 1, "cache", "WARN", 10}, {0, 1, "cache", "ERROR", 10}, {0, 1, "cache", "", 10},
+			// The kept logs left the bucket in debt
+			{1000, 1, "cache", "INFO", 10},
+			{2000, 1, "cache", "INFO", 10},
+		}, "yyyyny"},
+		{"node bytes", QuotaConfig{Node: Quota{BytesPerSec: 100}}, []quotaLog{
+			{0, 1, "cache", "INFO", 60},
+			{0, 1, "cache", "INFO", 60},
+			{0, 1, "cache", "INFO", 40},
+		}, "yny"},
+		{"node override", QuotaConfig{Node: Quota{MessagesPerSec: 10}, Nodes: map[string]Quota{"7": {MessagesPerSec: 1}}}, []quotaLog{
+			{0, 7, "cache", "INFO", 10}, {0, 7, "cache", "INFO", 10},
+			{0, 8, "cache", "INFO", 10}, {0, 8, "cache", "INFO", 10},
+		}, "ynyy"},
+		{"service", QuotaConfig{Services: map[string]Quota{"cache": {MessagesPerSec: 1}}}, []quotaLog{
+			{0, 1, "cache", "INFO", 10},
+			{0, 2, "cache", "INFO", 10},
+			{0, 3, "router", "INFO", 10},
+		}, "yny"},
+		{"sample", QuotaConfig{Node: Quota{MessagesPerSec: 1}, OverQuota: OverQuotaSample, SampleRate: 0.5}, []quotaLog{
+			{0, 1, "cache", "INFO", 10},
+			{0, 1, "cache", "INFO", 10}, {0, 1, "cache", "INFO", 10},
+			{0, 1, "cache", "INFO", 10}, {0, 1, "cache", "INFO", 10},
+		}, "ynyny"},
+	}
+	start := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qm, err := NewQuotaManager(tt.cfg, nil)
+			if err != nil {
+				t.Fatalf("NewQuotaManager: %v", err)
+			}
+			kept := ""
+			for _, l := range tt.logs {
+				logData := map[string]interface{}{"message_type": "HEARTBEAT", "node_id": l.node, "service_name": l.service}
+				if l.level != "" {
+					logData["message_type"], logData["log_level"] = "LOG", l.level
+				}
+				if qm.Allow(logData, l.size, start.Add(time.Duration(l.at)*time.Millisecond)) {
+					kept += "y"
+				} else {
+					kept += "n"
+				}
+			}
+			if kept != tt.kept {
+				t.Errorf("kept %s, want %s", kept, tt.kept)
+			}
+		})
+	}
+}
+
+func TestQuotaViolations(t *testing.T) {
+	var violations []QuotaViolation
+	qm, err := NewQuotaManager(QuotaConfig{Node: Quota{MessagesPerSec: 1}, ViolationInterval: Duration(time.Minute)}, func(v QuotaViolation) {
+		violations = append(violations, v)
+	})
+	if err != nil {
+		t.Fatalf("NewQuotaManager: %v", err)
+	}
+	start := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	info := map[string]interface{}{"message_type": "LOG", "log_level": "INFO", "node_id": float64(3)}
+	for _, at := range []int{0, 0, 0, 61, 61} {
+		qm.Allow(info, 10, start.Add(time.Duration(at)*time.Second))
+	}
+
+	if len(violations) != 2 {
+		t.Fatalf("violations = %+v, want one per interval", violations)
+	}
+	first, second := violations[0], violations[1]
+	if first.Scope != "node" || first.Key != "3" || first.NodeID != 3 || first.Limit != "1 msgs/s" || first.Dropped != 1 {
+		t.Errorf("first violation = %+v", first)
+	}
+	if second.Dropped != 2 {
+		t.Errorf("second violation dropped %d, want the 2 since the first", second.Dropped)
+	}
+}
+
+func TestNewQuotaManager(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  QuotaConfig
+		err  string
+	}{
+		{"drop by default", QuotaConfig{}, ""},
+		{"sample", QuotaConfig{OverQuota: OverQuotaSample, SampleRate: 0.1}, ""},
+		{"unknown action", QuotaConfig{OverQuota: "queue"}, `unknown over_quota action "queue", use "drop" or "sample"`},
+		{"no sample rate", QuotaConfig{OverQuota: OverQuotaSample}, "sample_rate must be between 0 and 1, got 0"},
+		{"sample rate over 1", QuotaConfig{OverQuota: OverQuotaSample, SampleRate: 1.5}, "sample_rate must be between 0 and 1, got 1.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewQuotaManager(tt.cfg, nil)
+			if tt.err == "" && err != nil {
+				t.Errorf("NewQuotaManager: %v", err)
+			}
+			if tt.err != "" && (err == nil || err.Error() != tt.err) {
+				t.Errorf("error = %v, want %s", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"example.com/logger"
+)
+
+// NodeState is a stage of a node's lifecycle as seen by the server
+type NodeState string
+
+const (
+	StateRegistered   NodeState = "REGISTERED"
+	StateUp           NodeState = "UP"
+	StateSuspect      NodeState = "SUSPECT"
+	StateDown         NodeState = "DOWN"
+	StateRecovered    NodeState = "RECOVERED"
+	StateDeregistered NodeState = "DEREGISTERED"
+)
+
+// nodeStates lists the states in lifecycle order
+var nodeStates = []NodeState{StateRegistered, StateUp, StateSuspect, StateDown, StateRecovered, StateDeregistered}
+
+// NodeInfo is everything the registry knows about a node
+type NodeInfo struct {
+	NodeID         int         `json:"node_id"`
+	ServiceName    string      `json:"service_name"`
+	Host           string      `json:"host,omitempty"`
+	Version        string      `json:"version,omitempty"`
+	Address        string      `json:"address,omitempty"`
+	State          NodeState   `json:"state"`
+	StateSince     time.Time   `json:"state_since"`
+	RegisteredAt   time.Time   `json:"registered_at"`
+	LastHeartbeat  time.Time   `json:"last_heartbeat"`
+	AutoRegistered bool        `json:"auto_registered"`
+	Flapping       bool        `json:"flapping"`
+	Downs          []time.Time `json:"downs,omitempty"`
+}
+
+// NodeMetadata is what a node tells about itself when it registers
+type NodeMetadata struct {
+	Host    string
+	Version string
+	Address string
+}
+
+// update copies the metadata to a node, keeping what it knew for fields left empty
+func (meta NodeMetadata) update(node *NodeInfo) {
+	if meta.Host != "" {
+		node.Host = meta.Host
+	}
+	if meta.Version != "" {
+		node.Version = meta.Version
+	}
+	if meta.Address != "" {
+		node.Address = meta.Address
+	}
+}
+
+// NodeEvent describes a state change worth telling someone about
+type NodeEvent struct {
+	Node            NodeInfo
+	From            NodeState
+	To              NodeState
+	Reason          string
+	FlappingStarted bool
+	FlappingStopped bool
+}
+
+// Registry tracks the lifecycle of every node that registered or sent a heartbeat
+type Registry struct {
+	mu     sync.Mutex
+	cfg    RegistryConfig
+	nodes  map[int]*NodeInfo
+	notify func(NodeEvent)
+}
+
+// NewRegistry creates an empty registry; notify is called for every event, outside the lock
+func NewRegistry(cfg RegistryConfig, notify func(NodeEvent)) *Registry {
+	return &Registry{
+		cfg:    cfg,
+		nodes:  make(map[int]*NodeInfo),
+		notify: notify,
+	}
+}
+
+// SetNotify replaces the event callback, e.g. to stay quiet while state is being rebuilt
+func (r *Registry) SetNotify(notify func(NodeEvent)) {
+	r.mu.Lock()
+	r.notify = notify
+	r.mu.Unlock()
+}
+
+// Restore loads nodes from a snapshot, replacing whatever the registry knew about them
+func (r *Registry) Restore(nodes []NodeInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range nodes {
+		node := nodes[i]
+		r.nodes[node.NodeID] = &node
+	}
+}
+
+// setState moves a node to a new state and returns the event for it
+func (r *Registry) setState(node *NodeInfo, state NodeState, now time.Time, reason string) NodeEvent {
+	event := NodeEvent{From: node.State, To: state, Reason: reason}
+	node.State = state
+	node.StateSince = now
+	event.Node = *node
+	return event
+}
+
+// Register handles a REGISTRATION message; a registration with status DOWN deregisters the node
+func (r *Registry) Register(nodeID int, serviceName string, meta NodeMetadata, up bool, now time.Time) {
+	var events []NodeEvent
+
+	r.mu.Lock()
+	node, known := r.nodes[nodeID]
+	switch {
+	case !up && known:
+		events = append(events, r.setState(node, StateDeregistered, now, "node deregistered"))
+	case !up:
+		// Nothing to forget
+	case !known:
+		node = &NodeInfo{NodeID: nodeID, ServiceName: serviceName, RegisteredAt: now, LastHeartbeat: now}
+		meta.update(node)
+		r.nodes[nodeID] = node
+		events = append(events, r.setState(node, StateRegistered, now, "node registered"))
+	default:
+		node.ServiceName = serviceName
+		meta.update(node)
+		node.AutoRegistered = false
+		node.LastHeartbeat = now
+		if node.State == StateDown || node.State == StateDeregistered {
+			events = append(events, r.setState(node, StateRecovered, now, "node registered again"))
+		} else {
+			node.RegisteredAt = now
+		}
+	}
+	r.mu.Unlock()
+
+	r.emit(events)
+}
+
+// Heartbeat handles a HEARTBEAT message, registering the node if it is unknown
+func (r *Registry) Heartbeat(nodeID int, healthy bool, now time.Time) {
+	var events []NodeEvent
+
+	r.mu.Lock()
+	node, known := r.nodes[nodeID]
+	if !known {
+		if !r.cfg.AutoRegister {
+			r.mu.Unlock()
+			return
+		}
+		node = &NodeInfo{
+			NodeID:         nodeID,
+			ServiceName:    r.cfg.UnknownService,
+			RegisteredAt:   now,
+			AutoRegistered: true,
+		}
+		r.nodes[nodeID] = node
+		events = append(events, r.setState(node, StateRegistered, now, "node registered from heartbeat"))
+	}
+	if now.After(node.LastHeartbeat) {
+		node.LastHeartbeat = now
+	}
+
+	switch {
+	case !healthy && node.State != StateSuspect:
+		events = append(events, r.setState(node, StateSuspect, now, "node reported itself unhealthy"))
+	case !healthy:
+		// Still unhealthy
+	case node.State == StateDown || node.State == StateDeregistered:
+		events = append(events, r.setState(node, StateRecovered, now, "heartbeat resumed"))
+	case node.State != StateUp:
+		events = append(events, r.setState(node, StateUp, now, "heartbeat received"))
+	}
+	r.mu.Unlock()
+
+	r.emit(events)
+}
+
+// Check advances the state of every node according to how long it has been silent
+func (r *Registry) Check(now time.Time) {
+	var events []NodeEvent
+
+	r.mu.Lock()
+	for nodeID, node := range r.nodes {
+		timeouts := r.cfg.Timeouts(node.ServiceName)
+		silence := now.Sub(node.LastHeartbeat)
+
+		switch node.State {
+		case StateRegistered, StateUp, StateRecovered:
+			if silence > time.Duration(timeouts.DownAfter) {
+				events = append(events, r.markDown(node, now, silence))
+			} else if silence > time.Duration(timeouts.SuspectAfter) {
+				events = append(events, r.setState(node, StateSuspect, now, fmt.Sprintf("no heartbeat for %s", silence.Round(time.Second))))
+			}
+		case StateSuspect:
+			if silence > time.Duration(timeouts.DownAfter) {
+				events = append(events, r.markDown(node, now, silence))
+			}
+		case StateDown:
+			if silence > time.Duration(r.cfg.DeregisterAfter) {
+				events = append(events, r.setState(node, StateDeregistered, now, "node expired"))
+			}
+		case StateDeregistered:
+			if now.Sub(node.StateSince) > time.Duration(r.cfg.DeregisterAfter) {
+				delete(r.nodes, nodeID)
+			}
+		}
+
+		if node.Flapping && r.quiet(node, now) {
+			node.Flapping = false
+			events = append(events, NodeEvent{Node: *node, From: node.State, To: node.State, Reason: "node stopped flapping", FlappingStopped: true})
+		}
+	}
+	r.mu.Unlock()
+
+	r.emit(events)
+}
+
+// markDown moves a node to DOWN and updates its flap history; callers hold the lock
+func (r *Registry) markDown(node *NodeInfo, now time.Time, silence time.Duration) NodeEvent {
+	window := time.Duration(r.cfg.FlapWindow)
+	// Build a new slice, the copies handed out by Get, Nodes and events share the old one
+	var downs []time.Time
+	for _, t := range node.Downs {
+		if now.Sub(t) <= window {
+			downs = append(downs, t)
+		}
+	}
+	node.Downs = append(downs, now)
+
+	event := r.setState(node, StateDown, now, fmt.Sprintf("no heartbeat for %s", silence.Round(time.Second)))
+	if !node.Flapping && r.cfg.FlapThreshold > 0 && len(node.Downs) >= r.cfg.FlapThreshold {
+		node.Flapping = true
+		event.Node.Flapping = true
+		event.FlappingStarted = true
+		event.Reason = fmt.Sprintf("node went down %d times in %s", len(node.Downs), window)
+	}
+	return event
+}
+
+// quiet reports whether a flapping node has stayed in one state for a whole flap window
+func (r *Registry) quiet(node *NodeInfo, now time.Time) bool {
+	return now.Sub(node.StateSince) > time.Duration(r.cfg.FlapWindow)
+}
+
+// emit hands events to the notify callback
+func (r *Registry) emit(events []NodeEvent) {
+	r.mu.Lock()
+	notify := r.notify
+	r.mu.Unlock()
+
+	if notify == nil {
+		return
+	}
+	for _, event := range events {
+		notify(event)
+	}
+}
+
+// Get returns a copy of a node's info
+func (r *Registry) Get(nodeID int) (NodeInfo, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	node, ok := r.nodes[nodeID]
+	if !ok {
+		return NodeInfo{}, false
+	}
+	return *node, true
+}
+
+// Nodes returns a copy of all known nodes ordered by node ID
+func (r *Registry) Nodes() []NodeInfo {
+	r.mu.Lock()
+	nodes := make([]NodeInfo, 0, len(r.nodes))
+	for _, node := range r.nodes {
+		nodes = append(nodes, *node)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].NodeID < nodes[j].NodeID })
+	return nodes
+}
+
+// ServeHTTP lists the known nodes and their state as JSON
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"nodes": r.Nodes()})
+}
+
+// monitorNodes periodically checks the registry for silent nodes
+func monitorNodes(ctx context.Context, registry *Registry, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			registry.Check(time.Now())
+		}
+	}
+}
+
+// sendSafely sends a log through the logger, which panics when Kafka or Fluentd fails;
+// the failure is logged instead, so an outage can't crash the server
+func sendSafely(send func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("Failed to send log: %v", err)
+		}
+	}()
+	send()
+}
+
+// announceNodeEvent turns a registry event into a log message; flapping nodes only
+// announce the start and end of the flapping so they don't flood the alerts
+func announceNodeEvent(cfg RegistryConfig) func(NodeEvent) {
+	return func(event NodeEvent) {
+		node := event.Node
+		fmt.Printf("Node %d (%s): %s -> %s (%s)\n", node.NodeID, node.ServiceName, event.From, event.To, event.Reason)
+		sendSafely(func() { sendNodeEvent(cfg, event) })
+	}
+}
+
+// sendNodeEvent logs a registry event, if it's worth telling
+func sendNodeEvent(cfg RegistryConfig, event NodeEvent) {
+	node := event.Node
+	switch {
+	case event.FlappingStopped && node.State == StateDown:
+		logger.SendErrorLog(node.NodeID, "server", fmt.Sprintf("%d timed out", node.NodeID), "500", "node timed out")
+	case event.FlappingStopped:
+		logger.SendInfoLog(node.NodeID, "server", fmt.Sprintf("%d %s", node.NodeID, event.Reason))
+	case event.FlappingStarted:
+		logger.SendWarnLog(node.NodeID, "server", fmt.Sprintf("%d is flapping: %s, suppressing alerts", node.NodeID, event.Reason))
+	case node.Flapping:
+		// Suppressed while flapping
+	case event.To == StateDown:
+		logger.SendErrorLog(node.NodeID, "server", fmt.Sprintf("%d timed out", node.NodeID), "500", "node timed out")
+	case event.To == StateSuspect:
+		logger.SendWarnLog(node.NodeID, "server", fmt.Sprintf("%d is suspect: %s", node.NodeID, event.Reason))
+	case event.To == StateRecovered && cfg.AnnounceRecovery:
+		logger.SendInfoLog(node.NodeID, "server", fmt.Sprintf("%d recovered: %s", node.NodeID, event.Reason))
+	}
+}
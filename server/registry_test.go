@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRegistryConfig has short timeouts, with slower ones for the "batch" service
+func testRegistryConfig() RegistryConfig {
+	return RegistryConfig{
+		Default:         ServiceTimeouts{SuspectAfter: Duration(20 * time.Second), DownAfter: Duration(30 * time.Second)},
+		Services:        map[string]ServiceTimeouts{"batch": {DownAfter: Duration(10 * time.Minute)}},
+		DeregisterAfter: Duration(time.Hour),
+		FlapWindow:      Duration(5 * time.Minute),
+		FlapThreshold:   3,
+		AutoRegister:    true,
+		UnknownService:  "unknown",
+	}
+}
+
+// registryStep is something happening to node 1 at a number of seconds after the start;
+// events lists the transitions it emits, like "REGISTERED>UP"
+type registryStep struct {
+	at     int
+	action string // register, deregister, heartbeat, unhealthy or check
+	state  NodeState
+	events string
+}
+
+func TestRegistryLifecycle(t *testing.T) {
+	tests := []struct {
+		name    string
+		service string
+		steps   []registryStep
+	}{
+		{"register and heartbeat", "cache", []registryStep{
+			{0, "register", StateRegistered, ">REGISTERED"},
+			{5, "heartbeat", StateUp, "REGISTERED>UP"},
+			{10, "heartbeat", StateUp, ""},
+			{25, "check", StateUp, ""},
+		}},
+		{"silent node goes down", "cache", []registryStep{
+			{0, "register", StateRegistered, ">REGISTERED"},
+			{21, "check", StateSuspect, "REGISTERED>SUSPECT"},
+			{31, "check", StateDown, "SUSPECT>DOWN"},
+			{40, "heartbeat", StateRecovered, "DOWN>RECOVERED"},
+			{45, "heartbeat", StateUp, "RECOVERED>UP"},
+		}},
+		{"straight to down", "cache", []registryStep{
+			{0, "register", StateRegistered, ">REGISTERED"},
+			{60, "check", StateDown, "REGISTERED>DOWN"},
+		}},
+		{"per-service timeouts", "batch", []registryStep{
+			{0, "register", StateRegistered, ">REGISTERED"},
+			{60, "check", StateSuspect, "REGISTERED>SUSPECT"},
+			{300, "check", StateSuspect, ""},
+			{601, "check", StateDown, "SUSPECT>DOWN"},
+		}},
+		{"unhealthy", "cache", []registryStep{
+			{0, "register", StateRegistered, ">REGISTERED"},
+			{5, "unhealthy", StateSuspect, "REGISTERED>SUSPECT"},
+			{10, "unhealthy", StateSuspect, ""},
+			{15, "heartbeat", StateUp, "SUSPECT>UP"},
+		}},
+		{"deregister and expire", "cache", []registryStep{
+			{0, "register", StateRegistered, ">REGISTERED"},
+			{5, "deregister", StateDeregistered, "REGISTERED>DEREGISTERED"},
+			{10, "register", StateRecovered, "DEREGISTERED>RECOVERED"},
+			{60, "check", StateDown, "RECOVERED>DOWN"},
+			{3661, "check", StateDeregistered, "DOWN>DEREGISTERED"},
+			{7262, "check", "", ""},
+		}},
+		{"auto registered", "", []registryStep{
+			{0, "heartbeat", StateUp, ">REGISTERED REGISTERED>UP"},
+		}},
+		{"flapping", "cache", []registryStep{
+			{0, "register", StateRegistered, ">REGISTERED"},
+			{31, "check", StateDown, "REGISTERED>DOWN"},
+			{32, "heartbeat", StateRecovered, "DOWN>RECOVERED"},
+			{63, "check", StateDown, "RECOVERED>DOWN"},
+			{64, "heartbeat", StateRecovered, "DOWN>RECOVERED"},
+			{95, "check", StateDown, "RECOVERED>DOWN flapping"},
+			{96, "heartbeat", StateRecovered, "DOWN>RECOVERED"},
+			{100, "heartbeat", StateUp, "RECOVERED>UP"},
+			{110, "heartbeat", StateUp, ""},
+			{401, "heartbeat", StateUp, "UP>UP stopped"},
+		}},
+	}
+	start := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []string
+			registry := NewRegistry(testRegistryConfig(), func(event NodeEvent) {
+				description := fmt.Sprintf("%s>%s", event.From, event.To)
+				switch {
+				case event.FlappingStarted:
+					description += " flapping"
+				case event.FlappingStopped:
+					description += " stopped"
+				}
+				events = append(events, description)
+			})
+			for _, step := range tt.steps {
+				now := start.Add(time.Duration(step.at) * time.Second)
+				events = nil
+				switch step.action {
+				case "register":
+					registry.Register(1, tt.service, NodeMetadata{Host: "host-1"}, true, now)
+				case "deregister":
+					registry.Register(1, tt.service, NodeMetadata{}, false, now)
+				case "heartbeat", "unhealthy":
+					registry.Heartbeat(1, step.action == "heartbeat", now)
+					// The server checks all the time, which is also what ends flapping
+					registry.Check(now)
+				case "check":
+					registry.Check(now)
+				}
+
+				node, known := registry.Get(1)
+				if node.State != step.state || known != (step.state != "") {
+					t.Errorf("%s at %ds: state = %q (known %v), want %q", step.action, step.at, node.State, known, step.state)
+				}
+				if got := strings.Join(events, " "); got != step.events {
+					t.Errorf("%s at %ds: events = %q, want %q", step.action, step.at, got, step.events)
+				}
+			}
+		})
+	}
+}
+
+func TestRegistryKeepsMetadata(t *testing.T) {
+	registry := NewRegistry(testRegistryConfig(), nil)
+	now := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	registry.Heartbeat(1, true, now)
+	registry.Register(1, "cache", NodeMetadata{Host: "host-1", Version: "1.2"}, true, now.Add(time.Second))
+	registry.Register(1, "cache", NodeMetadata{Version: "1.3"}, true, now.Add(2*time.Second))
+
+	node, _ := registry.Get(1)
+	if node.ServiceName != "cache" || node.Host != "host-1" || node.Version != "1.3" || node.AutoRegistered {
+		t.Errorf("node = %+v", node)
+	}
+	if !node.RegisteredAt.Equal(now.Add(2 * time.Second)) {
+		t.Errorf("registered at %s, want the last registration", node.RegisteredAt)
+	}
+}
+
+func TestSendSafely(t *testing.T) {
+	sent := false
+	sendSafely(func() { sent = true })
+	if !sent {
+		t.Errorf("send not called")
+	}
+	// A panicking logger must not take the server down
+	sendSafely(func() { panic("no producer") })
+}
+
+func TestRegistryCopiesKeepDowns(t *testing.T) {
+	registry := NewRegistry(testRegistryConfig(), nil)
+	start := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	registry.Register(1, "cache", NodeMetadata{}, true, start)
+	registry.Check(start.Add(31 * time.Second))
+	registry.Heartbeat(1, true, start.Add(32*time.Second))
+
+	node, _ := registry.Get(1)
+	first := node.Downs[0]
+	// Going down again after the flap window drops the first down from the registry's history
+	registry.Check(start.Add(10 * time.Minute))
+	if !node.Downs[0].Equal(first) {
+		t.Errorf("copy's downs changed to %s, want %s", node.Downs[0], first)
+	}
+	if node, _ := registry.Get(1); len(node.Downs) != 1 || node.Downs[0].Equal(first) {
+		t.Errorf("downs = %s, want only the last one", node.Downs)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"example.com/store"
+)
+
+// reindexBatchSize is how many logs a reindex writes at once
+const reindexBatchSize = 1000
+
+// reindexDoc brings a log stored before the store had a mapping up to what the server
+// stores today: an @timestamp, an event_id (the document ID, so reindexing twice doesn't
+// duplicate it) and, when the config normalizes them, upper-case levels and types
+func reindexDoc(hit store.Hit, enricher *Enricher) store.Document {
+	doc := hit.Doc
+	if _, ok := doc[store.FieldTimestamp].(string); !ok {
+		if t, ok := store.EventTime(doc); ok {
+			doc[store.FieldTimestamp] = t.UTC().Format(time.RFC3339Nano)
+		}
+	}
+	if doc.String(store.FieldEventID) == "" && hit.ID != "" {
+		doc[store.FieldEventID] = hit.ID
+	}
+	enricher.Normalize(doc)
+	return doc
+}
+
+// Reindex copies every log of source to target, fixed up with reindexDoc; it returns
+// how many logs it copied
+func Reindex(ctx context.Context, source store.LogStore, target store.LogStore, enricher *Enricher, progress func(int64)) (int64, error) {
+	var copied int64
+	var batch []store.Document
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := target.Append(batch...); err != nil {
+			return fmt.Errorf("failed to write logs: %w", err)
+		}
+		copied += int64(len(batch))
+		batch = batch[:0]
+		progress(copied)
+		return nil
+	}
+
+	err := source.Scan(store.Query{Sort: store.SortNone}, func(hit store.Hit) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		batch = append(batch, reindexDoc(hit, enricher))
+		if len(batch) >= reindexBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return copied, err
+	}
+	return copied, flush()
+}
+
+// runReindex implements the reindex subcommand and returns the exit code
+func runReindex(args []string) int {
+	flags := flag.NewFlagSet("reindex", flag.ExitOnError)
+	configPath := flags.String("config", "", "Path to the JSON server config file")
+	from := flags.String("from", "", "Elasticsearch index to read (default the configured one)")
+	index := flags.String("index", "", "New Elasticsearch index to write, created with the store's mapping")
+	flags.Parse(args)
+
+	cfg, err := LoadConfig(*configPath)
+	if err != nil {
+		log.Printf("Failed to load config: %v", err)
+		return 1
+	}
+	if cfg.Store.Backend != "" && cfg.Store.Backend != "elasticsearch" {
+		log.Printf("Reindexing only applies to Elasticsearch")
+		return 1
+	}
+	if *index == "" {
+		log.Printf("-index is required")
+		return 1
+	}
+	sourceCfg, targetCfg := cfg.Store.Elasticsearch, cfg.Store.Elasticsearch
+	if *from != "" {
+		sourceCfg.Index = *from
+	}
+	targetCfg.Index = *index
+	if sourceCfg.Index == targetCfg.Index {
+		log.Printf("-index must differ from the index being read")
+		return 1
+	}
+
+	source, err := store.NewElasticStore(sourceCfg)
+	if err != nil {
+		log.Printf("Failed to open log store: %v", err)
+		return 1
+	}
+	defer source.Close()
+	target, err := store.NewElasticStore(targetCfg)
+	if err != nil {
+		log.Printf("Failed to open log store: %v", err)
+		return 1
+	}
+	defer target.Close()
+	if err := target.EnsureIndex(); err != nil {
+		log.Printf("Failed to create index: %v", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	last := time.Now()
+	copied, err := Reindex(ctx, source, target, NewEnricher(cfg.Enrich, nil), func(copied int64) {
+		if time.Since(last) >= replayProgressInterval {
+			fmt.Printf("Reindexed %d logs\n", copied)
+			last = time.Now()
+		}
+	})
+	fmt.Printf("Reindexed %d logs from %s to %s\n", copied, sourceCfg.Index, targetCfg.Index)
+	if err != nil {
+		log.Printf("Reindex failed: %v", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"example.com/store"
+
+	"github.com/IBM/sarama"
+)
+
+// replayProgressInterval is how often a replay prints its progress
+const replayProgressInterval = 5 * time.Second
+
+// ReplayOptions says what to replay and where to
+type ReplayOptions struct {
+	Topic      string
+	Partitions []int32 // all partitions when empty
+	FromOffset int64   // -1 for the oldest offset
+	ToOffset   int64   // exclusive, -1 for the newest offset when the replay starts
+	Since      time.Time
+	Until      time.Time
+	Rate       float64 // messages per second, 0 for no limit
+	DryRun     bool
+}
+
+// replayRange is the offsets of one partition to replay, end excluded
+type replayRange struct {
+	partition  int32
+	start, end int64
+}
+
+// ReplayStats counts what happened to the replayed messages
+type ReplayStats struct {
+	Read    int64
+	Kept    int64
+	Skipped map[string]int64 // by reason
+}
+
+// runReplay implements the replay subcommand and returns the exit code
+func runReplay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	configPath := flags.String("config", "", "Path to the JSON server config file")
+	topic := flags.String("topic", "logs", "Topic to replay")
+	partitions := flags.String("partitions", "", "Comma separated partitions to replay (default all)")
+	fromOffset := flags.Int64("from-offset", -1, "First offset to replay (default the oldest)")
+	toOffset := flags.Int64("to-offset", -1, "Offset to stop before (default the newest when the replay starts)")
+	since := flags.String("since", "", "Only replay messages from this time on (RFC 3339 or a duration like 2h)")
+	until := flags.String("until", "", "Only replay messages before this time (RFC 3339 or a duration like 30m)")
+	index := flags.String("index", "", "Elasticsearch index, or local store subdirectory, to write to (default the configured one)")
+	backend := flags.String("backend", "", "Store to write to: elasticsearch or local (default the configured one)")
+	dataDir := flags.String("data-dir", "", "Directory of the local store (default the configured one)")
+	rate := flags.Float64("rate", 0, "Maximum messages per second (default no limit)")
+	dryRun := flags.Bool("dry-run", false, "Run the pipeline without storing anything")
+	flags.Parse(args)
+
+	cfg, err := LoadConfig(*configPath)
+	if err != nil {
+		log.Printf("Failed to load config: %v", err)
+		return 1
+	}
+
+	opts := ReplayOptions{Topic: *topic, FromOffset: *fromOffset, ToOffset: *toOffset, Rate: *rate, DryRun: *dryRun}
+	for _, part := range strings.Split(*partitions, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		var partition int32
+		if _, err := fmt.Sscan(part, &partition); err != nil {
+			log.Printf("Invalid partition %q", part)
+			return 1
+		}
+		opts.Partitions = append(opts.Partitions, partition)
+	}
+	now := time.Now()
+	if opts.Since, err = parseReplayTime(*since, now); err != nil {
+		log.Printf("Invalid -since: %v", err)
+		return 1
+	}
+	if opts.Until, err = parseReplayTime(*until, now); err != nil {
+		log.Printf("Invalid -until: %v", err)
+		return 1
+	}
+
+	// The target store is the configured one unless the flags say otherwise
+	if *backend != "" {
+		cfg.Store.Backend = *backend
+	}
+	if *dataDir != "" {
+		cfg.Store.Local.Dir = *dataDir
+	}
+	if *index != "" {
+		cfg.Store.Elasticsearch.Index = *index
+		cfg.Store.Local.Dir = filepath.Join(cfg.Store.Local.Dir, *index)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stats, err := replay(ctx, cfg, opts)
+	fmt.Printf("Replayed %d messages: %d kept, %d skipped\n", stats.Read, stats.Kept, stats.Read-stats.Kept)
+	reasons := make([]string, 0, len(stats.Skipped))
+	for reason := range stats.Skipped {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Printf("  skipped (%s): %d\n", reason, stats.Skipped[reason])
+	}
+	if err != nil {
+		log.Printf("Replay failed: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// parseReplayTime reads an RFC 3339 time, or a duration meaning that long before now
+func parseReplayTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// replayRanges works out the offsets to replay in every partition
+func replayRanges(client sarama.Client, opts ReplayOptions) ([]replayRange, error) {
+	partitions := opts.Partitions
+	if len(partitions) == 0 {
+		var err error
+		if partitions, err = client.Partitions(opts.Topic); err != nil {
+			return nil, fmt.Errorf("failed to list partitions of %s: %w", opts.Topic, err)
+		}
+	}
+
+	var ranges []replayRange
+	for _, partition := range partitions {
+		oldest, err := client.GetOffset(opts.Topic, partition, sarama.OffsetOldest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get offsets of %s/%d: %w", opts.Topic, partition, err)
+		}
+		newest, err := client.GetOffset(opts.Topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get offsets of %s/%d: %w", opts.Topic, partition, err)
+		}
+
+		r := replayRange{partition: partition, start: oldest, end: newest}
+		if opts.FromOffset >= 0 {
+			r.start = max(r.start, opts.FromOffset)
+		}
+		if opts.ToOffset >= 0 {
+			r.end = min(r.end, opts.ToOffset)
+		}
+		// Kafka finds the first offset at or after a time; -1 means there is none
+		if !opts.Since.IsZero() {
+			offset, err := client.GetOffset(opts.Topic, partition, opts.Since.UnixMilli())
+			if err != nil {
+				return nil, fmt.Errorf("failed to find %s in %s/%d: %w", opts.Since, opts.Topic, partition, err)
+			}
+			if offset < 0 {
+				offset = newest
+			}
+			r.start = max(r.start, offset)
+		}
+		if !opts.Until.IsZero() {
+			offset, err := client.GetOffset(opts.Topic, partition, opts.Until.UnixMilli())
+			if err != nil {
+				return nil, fmt.Errorf("failed to find %s in %s/%d: %w", opts.Until, opts.Topic, partition, err)
+			}
+			if offset >= 0 {
+				r.end = min(r.end, offset)
+			}
+		}
+		if r.start < r.end {
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges, nil
+}
+
+// replay reads the ranges and runs every message through the ingestion pipeline, with
+// the node registry restored from the snapshot and without alerting, quotas or tail
+func replay(ctx context.Context, cfg *Config, opts ReplayOptions) (ReplayStats, error) {
+	stats := ReplayStats{Skipped: make(map[string]int64)}
+
+	client, err := sarama.NewClient(cfg.Brokers, nil)
+	if err != nil {
+		return stats, fmt.Errorf("failed to create client: %w", err)
+	}
+	defer client.Close()
+
+	ranges, err := replayRanges(client, opts)
+	if err != nil {
+		return stats, err
+	}
+	var total int64
+	for _, r := range ranges {
+		total += r.end - r.start
+		fmt.Printf("Replaying %s/%d offsets %d to %d\n", opts.Topic, r.partition, r.start, r.end-1)
+	}
+	if total == 0 {
+		fmt.Println("Nothing to replay")
+		return stats, nil
+	}
+
+	registry := NewRegistry(cfg.Registry, nil)
+	if snapshot, err := loadSnapshot(cfg.Persistence.SnapshotPath); err == nil && snapshot != nil {
+		registry.Restore(snapshot.Nodes)
+	}
+	// Rejected messages are counted but not sent to the dead-letter topic a second time
+	validation := cfg.Validation
+	validation.DeadLetterTopic = ""
+	validator, err := NewValidator(validation, nil)
+	if err != nil {
+		return stats, err
+	}
+	pipeline := &Pipeline{
+		Validator: validator,
+		Enricher:  NewEnricher(cfg.Enrich, registry),
+		Registry:  registry,
+		Metrics:   NewMetrics(registry),
+		Replay:    true,
+	}
+
+	if !opts.DryRun {
+		logs, err := store.Open(cfg.Store)
+		if err != nil {
+			return stats, fmt.Errorf("failed to open log store: %w", err)
+		}
+		defer logs.Close()
+		if es, ok := logs.(*store.ElasticStore); ok {
+			if err := es.EnsureIndex(); err != nil {
+				return stats, err
+			}
+		}
+		pipeline.Indexer = NewIndexer(logs, pipeline.Metrics, cfg.Indexing)
+		go pipeline.Indexer.Run()
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return stats, fmt.Errorf("failed to create consumer: %w", err)
+	}
+	defer consumer.Close()
+
+	start := time.Now()
+	lastProgress := start
+	for _, r := range ranges {
+		if err := replayPartition(ctx, consumer, opts, r, pipeline, &stats, func() {
+			if time.Since(lastProgress) >= replayProgressInterval {
+				lastProgress = time.Now()
+				elapsed := time.Since(start).Seconds()
+				fmt.Printf("Progress: %d/%d messages (%.1f%%), %d kept, %d skipped, %.0f msgs/s\n",
+					stats.Read, total, 100*float64(stats.Read)/float64(total), stats.Kept, stats.Read-stats.Kept, float64(stats.Read)/elapsed)
+			}
+		}); err != nil {
+			err = fmt.Errorf("partition %d: %w", r.partition, err)
+			if pipeline.Indexer != nil {
+				pipeline.Indexer.Drain(time.Duration(cfg.ShutdownTimeout))
+			}
+			return stats, err
+		}
+	}
+
+	if pipeline.Indexer != nil {
+		if err := pipeline.Indexer.Drain(time.Duration(cfg.ShutdownTimeout)); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// replayPartition replays one range, pacing itself to the rate limit
+func replayPartition(ctx context.Context, consumer sarama.Consumer, opts ReplayOptions, r replayRange, pipeline *Pipeline, stats *ReplayStats, progress func()) error {
+	partitionConsumer, err := consumer.ConsumePartition(opts.Topic, r.partition, r.start)
+	if err != nil {
+		return err
+	}
+	defer partitionConsumer.Close()
+
+	begin := time.Now()
+	var count int64
+	for {
+		var message *sarama.ConsumerMessage
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-partitionConsumer.Errors():
+			return err
+		case message = <-partitionConsumer.Messages():
+		case <-time.After(replayIdleTimeout):
+			// The last offsets may not hold messages (e.g. transaction markers)
+			return nil
+		}
+
+		if opts.Rate > 0 {
+			count++
+			if wait := time.Until(begin.Add(time.Duration(float64(count) / opts.Rate * float64(time.Second)))); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+
+		stats.Read++
+		if reason := pipeline.Process(message, nil); reason == "" {
+			stats.Kept++
+		} else {
+			stats.Skipped[reason]++
+		}
+		progress()
+
+		if message.Offset >= r.end-1 {
+			return nil
+		}
+	}
+}
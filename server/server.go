@@ -1,142 +1,248 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"sync"
+	"syscall"
 	"time"
 
 	"example.com/logger"
+	"example.com/store"
 
 	"github.com/IBM/sarama"
-	"github.com/elastic/go-elasticsearch/v8"
-	"github.com/fatih/color"
 )
 
-// ElasticClient wraps the Elasticsearch client for indexing logs
-type ElasticClient struct {
-	Client *elasticsearch.Client
-	Index  string
-}
-
-// NodeStatus tracks the last heartbeat timestamp and status of nodes
-type NodeStatus struct {
-	LastHeartbeat time.Time
-	Status        string
+// stamp adds the fields every stored log has: its event time as @timestamp (falling
+// back to the ingestion time), the ingestion time and a unique event_id
+func stamp(logData map[string]interface{}, eventID string, ingested time.Time) {
+	eventTime, ok := store.EventTime(logData)
+	if !ok {
+		eventTime = ingested
+	}
+	logData[store.FieldTimestamp] = eventTime.UTC().Format(time.RFC3339Nano)
+	logData["ingested_at"] = ingested.UTC().Format(time.RFC3339Nano)
+	if eventID != "" {
+		logData[store.FieldEventID] = eventID
+	}
 }
 
-// NewElasticClient initializes an Elasticsearch client
-func NewElasticClient(index string) (*ElasticClient, error) {
-	cfg := elasticsearch.Config{
-		Addresses: []string{"http://localhost:9200"},
-		Username:  "elastic",              // Add your username here
-		Password:  "XXiqV27E1FcB*Qeh0jox", // Add your password here
+// openStores opens the log store and the alert history store, which is the same
+// backend in the history index (Elasticsearch) or a subdirectory (local)
+func openStores(cfg *Config) (store.LogStore, store.LogStore, error) {
+	logs, err := store.Open(cfg.Store)
+	if err != nil {
+		return nil, nil, err
 	}
-	client, err := elasticsearch.NewClient(cfg)
+
+	historyCfg := cfg.Store
+	historyCfg.Elasticsearch.Index = cfg.Alerting.HistoryIndex
+	historyCfg.Local.Dir = filepath.Join(cfg.Store.Local.Dir, cfg.Alerting.HistoryIndex)
+	history, err := store.Open(historyCfg)
 	if err != nil {
-		return nil, err
+		logs.Close()
+		return nil, nil, err
+	}
+
+	// Create the Elasticsearch indices with their mapping on first use. An index with
+	// another mapping would silently break queries, so it stops the server.
+	for _, s := range []store.LogStore{logs, history} {
+		if es, ok := s.(*store.ElasticStore); ok {
+			err := es.EnsureIndex()
+			var mappingErr *store.MappingError
+			if errors.As(err, &mappingErr) {
+				logs.Close()
+				history.Close()
+				return nil, nil, fmt.Errorf("%w (see \"reindex\" in the README)", err)
+			}
+			if err != nil {
+				log.Printf("Failed to prepare index: %v", err)
+			}
+		}
 	}
-	return &ElasticClient{Client: client, Index: index}, nil
+	return logs, history, nil
 }
 
-// IndexLog stores a log in Elasticsearch
-func (ec *ElasticClient) IndexLog(logData map[string]interface{}) error {
-	infoColor := color.New(color.FgGreen).SprintFunc()
-	warnColor := color.New(color.FgYellow).SprintFunc()
-	errorColor := color.New(color.FgRed).SprintFunc()
-	otherColor := color.New(color.FgBlue).SprintFunc()
-	messageColor := color.New(color.FgWhite).SprintFunc()
-	timeColor := color.New(color.FgHiWhite).SprintFunc()
-	serviceColor := color.New(color.FgCyan).SprintFunc()
-
-	// Print the log message with color based on the log level
-	switch {
-	case logData["log_level"] == "INFO":
-		fmt.Printf("  %s - %s [%s] - %s\n", infoColor(logData["log_level"]), messageColor(logData["message"]), serviceColor(logData["service_name"]), timeColor(time.Now().Format("2006-01-02 15:04:05")))
-	case logData["log_level"] == "WARN":
-		fmt.Printf("  %s - %s [%s] - %s\n", warnColor(logData["log_level"]), messageColor(logData["message"]), serviceColor(logData["service_name"]), timeColor(time.Now().Format("2006-01-02 15:04:05")))
-	case logData["log_level"] == "ERROR":
-		fmt.Printf("  %s - %s [%s] - %s\n", errorColor(logData["log_level"]), messageColor(logData["message"]), serviceColor(logData["service_name"]), timeColor(time.Now().Format("2006-01-02 15:04:05")))
-	case logData["message_type"] == "REGISTRATION":
-		fmt.Printf("  %s - %s [%s] - %s\n", otherColor(logData["message_type"]), messageColor(logData["service_name"]), serviceColor(int(logData["node_id"].(float64))), timeColor(time.Now().Format("2006-01-02 15:04:05")))
-	case logData["message_type"] == "HEARTBEAT":
-		fmt.Printf("  %s - %s [%s] - %s\n", otherColor(logData["message_type"]), messageColor(logData["status"]), serviceColor(int(logData["node_id"].(float64))), timeColor(time.Now().Format("2006-01-02 15:04:05")))
-	default:
-		fmt.Printf("%s %s\n", logData, timeColor(time.Now().Format("2006-01-02 15:04:05")))
+// trackNode updates the registry from a REGISTRATION or HEARTBEAT message seen at the given
+// time and reports whether the message was one of those
+func trackNode(registry *Registry, logData map[string]interface{}, seen time.Time) bool {
+	nodeID, ok := logData["node_id"].(float64)
+	if !ok {
+		return false
 	}
-	data, err := json.Marshal(logData)
-	if err != nil {
-		return err
+	status, _ := logData["status"].(string)
+
+	switch logData["message_type"] {
+	case "REGISTRATION":
+		// Registration message: a status of DOWN means the node is leaving
+		serviceName, _ := logData["service_name"].(string)
+		var meta NodeMetadata
+		meta.Host, _ = logData["host"].(string)
+		meta.Version, _ = logData["version"].(string)
+		meta.Address, _ = logData["address"].(string)
+		registry.Register(int(nodeID), serviceName, meta, status != "DOWN", seen)
+	case "HEARTBEAT":
+		// Heartbeat message: keeps the node alive, registering it if needed
+		registry.Heartbeat(int(nodeID), status != "DOWN", seen)
+	default:
+		return false
 	}
-	res, err := ec.Client.Index(ec.Index, bytes.NewReader(data))
-	if err != nil {
-		return err
+	return true
+}
+
+// Pipeline is everything a consumed message goes through
+type Pipeline struct {
+	Indexer   *Indexer
+	Validator *Validator
+	Console   *Console
+	Enricher  *Enricher
+	Quotas    *QuotaManager
+	Registry  *Registry
+	Alerts    *AlertEngine
+	Tail      *TailHub
+	Metrics   *Metrics
+	Replay    bool // messages come from a replay, not live
+}
+
+// Process decodes one Kafka message and takes it through validation, node tracking,
+// enrichment, alerting, quotas, indexing and live tail; ack is called once the log is
+// stored, or once the logs before it are when it's skipped. It returns why the message
+// was skipped, or "" if it was kept.
+func (p *Pipeline) Process(message *sarama.ConsumerMessage, ack func()) string {
+	reason := p.process(message, ack)
+	if reason != "" && ack != nil {
+		if p.Indexer != nil {
+			p.Indexer.Skip(ack)
+		} else {
+			ack()
+		}
 	}
-	defer res.Body.Close()
-	return nil
+	return reason
 }
 
-func consumeTopic(brokers []string, topic string, ec *ElasticClient, wg *sync.WaitGroup, nodeMap *sync.Map) {
-	defer wg.Done()
+// process does the work of Process, leaving skipped messages unacknowledged
+func (p *Pipeline) process(message *sarama.ConsumerMessage, ack func()) string {
+	var logData map[string]interface{}
+	if err := json.Unmarshal(message.Value, &logData); err != nil {
+		fmt.Printf("Failed to unmarshal log: %v\n", err)
+		p.Metrics.Failed(nil, "decode")
+		return "decode"
+	}
+
+	// The Kafka coordinates make the ID stable if the message is ever ingested again
+	eventID := fmt.Sprintf("%s-%d-%d", message.Topic, message.Partition, message.Offset)
+	// Replayed messages were seen when Kafka got them, not now
+	seen := time.Now()
+	if p.Replay && !message.Timestamp.IsZero() {
+		seen = message.Timestamp
+	}
+
+	// Validation checks the casing the store keeps, not the casing the node sent
+	p.Enricher.Normalize(logData)
+
+	// if len(logData) == 4 {
+	// 	// registration message
+	// 	logData["status"] = "UP"
+	// }
+	messageType, ok := logData["message_type"].(string)
+	if !ok {
+		log.Printf("Invalid or missing 'message_type': %+v", logData)
+		p.Metrics.Failed(logData, "decode")
+		return "decode"
+	}
+	if messageType != "REGISTRATION" && messageType != "HEARTBEAT" && messageType != "LOG" {
+		return "message_type"
+	}
+	if !p.Validator.Validate(logData, eventID) {
+		p.Metrics.Failed(logData, "validation")
+		return "validation"
+	}
+	if _, ok := logData["node_id"].(float64); !ok {
+		log.Printf("Invalid or missing 'node_id': %+v", logData)
+		p.Metrics.Failed(logData, "decode")
+		return "decode"
+	}
+	if messageType == "REGISTRATION" {
+		if _, hasStatus := logData["status"].(string); !hasStatus {
+			logData["status"] = "UP"
+		}
+	}
+	stamp(logData, eventID, time.Now())
+	trackNode(p.Registry, logData, seen)
+	p.Enricher.Enrich(logData)
+	if p.Alerts != nil {
+		p.Alerts.Observe(logData, seen)
+	}
+
+	// Over-quota INFO logs still count for node tracking and alerting, but go no further
+	if p.Quotas != nil && !p.Quotas.Allow(logData, len(message.Value), seen) {
+		p.Metrics.Dropped(logData, "quota")
+		return "quota"
+	}
+	p.Console.Print(logData)
 
-	consumer, err := sarama.NewConsumer(brokers, nil)
+	// Without an indexer (a dry run) nothing is stored
+	if p.Indexer != nil {
+		p.Indexer.Enqueue(logData, ack)
+	}
+	if p.Tail != nil {
+		p.Tail.Publish(logData)
+	}
+	return ""
+}
+
+// consumeTopic feeds partition 0 of a topic into the pipeline, resuming after the last
+// committed offset, until ctx is cancelled
+func consumeTopic(ctx context.Context, client sarama.Client, offsets sarama.OffsetManager, topic string, pipeline *Pipeline) error {
+	consumer, err := sarama.NewConsumerFromClient(client)
 	if err != nil {
-		log.Fatalf("Error creating consumer: %v", err)
+		return fmt.Errorf("failed to create consumer: %w", err)
 	}
 	defer consumer.Close()
 
-	partitionConsumer, err := consumer.ConsumePartition(topic, 0, sarama.OffsetNewest)
+	// The offset manager outlives the consumer so the offsets of logs still being
+	// indexed can be committed after it stops
+	partitionOffsets, err := offsets.ManagePartition(topic, 0)
+	if err != nil {
+		return fmt.Errorf("failed to manage offsets: %w", err)
+	}
+	next, _ := partitionOffsets.NextOffset()
+	partitionConsumer, err := consumer.ConsumePartition(topic, 0, next)
+	if errors.Is(err, sarama.ErrOffsetOutOfRange) {
+		log.Printf("Committed offset %d of %s is gone, starting from the newest", next, topic)
+		partitionConsumer, err = consumer.ConsumePartition(topic, 0, sarama.OffsetNewest)
+	}
 	if err != nil {
-		log.Fatalf("Error creating partition consumer: %v", err)
+		return fmt.Errorf("failed to create partition consumer: %w", err)
 	}
 	defer partitionConsumer.Close()
+	pipeline.Metrics.TrackPartition(topic, 0, partitionConsumer, partitionOffsets)
 
 	fmt.Printf("Listening to topic: %s\n", topic)
 
-	for message := range partitionConsumer.Messages() {
-		var logData map[string]interface{}
-		err := json.Unmarshal(message.Value, &logData)
-		if err != nil {
-			fmt.Printf("Failed to unmarshal log: %v\n", err)
-			continue
-		}
-
-		// if len(logData) == 4 {
-		// 	// registration message
-		// 	logData["status"] = "UP"
-		// }
-		messageType, ok := logData["message_type"].(string)
-		if !ok {
-			log.Printf("Invalid or missing 'message_type': %+v", logData)
-			return
-		}
-		if messageType == "REGISTRATION" || messageType == "HEARTBEAT" || messageType == "LOG" {
-			nodeID := int(logData["node_id"].(float64))
-
-			if messageType == "REGISTRATION" {
-				// Registration message: initialize the node's status
-				logData["STATUS"] = "UP"
-				nodeMap.Store(nodeID, &NodeStatus{
-					LastHeartbeat: time.Now(),
-					Status:        "UP",
-				})
-			} else if messageType == "HEARTBEAT" {
-				// Heartbeat message: update the node's last heartbeat
-				if val, ok := nodeMap.Load(nodeID); ok {
-					nodeStatus := val.(*NodeStatus)
-					nodeStatus.LastHeartbeat = time.Now()
-					nodeMap.Store(nodeID, nodeStatus)
-				}
-			}
-
-			// Store the log in Elasticsearch
-			err = ec.IndexLog(logData)
-			if err != nil {
-				fmt.Printf("Failed to index log: %v\n", err)
+	for {
+		var message *sarama.ConsumerMessage
+		select {
+		case <-ctx.Done():
+			return nil
+		case received, ok := <-partitionConsumer.Messages():
+			if !ok {
+				return nil
 			}
+			message = received
 		}
+		pipeline.Metrics.Consumed(message)
+
+		pipeline.Process(message, func() {
+			partitionOffsets.MarkOffset(message.Offset+1, "")
+		})
 
 		// Search for all documents in the index
 		// searchRes, err := ec.Client.Search(
@@ -160,53 +266,242 @@ func consumeTopic(brokers []string, topic string, ec *ElasticClient, wg *sync.Wa
 	}
 }
 
-func monitorNodes(nodeMap *sync.Map) {
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			os.Exit(runReplay(os.Args[2:]))
+		case "archive":
+			os.Exit(runArchive(os.Args[2:]))
+		case "restore":
+			os.Exit(runRestore(os.Args[2:]))
+		case "reindex":
+			os.Exit(runReindex(os.Args[2:]))
+		}
+	}
 
-	for {
-		<-ticker.C
-		currentTime := time.Now()
-
-		nodeMap.Range(func(key, value interface{}) bool {
-			nodeID := key.(int)
-			nodeStatus := value.(*NodeStatus)
-			if currentTime.Sub(nodeStatus.LastHeartbeat) > 30*time.Second && nodeStatus.Status == "UP" {
-				nodeStatus.Status = "DOWN"
-				nodeMap.Store(nodeID, nodeStatus)
-				nodeMap.Delete(nodeID)
-				logger.SendErrorLog(nodeID, "server", fmt.Sprintf("%d timed out", nodeID), "500", "node timed out")
-			}
+	configPath := flag.String("config", "", "Path to the JSON server config file")
+	testNotify := flag.Bool("test-notify", false, "Send a test alert to the notification channels and exit")
+	testChannel := flag.String("test-channel", "", "Only test this notification channel (with -test-notify)")
+	consoleFormat := flag.String("console", "", "Console output: color, logfmt, json or none (overrides the config)")
+	flag.Parse()
+	os.Exit(run(*configPath, *testNotify, *testChannel, *consoleFormat))
+}
 
-			return true
-		})
+// run starts the server and blocks until SIGINT or SIGTERM has been handled; it returns
+// the exit code, so deferred cleanup runs before the process exits
+func run(configPath string, testNotify bool, testChannel string, consoleFormat string) int {
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		log.Printf("Failed to load config: %v", err)
+		return 1
+	}
+	if consoleFormat != "" {
+		cfg.Console.Format = consoleFormat
+	}
+	console, err := NewConsole(cfg.Console)
+	if err != nil {
+		log.Printf("Failed to configure console: %v", err)
+		return 1
 	}
-}
 
-func main() {
-	brokers := []string{"localhost:9092"}
-	topics := []string{"logs", "critical_logs"}
-	elasticIndex := "kafka-logs"
-	logger.InitLogger(brokers, "critical_logs", "localhost", 24225) //change brokerIP here
+	// Load the alert rules, if any, and the channels they notify
+	var rules []AlertRule
+	if cfg.Alerting.RulesFile != "" {
+		rules, err = LoadAlertRules(cfg.Alerting.RulesFile)
+		if err != nil {
+			log.Printf("Failed to load alert rules: %v", err)
+			return 1
+		}
+	}
+	notifier, err := NewNotifier(cfg.Notifications, rules)
+	if err != nil {
+		log.Printf("Failed to configure notifications: %v", err)
+		return 1
+	}
+	if testNotify {
+		fmt.Println("Sending test notifications:")
+		if err := notifier.Test(testChannel); err != nil {
+			log.Printf("Test failed: %v", err)
+			return 1
+		}
+		return 0
+	}
+
+	// Cancelled on SIGINT or SIGTERM; every background loop stops with it
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := logger.InitLogger(cfg.Brokers, "critical_logs", cfg.FluentdHost, cfg.FluentdPort); err != nil {
+		log.Printf("Failed to initialize logger: %v", err)
+		return 1
+	}
 	defer logger.CloseLogger()
-	// Initialize Elasticsearch client
-	ec, err := NewElasticClient(elasticIndex)
+	// Open the log and alert history stores
+	logs, history, err := openStores(cfg)
 	if err != nil {
-		log.Fatalf("Failed to initialize Elasticsearch client: %v", err)
+		log.Printf("Failed to open log store: %v", err)
+		return 1
 	}
+	defer logs.Close()
+	defer history.Close()
 
-	nodeMap := &sync.Map{}
+	// One Kafka client for the consumers, the offset commits, the election and the readiness probe
+	kafkaClient, err := sarama.NewClient(cfg.Brokers, nil)
+	if err != nil {
+		log.Printf("Failed to create Kafka client: %v", err)
+		return 1
+	}
+	defer kafkaClient.Close()
 
-	// Start the monitor goroutine
-	go monitorNodes(nodeMap)
+	// Rebuild the registry quietly; the leader reports what changed while we were away
+	var elector *LeaderElector
+	registry := NewRegistry(cfg.Registry, nil)
+	restoreRegistry(cfg, registry)
+	announce := announceNodeEvent(cfg.Registry)
+	registry.SetNotify(func(event NodeEvent) {
+		if elector.IsLeader() {
+			announce(event)
+		}
+	})
 
-	// Spawn a consumer for each topic
+	// Start the snapshot goroutine; every instance keeps its own registry up to date
+	var background sync.WaitGroup
+	if cfg.Persistence.SnapshotPath != "" {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			snapshotRegistry(ctx, cfg.Persistence.SnapshotPath, time.Duration(cfg.Persistence.SnapshotInterval), registry)
+		}()
+	}
+
+	record := recordAlert(history)
+	alerts := NewAlertEngine(rules, func(alert Alert) {
+		record(alert)
+		notifier.Notify(alert)
+	})
+
+	var archiver *Archiver
+	if cfg.Archive.Enabled {
+		if archiver, err = NewArchiver(cfg.Archive, logs); err != nil {
+			log.Printf("Failed to configure the archive: %v", err)
+			return 1
+		}
+	}
+
+	// Node monitoring, alerting and archiving run on the leader only, for as long as it leads
+	lead := func(ctx context.Context) {
+		registry.Check(time.Now())
+		go monitorNodes(ctx, registry, time.Duration(cfg.Registry.CheckInterval))
+		go evaluateAlerts(ctx, alerts, time.Duration(cfg.Alerting.EvaluateInterval))
+		if archiver != nil {
+			go archiveLogs(ctx, archiver, time.Duration(cfg.Archive.Interval))
+		}
+	}
+	offsetGroup := cfg.ConsumerGroup
+	if cfg.HA.Enabled {
+		elector, err = NewLeaderElector(cfg.HA, cfg.Brokers, kafkaClient, lead)
+		if err != nil {
+			log.Printf("Failed to set up leader election: %v", err)
+			return 1
+		}
+		// Every instance ingests everything, so each one needs its own offsets
+		offsetGroup = cfg.ConsumerGroup + "-" + elector.InstanceID()
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			elector.Run(ctx)
+		}()
+	} else {
+		lead(ctx)
+	}
+
+	offsets, err := sarama.NewOffsetManagerFromClient(offsetGroup, kafkaClient)
+	if err != nil {
+		log.Printf("Failed to create offset manager: %v", err)
+		return 1
+	}
+
+	// Quota violations are reported by the leader only, like node events
+	quotas, err := NewQuotaManager(cfg.Quotas, func(violation QuotaViolation) {
+		if elector.IsLeader() {
+			sendSafely(func() {
+				logger.SendWarnLog(violation.NodeID, "server", fmt.Sprintf("%s %s is over its quota of %s, %d INFO logs dropped", violation.Scope, violation.Key, violation.Limit, violation.Dropped))
+			})
+		}
+	})
+	if err != nil {
+		log.Printf("Failed to configure quotas: %v", err)
+		return 1
+	}
+
+	validator, err := NewValidator(cfg.Validation, cfg.Brokers)
+	if err != nil {
+		log.Printf("Failed to configure validation: %v", err)
+		return 1
+	}
+	defer validator.Close()
+
+	metrics := NewMetrics(registry)
+	indexer := NewIndexer(logs, metrics, cfg.Indexing)
+	go indexer.Run()
+
+	pipeline := &Pipeline{
+		Indexer:   indexer,
+		Validator: validator,
+		Console:   console,
+		Enricher:  NewEnricher(cfg.Enrich, registry),
+		Quotas:    quotas,
+		Registry:  registry,
+		Alerts:    alerts,
+		Tail:      NewTailHub(cfg.TailBuffer),
+		Metrics:   metrics,
+	}
+
+	if cfg.APIAddress != "" {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			serveAPI(ctx, cfg.APIAddress, &API{
+				Tail:       pipeline.Tail,
+				Metrics:    pipeline.Metrics,
+				Health:     &HealthChecker{Kafka: kafkaClient, Store: logs, Timeout: 5 * time.Second},
+				Leader:     elector,
+				Quotas:     quotas,
+				Validation: validator,
+				Registry:   registry,
+				Token:      cfg.APIToken,
+			})
+		}()
+	}
+
+	// Spawn a consumer for each topic; one failing shuts the whole server down
 	var wg sync.WaitGroup
-	for _, topic := range topics {
+	for _, topic := range cfg.Topics {
 		wg.Add(1)
-		go consumeTopic(brokers, topic, ec, &wg, nodeMap)
+		go func() {
+			defer wg.Done()
+			if err := consumeTopic(ctx, kafkaClient, offsets, topic, pipeline); err != nil {
+				log.Printf("Consumer of %s failed: %v", topic, err)
+				stop()
+			}
+		}()
 	}
 
+	<-ctx.Done()
+	fmt.Println("Shutting down...")
 	wg.Wait()
 	fmt.Println("All consumers stopped.")
+
+	// Store what is still queued, then commit the offsets of everything stored
+	exitCode := 0
+	if err := indexer.Drain(time.Duration(cfg.ShutdownTimeout)); err != nil {
+		log.Printf("Failed to drain the indexing queue: %v", err)
+		exitCode = 1
+	}
+	if err := offsets.Close(); err != nil {
+		log.Printf("Failed to commit offsets: %v", err)
+	}
+	background.Wait()
+	return exitCode
 }
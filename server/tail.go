@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tailKeepAlive is how often an idle stream gets a comment line so proxies keep it open
+const tailKeepAlive = 15 * time.Second
+
+// TailFilter selects the logs a tail client wants; empty fields match everything
+type TailFilter struct {
+	Levels   []string
+	Services []string
+	Nodes    []int
+	Contains string
+	TraceID  string
+}
+
+// parseTailFilter reads a filter from the query string: level, service and node may be
+// repeated or comma separated, contains and trace_id are single values
+func parseTailFilter(r *http.Request) (TailFilter, error) {
+	query := r.URL.Query()
+	list := func(key string) []string {
+		var values []string
+		for _, value := range query[key] {
+			for _, part := range strings.Split(value, ",") {
+				if part = strings.TrimSpace(part); part != "" {
+					values = append(values, part)
+				}
+			}
+		}
+		return values
+	}
+
+	filter := TailFilter{
+		Levels:   list("level"),
+		Services: list("service"),
+		Contains: query.Get("contains"),
+		TraceID:  query.Get("trace_id"),
+	}
+	for _, node := range list("node") {
+		nodeID, err := strconv.Atoi(node)
+		if err != nil {
+			return filter, fmt.Errorf("invalid node %q", node)
+		}
+		filter.Nodes = append(filter.Nodes, nodeID)
+	}
+	return filter, nil
+}
+
+// Match reports whether a log passes the filter
+func (f TailFilter) Match(logData map[string]interface{}) bool {
+	if len(f.Levels) > 0 {
+		level, _ := logData["log_level"].(string)
+		if level == "" {
+			level, _ = logData["message_type"].(string)
+		}
+		if !containsFold(f.Levels, level) {
+			return false
+		}
+	}
+	if len(f.Services) > 0 {
+		service, _ := logData["service_name"].(string)
+		if !containsFold(f.Services, service) {
+			return false
+		}
+	}
+	if len(f.Nodes) > 0 {
+		nodeID, _ := logData["node_id"].(float64)
+		found := false
+		for _, wanted := range f.Nodes {
+			if int(nodeID) == wanted {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.TraceID != "" {
+		traceID, _ := logData["trace_id"].(string)
+		if traceID != f.TraceID {
+			return false
+		}
+	}
+	if f.Contains != "" {
+		message, _ := logData["message"].(string)
+		if !strings.Contains(strings.ToLower(message), strings.ToLower(f.Contains)) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsFold reports whether values holds s, ignoring case
+func containsFold(values []string, s string) bool {
+	for _, value := range values {
+		if strings.EqualFold(value, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// tailClient is one connected stream
+type tailClient struct {
+	filter  TailFilter
+	ch      chan []byte
+	dropped atomic.Int64
+}
+
+// TailHub fans consumed logs out to the connected tail clients
+type TailHub struct {
+	mu      sync.RWMutex
+	clients map[*tailClient]struct{}
+	buffer  int
+}
+
+// NewTailHub creates a hub whose clients may fall behind by up to buffer logs
+func NewTailHub(buffer int) *TailHub {
+	return &TailHub{clients: make(map[*tailClient]struct{}), buffer: buffer}
+}
+
+// Publish offers a log to every interested client; a client whose buffer is full misses
+// the log and is told how many it missed, ingestion never waits for a client
+func (h *TailHub) Publish(logData map[string]interface{}) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var data []byte
+	for client := range h.clients {
+		if !client.filter.Match(logData) {
+			continue
+		}
+		if data == nil {
+			var err error
+			if data, err = json.Marshal(logData); err != nil {
+				return
+			}
+		}
+		select {
+		case client.ch <- data:
+		default:
+			client.dropped.Add(1)
+		}
+	}
+}
+
+func (h *TailHub) add(client *tailClient) {
+	h.mu.Lock()
+	h.clients[client] = struct{}{}
+	h.mu.Unlock()
+}
+
+func (h *TailHub) remove(client *tailClient) {
+	h.mu.Lock()
+	delete(h.clients, client)
+	h.mu.Unlock()
+}
+
+// ServeHTTP streams matching logs as Server-Sent Events: "log" events carry a log as JSON,
+// "dropped" events tell the client how many logs it missed because it was too slow
+func (h *TailHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	filter, err := parseTailFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client := &tailClient{filter: filter, ch: make(chan []byte, h.buffer)}
+	h.add(client)
+	defer h.remove(client)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	fmt.Fprintf(w, ": tailing logs\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(tailKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case data := <-client.ch:
+			if dropped := client.dropped.Swap(0); dropped > 0 {
+				fmt.Fprintf(w, "event: dropped\ndata: {\"dropped\": %d}\n\n", dropped)
+			}
+			fmt.Fprintf(w, "event: log\ndata: %s\n\n", data)
+			flusher.Flush()
+		case <-keepAlive.C:
+			if dropped := client.dropped.Swap(0); dropped > 0 {
+				fmt.Fprintf(w, "event: dropped\ndata: {\"dropped\": %d}\n\n", dropped)
+			} else {
+				fmt.Fprintf(w, ": keep-alive\n\n")
+			}
+			flusher.Flush()
+		}
+	}
+}
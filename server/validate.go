@@ -0,0 +1,418 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"example.com/store"
+
+	"github.com/IBM/sarama"
+)
+
+// Validation modes
+const (
+	ValidationOff    = "off"
+	ValidationReject = "reject" // invalid messages go to the dead-letter topic
+	ValidationCoerce = "coerce" // fix what can be fixed, tag the log with what was wrong
+	ValidationWarn   = "warn"   // keep the log as it is and print a warning
+)
+
+// Field types
+const (
+	FieldString  = "string"
+	FieldNumber  = "number"
+	FieldInteger = "integer"
+	FieldBoolean = "boolean"
+	FieldObject  = "object"
+)
+
+// validationWarnInterval is how often warn mode prints a warning for the same node
+const validationWarnInterval = time.Minute
+
+// ValidationConfig controls schema validation at ingestion
+type ValidationConfig struct {
+	Mode            string `json:"mode"`
+	SchemaFile      string `json:"schema_file"` // replaces the built-in schema
+	DeadLetterTopic string `json:"dead_letter_topic"`
+}
+
+// FieldRule describes one field of a message
+type FieldRule struct {
+	Type      string   `json:"type"`
+	Required  bool     `json:"required,omitempty"`
+	Enum      []string `json:"enum,omitempty"`
+	MaxLength int      `json:"max_length,omitempty"` // in characters, not bytes
+	Pattern   string   `json:"pattern,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// MessageSchema lists the fields of a message type; other fields are allowed
+type MessageSchema struct {
+	Fields map[string]*FieldRule `json:"fields"`
+}
+
+// Schema maps message types to their schema; types without one aren't validated
+type Schema map[string]MessageSchema
+
+// serviceNamePattern rejects service names with spaces, like "origin-server "
+const serviceNamePattern = `^[A-Za-z0-9][A-Za-z0-9_.-]*$`
+
+// DefaultSchema describes the messages the logger package sends
+func DefaultSchema() Schema {
+	service := func(required bool) *FieldRule {
+		return &FieldRule{Type: FieldString, Required: required, MaxLength: 64, Pattern: serviceNamePattern}
+	}
+	return Schema{
+		"LOG": {Fields: map[string]*FieldRule{
+			"node_id":       {Type: FieldInteger, Required: true},
+			"log_id":        {Type: FieldInteger},
+			"log_level":     {Type: FieldString, Required: true, Enum: []string{"INFO", "WARN", "ERROR"}},
+			"message":       {Type: FieldString, Required: true, MaxLength: 8192},
+			"service_name":  service(true),
+			"timestamp":     {Type: FieldString, Required: true, MaxLength: 64},
+			"error_details": {Type: FieldObject},
+		}},
+		"HEARTBEAT": {Fields: map[string]*FieldRule{
+			"node_id":   {Type: FieldInteger, Required: true},
+			"status":    {Type: FieldString, Required: true, Enum: []string{"UP", "DOWN"}},
+			"timestamp": {Type: FieldString, Required: true, MaxLength: 64},
+		}},
+		"REGISTRATION": {Fields: map[string]*FieldRule{
+			"node_id":      {Type: FieldInteger, Required: true},
+			"service_name": service(true),
+			"status":       {Type: FieldString, Enum: []string{"UP", "DOWN"}},
+			"timestamp":    {Type: FieldString, Required: true, MaxLength: 64},
+			"host":         {Type: FieldString, MaxLength: 255},
+			"version":      {Type: FieldString, MaxLength: 64},
+			"address":      {Type: FieldString, MaxLength: 255},
+		}},
+	}
+}
+
+// LoadSchema reads a schema file
+func LoadSchema(path string) (Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema: %w", err)
+	}
+	var schema Schema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("failed to parse schema %s: %w", path, err)
+	}
+	return schema, nil
+}
+
+// compile checks the rules of a schema and prepares their patterns
+func (s Schema) compile() error {
+	for messageType, message := range s {
+		for name, rule := range message.Fields {
+			switch rule.Type {
+			case "", FieldString, FieldNumber, FieldInteger, FieldBoolean, FieldObject:
+			default:
+				return fmt.Errorf("%s.%s: unknown type %q", messageType, name, rule.Type)
+			}
+			if rule.Pattern != "" {
+				pattern, err := regexp.Compile(rule.Pattern)
+				if err != nil {
+					return fmt.Errorf("%s.%s: invalid pattern: %w", messageType, name, err)
+				}
+				rule.pattern = pattern
+			}
+		}
+	}
+	return nil
+}
+
+// truncate cuts s down to its first n characters, never in the middle of one
+func truncate(s string, n int) string {
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+	return s
+}
+
+// check validates one field; in coerce mode it also returns the fixed value when the
+// problem could be fixed
+func (rule *FieldRule) check(value interface{}, coerce bool) (interface{}, string, bool) {
+	fixed := false
+
+	switch rule.Type {
+	case FieldString:
+		s, ok := value.(string)
+		if !ok {
+			if number, isNumber := value.(float64); isNumber && coerce {
+				s, fixed = strconv.FormatFloat(number, 'f', -1, 64), true
+			} else {
+				return value, "must be a string", false
+			}
+		}
+		if trimmed := strings.TrimSpace(s); trimmed != s {
+			if !coerce {
+				return value, "has leading or trailing spaces", false
+			}
+			s, fixed = trimmed, true
+		}
+		if len(rule.Enum) > 0 && !containsString(rule.Enum, s) {
+			match := ""
+			for _, allowed := range rule.Enum {
+				if strings.EqualFold(allowed, s) {
+					match = allowed
+				}
+			}
+			if match == "" || !coerce {
+				return value, fmt.Sprintf("must be one of %s", strings.Join(rule.Enum, ", ")), false
+			}
+			s, fixed = match, true
+		}
+		if rule.MaxLength > 0 && utf8.RuneCountInString(s) > rule.MaxLength {
+			if !coerce {
+				return value, fmt.Sprintf("is longer than %d characters", rule.MaxLength), false
+			}
+			s, fixed = truncate(s, rule.MaxLength), true
+		}
+		if rule.pattern != nil && !rule.pattern.MatchString(s) {
+			return value, fmt.Sprintf("doesn't match %s", rule.Pattern), false
+		}
+		if fixed {
+			return s, "", true
+		}
+	case FieldNumber, FieldInteger:
+		number, ok := value.(float64)
+		if !ok {
+			s, isString := value.(string)
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if !isString || err != nil || !coerce {
+				return value, "must be a number", false
+			}
+			number, fixed = parsed, true
+		}
+		if rule.Type == FieldInteger && number != math.Trunc(number) {
+			return value, "must be an integer", false
+		}
+		if fixed {
+			return number, "", true
+		}
+	case FieldBoolean:
+		if _, ok := value.(bool); !ok {
+			return value, "must be a boolean", false
+		}
+	case FieldObject:
+		if _, ok := value.(map[string]interface{}); !ok {
+			return value, "must be an object", false
+		}
+	}
+	return value, "", false
+}
+
+func containsString(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
+
+// validationCounts are the validation failures of one producing node
+type validationCounts struct {
+	Rejected   int64     `json:"rejected"`
+	Coerced    int64     `json:"coerced"`
+	Warned     int64     `json:"warned"`
+	LastError  string    `json:"last_error"`
+	LastFailed time.Time `json:"last_failed"`
+
+	lastWarning time.Time
+}
+
+// Validator checks messages against the schema of their type
+type Validator struct {
+	mu          sync.Mutex
+	mode        string
+	schema      Schema
+	topic       string
+	deadLetters sarama.SyncProducer
+	counts      map[int]*validationCounts
+}
+
+// NewValidator creates a validator; reject mode needs brokers to send dead letters to
+func NewValidator(cfg ValidationConfig, brokers []string) (*Validator, error) {
+	v := &Validator{mode: cfg.Mode, schema: DefaultSchema(), topic: cfg.DeadLetterTopic, counts: make(map[int]*validationCounts)}
+	switch cfg.Mode {
+	case "":
+		v.mode = ValidationOff
+	case ValidationOff, ValidationReject, ValidationCoerce, ValidationWarn:
+	default:
+		return nil, fmt.Errorf("unknown validation mode %q", cfg.Mode)
+	}
+
+	if cfg.SchemaFile != "" {
+		schema, err := LoadSchema(cfg.SchemaFile)
+		if err != nil {
+			return nil, err
+		}
+		v.schema = schema
+	}
+	if err := v.schema.compile(); err != nil {
+		return nil, err
+	}
+
+	if v.mode == ValidationReject && v.topic != "" {
+		config := sarama.NewConfig()
+		config.Producer.Return.Successes = true
+		producer, err := sarama.NewSyncProducer(brokers, config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create dead-letter producer: %w", err)
+		}
+		v.deadLetters = producer
+	}
+	return v, nil
+}
+
+// Validate checks a message and reports whether it should go on through the pipeline;
+// in coerce mode the message is fixed in place and tagged with its problems
+func (v *Validator) Validate(logData map[string]interface{}, source string) bool {
+	if v == nil || v.mode == ValidationOff {
+		return true
+	}
+	messageType, _ := logData["message_type"].(string)
+	schema, ok := v.schema[messageType]
+	if !ok {
+		return true
+	}
+
+	var problems []string
+	coerce := v.mode == ValidationCoerce
+	for _, name := range sortedFields(schema.Fields) {
+		rule := schema.Fields[name]
+		value, present := logData[name]
+		if !present || value == nil {
+			if rule.Required {
+				problems = append(problems, name+" is missing")
+			}
+			continue
+		}
+		fixedValue, problem, fixed := rule.check(value, coerce)
+		if fixed {
+			problems = append(problems, fmt.Sprintf("%s was %s", name, describeValue(value)))
+			logData[name] = fixedValue
+		} else if problem != "" {
+			problems = append(problems, name+" "+problem)
+		}
+	}
+	if len(problems) == 0 {
+		return true
+	}
+
+	// Failures are counted against the producing node, even when its ID is malformed
+	nodeID := -1
+	if id, err := strconv.Atoi(strings.TrimSpace(store.FormatValue(logData["node_id"]))); err == nil {
+		nodeID = id
+	}
+	switch v.mode {
+	case ValidationReject:
+		v.count(nodeID, problems, func(c *validationCounts) { c.Rejected++ })
+		v.deadLetter(logData, source, problems)
+		return false
+	case ValidationCoerce:
+		v.count(nodeID, problems, func(c *validationCounts) { c.Coerced++ })
+		logData["schema_violations"] = problems
+	case ValidationWarn:
+		warn := false
+		v.count(nodeID, problems, func(c *validationCounts) {
+			c.Warned++
+			if time.Since(c.lastWarning) >= validationWarnInterval {
+				c.lastWarning = time.Now()
+				warn = true
+			}
+		})
+		if warn {
+			log.Printf("Invalid %s from node %d: %s", messageType, nodeID, strings.Join(problems, "; "))
+		}
+	}
+	return true
+}
+
+func sortedFields(fields map[string]*FieldRule) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// describeValue quotes strings so stray spaces show
+func describeValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return strconv.Quote(s)
+	}
+	return fmt.Sprint(value)
+}
+
+// count records a validation failure of a node
+func (v *Validator) count(nodeID int, problems []string, update func(*validationCounts)) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	counts, ok := v.counts[nodeID]
+	if !ok {
+		counts = &validationCounts{}
+		v.counts[nodeID] = counts
+	}
+	update(counts)
+	counts.LastError = strings.Join(problems, "; ")
+	counts.LastFailed = time.Now()
+}
+
+// deadLetter sends a rejected message with its problems to the dead-letter topic
+func (v *Validator) deadLetter(logData map[string]interface{}, source string, problems []string) {
+	if v.deadLetters == nil {
+		return
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"source":      source,
+		"problems":    problems,
+		"rejected_at": time.Now().UTC().Format(time.RFC3339Nano),
+		"message":     logData,
+	})
+	if err != nil {
+		log.Printf("Failed to encode dead letter: %v", err)
+		return
+	}
+	if _, _, err := v.deadLetters.SendMessage(&sarama.ProducerMessage{Topic: v.topic, Value: sarama.ByteEncoder(data)}); err != nil {
+		log.Printf("Failed to send dead letter: %v", err)
+	}
+}
+
+// Close releases the dead-letter producer
+func (v *Validator) Close() error {
+	if v == nil || v.deadLetters == nil {
+		return nil
+	}
+	return v.deadLetters.Close()
+}
+
+// ServeHTTP reports the validation failures per producing node
+func (v *Validator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	nodes := make(map[string]validationCounts, len(v.counts))
+	for nodeID, counts := range v.counts {
+		nodes[strconv.Itoa(nodeID)] = *counts
+	}
+	v.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"mode": v.mode, "nodes": nodes})
+}
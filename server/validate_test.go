@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+// testLog returns a valid LOG message
+func testLog() map[string]interface{} {
+	return map[string]interface{}{
+		"message_type": "LOG",
+		"node_id":      float64(3),
+		"log_level":    "ERROR",
+		"message":      "disk full",
+		"service_name": "cache",
+		"timestamp":    "2026-10-19T08:00:00Z",
+	}
+}
+
+func newTestValidator(t *testing.T, mode string) *Validator {
+	t.Helper()
+	v, err := NewValidator(ValidationConfig{Mode: mode}, nil)
+	if err != nil {
+		t.Fatalf("NewValidator: %v", err)
+	}
+	return v
+}
+
+func TestValidatorModes(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		change     func(map[string]interface{})
+		keep       bool
+		violations []string
+		fixed      map[string]interface{}
+	}{
+		{"valid", ValidationReject, func(map[string]interface{}) {}, true, nil, nil},
+		{"off", ValidationOff, func(m map[string]interface{}) { delete(m, "message") }, true, nil, nil},
+		{"unknown type", ValidationReject, func(m map[string]interface{}) { m["message_type"] = "METRIC" }, true, nil, nil},
+		{"missing field", ValidationReject, func(m map[string]interface{}) { delete(m, "message") }, false, nil, nil},
+		{"bad enum", ValidationReject, func(m map[string]interface{}) { m["log_level"] = "FATAL" }, false, nil, nil},
+		{"bad pattern", ValidationReject, func(m map[string]interface{}) { m["service_name"] = "origin server" }, false, nil, nil},
+		{"not an integer", ValidationReject, func(m map[string]interface{}) { m["node_id"] = 3.5 }, false, nil, nil},
+		{"warn keeps", ValidationWarn, func(m map[string]interface{}) { m["log_level"] = "FATAL" }, true, nil, nil},
+		{
+			"coerce fixes", ValidationCoerce,
+			func(m map[string]interface{}) {
+				m["log_level"], m["node_id"], m["service_name"] = "error", "3", "cache "
+			},
+			true,
+			[]string{"log_level was \"error\"", "node_id was \"3\"", "service_name was \"cache \""},
+			map[string]interface{}{"log_level": "ERROR", "node_id": float64(3), "service_name": "cache"},
+		},
+		{
+			"coerce truncates characters", ValidationCoerce,
+			func(m map[string]interface{}) { m["timestamp"] = strings.Repeat("é", 65) },
+			true,
+			[]string{"timestamp was \"" + strings.Repeat("é", 65) + "\""},
+			map[string]interface{}{"timestamp": strings.Repeat("é", 64)},
+		},
+		{"characters within the limit", ValidationReject, func(m map[string]interface{}) { m["timestamp"] = strings.Repeat("é", 64) }, true, nil, nil},
+		{
+			"coerce can't fix", ValidationCoerce,
+			func(m map[string]interface{}) { m["log_level"] = "FATAL" },
+			true,
+			[]string{"log_level must be one of INFO, WARN, ERROR"},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newTestValidator(t, tt.mode)
+			logData := testLog()
+			tt.change(logData)
+			if keep := v.Validate(logData, "logs-0-1"); keep != tt.keep {
+				t.Errorf("Validate = %v, want %v", keep, tt.keep)
+			}
+			violations, _ := logData["schema_violations"].([]string)
+			if !reflect.DeepEqual(violations, tt.violations) {
+				t.Errorf("schema_violations = %q, want %q", violations, tt.violations)
+			}
+			for field, want := range tt.fixed {
+				if logData[field] != want {
+					t.Errorf("%s = %#v, want %#v", field, logData[field], want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidatorCountsPerNode(t *testing.T) {
+	v := newTestValidator(t, ValidationReject)
+	for i := 0; i < 2; i++ {
+		logData := testLog()
+		delete(logData, "message")
+		v.Validate(logData, "logs-0-1")
+	}
+	logData := testLog()
+	logData["node_id"] = "three"
+	v.Validate(logData, "logs-0-2")
+
+	if counts := v.counts[3]; counts == nil || counts.Rejected != 2 || counts.LastError != "message is missing" {
+		t.Errorf("node 3 counts = %+v", counts)
+	}
+	if counts := v.counts[-1]; counts == nil || counts.Rejected != 1 {
+		t.Errorf("malformed node counts = %+v", counts)
+	}
+}
+
+func TestPipelineNormalizesBeforeValidation(t *testing.T) {
+	registry := NewRegistry(RegistryConfig{}, nil)
+	tests := []struct {
+		name      string
+		normalize bool
+		reason    string
+	}{
+		{"normalized", true, ""},
+		{"not normalized", false, "message_type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline := &Pipeline{
+				Validator: newTestValidator(t, ValidationReject),
+				Enricher:  NewEnricher(EnrichConfig{NormalizeCase: tt.normalize}, registry),
+				Registry:  registry,
+				Metrics:   NewMetrics(registry),
+			}
+			logData := testLog()
+			logData["message_type"], logData["log_level"], logData["service_name"] = "log", "error", "Cache"
+			value, _ := json.Marshal(logData)
+
+			acked := false
+			message := &sarama.ConsumerMessage{Topic: "logs", Offset: 7, Value: value}
+			if reason := pipeline.Process(message, func() { acked = true }); reason != tt.reason {
+				t.Errorf("Process = %q, want %q", reason, tt.reason)
+			}
+			// Without an indexer only skipped messages are acknowledged
+			if acked != (tt.reason != "") {
+				t.Errorf("acknowledged = %v", acked)
+			}
+		})
+	}
+}
@@ -0,0 +1,727 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// ElasticConfig says how to reach an Elasticsearch index
+type ElasticConfig struct {
+	Addresses []string `json:"addresses"`
+	Username  string   `json:"username"`
+	Password  string   `json:"password"`
+	Index     string   `json:"index"`
+}
+
+// ElasticStore keeps logs in an Elasticsearch index
+type ElasticStore struct {
+	Client *elasticsearch.Client
+	Index  string
+}
+
+// indexMapping is applied when the store creates its index: every string is a keyword
+// except the message, which is also searchable as text
+const indexMapping = `{
+	"mappings": {
+		"dynamic_templates": [
+			{"strings": {"match_mapping_type": "string", "mapping": {"type": "keyword", "ignore_above": 1024}}}
+		],
+		"properties": {
+			"@timestamp":  {"type": "date"},
+			"ingested_at": {"type": "date"},
+			"event_id":    {"type": "keyword"},
+			"node_id":     {"type": "long"},
+			"log_id":      {"type": "long"},
+			"message":     {"type": "text", "fields": {"keyword": {"type": "keyword", "ignore_above": 1024}}}
+		}
+	}
+}`
+
+// NewElasticStore connects to Elasticsearch and creates the index if it doesn't exist
+func NewElasticStore(cfg ElasticConfig) (*ElasticStore, error) {
+	client, err := elasticsearch.NewClient(elasticsearch.Config{
+		Addresses: cfg.Addresses,
+		Username:  cfg.Username,
+		Password:  cfg.Password,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ElasticStore{Client: client, Index: cfg.Index}, nil
+}
+
+// EnsureIndex creates the index with the store's mapping unless it already exists, in
+// which case it checks the index has that mapping
+func (es *ElasticStore) EnsureIndex() error {
+	res, err := es.Client.Indices.Exists([]string{es.Index})
+	if err != nil {
+		return fmt.Errorf("failed to check index %s: %w", es.Index, err)
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		return es.CheckMapping()
+	}
+
+	res, err = es.Client.Indices.Create(es.Index, es.Client.Indices.Create.WithBody(strings.NewReader(indexMapping)))
+	if err != nil {
+		return fmt.Errorf("failed to create index %s: %w", es.Index, err)
+	}
+	defer res.Body.Close()
+	// Another instance may have created it in the meantime
+	if res.IsError() && !strings.Contains(readBody(res), "resource_already_exists_exception") {
+		return fmt.Errorf("failed to create index %s: %s", es.Index, res.Status())
+	}
+	return nil
+}
+
+// mappedTypes are the types filters, sorts and ranges rely on. An index Elasticsearch
+// mapped dynamically, before the store created its indices, has text fields instead,
+// which term filters never match, and no @timestamp.
+var mappedTypes = map[string]string{
+	FieldTimestamp:                "date",
+	FieldEventID:                  "keyword",
+	"log_level":                   "keyword",
+	"service_name":                "keyword",
+	"message_type":                "keyword",
+	"error_details.error_code":    "keyword",
+	"error_details.error_message": "keyword",
+}
+
+// MappingError says an index doesn't have the mapping the store queries it with
+type MappingError struct {
+	Index string
+	Field string
+	Type  string // empty when the field isn't mapped
+	Want  string
+}
+
+func (e *MappingError) Error() string {
+	problem := fmt.Sprintf("has no %s field of type %s", e.Field, e.Want)
+	if e.Type != "" {
+		problem = fmt.Sprintf("maps %s as %s instead of %s", e.Field, e.Type, e.Want)
+	}
+	return fmt.Sprintf("index %s %s, it predates the log store mapping and must be reindexed", e.Index, problem)
+}
+
+// CheckMapping returns a MappingError when the index exists without the store's mapping;
+// a missing index passes, the server creates it
+func (es *ElasticStore) CheckMapping() error {
+	fields := make([]string, 0, len(mappedTypes))
+	for field := range mappedTypes {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	res, err := es.Client.Indices.GetFieldMapping(fields, es.Client.Indices.GetFieldMapping.WithIndex(es.Index))
+	if err != nil {
+		return fmt.Errorf("failed to read mapping of %s: %w", es.Index, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if res.IsError() {
+		return fmt.Errorf("failed to read mapping of %s: %s", es.Index, res.Status())
+	}
+	var response map[string]struct {
+		Mappings map[string]struct {
+			Mapping map[string]struct {
+				Type string `json:"type"`
+			} `json:"mapping"`
+		} `json:"mappings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return fmt.Errorf("failed to decode mapping of %s: %w", es.Index, err)
+	}
+
+	for index, mapping := range response {
+		for _, field := range fields {
+			found, ok := mapping.Mappings[field]
+			if !ok {
+				// Fields other than @timestamp appear with the first log that has them
+				if field == FieldTimestamp {
+					return &MappingError{Index: index, Field: field, Want: mappedTypes[field]}
+				}
+				continue
+			}
+			leaf := field[strings.LastIndex(field, ".")+1:]
+			if typ := found.Mapping[leaf].Type; typ != mappedTypes[field] {
+				return &MappingError{Index: index, Field: field, Type: typ, Want: mappedTypes[field]}
+			}
+		}
+	}
+	return nil
+}
+
+// readBody returns the body of a response as a string
+func readBody(res *esapi.Response) string {
+	data, _ := io.ReadAll(res.Body)
+	return string(data)
+}
+
+// Append stores documents with the bulk API, using their event_id as document ID
+func (es *ElasticStore) Append(docs ...Document) error {
+	if len(docs) == 0 {
+		return nil
+	}
+
+	var body bytes.Buffer
+	for _, doc := range docs {
+		action := map[string]interface{}{"_index": es.Index}
+		if id := doc.String(FieldEventID); id != "" {
+			action["_id"] = id
+		}
+		meta, _ := json.Marshal(map[string]interface{}{"index": action})
+		data, err := json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		body.Write(meta)
+		body.WriteByte('\n')
+		body.Write(data)
+		body.WriteByte('\n')
+	}
+
+	res, err := es.Client.Bulk(&body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("bulk request failed: %s", res.Status())
+	}
+
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int `json:"status"`
+			Error  struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode bulk response: %w", err)
+	}
+	if !result.Errors {
+		return nil
+	}
+	failed := 0
+	reason := ""
+	for _, item := range result.Items {
+		for _, status := range item {
+			if status.Status >= 300 {
+				failed++
+				reason = status.Error.Type + ": " + status.Error.Reason
+			}
+		}
+	}
+	return fmt.Errorf("%d of %d documents failed, last error: %s", failed, len(docs), reason)
+}
+
+// ElasticFilter translates a filter to the Elasticsearch query DSL
+func ElasticFilter(f Filter) map[string]interface{} {
+	switch filter := f.(type) {
+	case nil:
+		return map[string]interface{}{"match_all": map[string]interface{}{}}
+	case Term:
+		return map[string]interface{}{"terms": map[string]interface{}{filter.Field: filter.Values}}
+	case Contains:
+		return map[string]interface{}{"wildcard": map[string]interface{}{
+			keywordField(filter.Field): map[string]interface{}{"value": "*" + escapeWildcard(filter.Text) + "*", "case_insensitive": true},
+		}}
+	case Text:
+		return map[string]interface{}{"match": map[string]interface{}{
+			filter.Field: map[string]interface{}{"query": filter.Query, "operator": "and"},
+		}}
+	case Regexp:
+		pattern, caseInsensitive := luceneRegexp(filter.Pattern.String())
+		return map[string]interface{}{"regexp": map[string]interface{}{
+			keywordField(filter.Field): map[string]interface{}{"value": pattern, "case_insensitive": caseInsensitive, "flags": "NONE"},
+		}}
+	case Wildcard:
+		return map[string]interface{}{"wildcard": map[string]interface{}{
+			keywordField(filter.Field): map[string]interface{}{"value": filter.Pattern},
+		}}
+	case Range:
+		return rangeQuery(filter)
+	case IDs:
+		return map[string]interface{}{"ids": map[string]interface{}{"values": []string(filter)}}
+	case And:
+		return boolQuery("filter", filter)
+	case Or:
+		query := boolQuery("should", filter)
+		query["bool"].(map[string]interface{})["minimum_should_match"] = 1
+		return query
+	case Not:
+		return map[string]interface{}{"bool": map[string]interface{}{"must_not": []interface{}{ElasticFilter(filter.Filter)}}}
+	}
+	panic(fmt.Sprintf("store: unsupported filter %T", f))
+}
+
+// rangeOps names the bounds of a range query
+var rangeOps = map[string]string{">": "gt", ">=": "gte", "<": "lt", "<=": "lte"}
+
+// numberRangeScript compares a field with a number; the logger sends numbers such as
+// response times as strings, which are indexed as keywords a range query would compare
+// as text
+const numberRangeScript = `
+def field = params.field;
+if (!doc.containsKey(field) || doc[field].size() == 0) {
+	return false;
+}
+def value = doc[field].value;
+double number;
+if (value instanceof String) {
+	try {
+		number = Double.parseDouble(value.trim());
+	} catch (NumberFormatException e) {
+		return false;
+	}
+} else {
+	number = ((Number) value).doubleValue();
+}
+if (params.op == '>') {
+	return number > params.value;
+} else if (params.op == '>=') {
+	return number >= params.value;
+} else if (params.op == '<') {
+	return number < params.value;
+}
+return number <= params.value;`
+
+// rangeQuery translates a range: a range query for times, a script for numbers
+func rangeQuery(filter Range) map[string]interface{} {
+	if t, ok := filter.Value.(time.Time); ok {
+		return map[string]interface{}{"range": map[string]interface{}{
+			filter.Field: map[string]interface{}{rangeOps[filter.Op]: t.Format(time.RFC3339Nano)},
+		}}
+	}
+	return map[string]interface{}{"script": map[string]interface{}{
+		"script": map[string]interface{}{
+			"source": numberRangeScript,
+			"params": map[string]interface{}{"field": filter.Field, "op": filter.Op, "value": filter.Value},
+		},
+	}}
+}
+
+// keywordField returns the keyword version of a field; the message is the only text field
+func keywordField(field string) string {
+	if field == "message" {
+		return "message.keyword"
+	}
+	return field
+}
+
+// luceneRegexp turns a Go pattern, which matches anywhere unless anchored, into a
+// Lucene one, which must match the whole value; a leading (?i) becomes a flag
+func luceneRegexp(pattern string) (string, bool) {
+	caseInsensitive := strings.HasPrefix(pattern, "(?i)")
+	pattern = strings.TrimPrefix(pattern, "(?i)")
+	if strings.HasPrefix(pattern, "^") {
+		pattern = pattern[1:]
+	} else {
+		pattern = ".*" + pattern
+	}
+	if strings.HasSuffix(pattern, "$") && !strings.HasSuffix(pattern, `\$`) {
+		pattern = pattern[:len(pattern)-1]
+	} else {
+		pattern += ".*"
+	}
+	return pattern, caseInsensitive
+}
+
+// escapeWildcard escapes the characters that have a meaning in a wildcard query
+func escapeWildcard(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`).Replace(s)
+}
+
+func boolQuery(occur string, filters []Filter) map[string]interface{} {
+	clauses := make([]interface{}, len(filters))
+	for i, filter := range filters {
+		clauses[i] = ElasticFilter(filter)
+	}
+	return map[string]interface{}{"bool": map[string]interface{}{occur: clauses}}
+}
+
+// ElasticQuery translates the filter and time range of a query to the query DSL
+func ElasticQuery(q Query) map[string]interface{} {
+	clauses := []interface{}{ElasticFilter(q.Filter)}
+	if !q.From.IsZero() || !q.To.IsZero() {
+		bounds := map[string]interface{}{}
+		if !q.From.IsZero() {
+			bounds["gte"] = q.From.Format(time.RFC3339Nano)
+		}
+		if !q.To.IsZero() {
+			bounds["lt"] = q.To.Format(time.RFC3339Nano)
+		}
+		clauses = append(clauses, map[string]interface{}{"range": map[string]interface{}{FieldTimestamp: bounds}})
+	}
+	return map[string]interface{}{"bool": map[string]interface{}{"filter": clauses}}
+}
+
+// ElasticSearchBody returns the full search request for a query
+func ElasticSearchBody(q Query) map[string]interface{} {
+	body := map[string]interface{}{
+		"query":            ElasticQuery(q),
+		"from":             q.Offset,
+		"size":             q.Size,
+		"track_total_hits": true,
+	}
+	// Dates sort as epoch milliseconds
+	if q.After != nil {
+		body["search_after"] = []interface{}{q.After.Time.UnixMilli(), q.After.ID}
+	}
+	switch q.Sort {
+	case SortNewest:
+		body["sort"] = []interface{}{
+			map[string]interface{}{FieldTimestamp: map[string]interface{}{"order": "desc", "unmapped_type": "date"}},
+			map[string]interface{}{FieldEventID: map[string]interface{}{"order": "desc", "unmapped_type": "keyword"}},
+		}
+	case SortOldest:
+		body["sort"] = []interface{}{
+			map[string]interface{}{FieldTimestamp: map[string]interface{}{"order": "asc", "unmapped_type": "date"}},
+			map[string]interface{}{FieldEventID: map[string]interface{}{"order": "asc", "unmapped_type": "keyword"}},
+		}
+	}
+	return body
+}
+
+// search runs a search request and decodes the response
+func (es *ElasticStore) search(body map[string]interface{}, result interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	options := []func(*esapi.SearchRequest){es.Client.Search.WithBody(bytes.NewReader(data))}
+	// A point in time already says which index to search
+	if _, ok := body["pit"]; !ok {
+		options = append(options, es.Client.Search.WithIndex(es.Index))
+	}
+	res, err := es.Client.Search(options...)
+	if err != nil {
+		return fmt.Errorf("failed to search: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("search failed: %s: %s", res.Status(), readBody(res))
+	}
+	if err := json.NewDecoder(res.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to decode search result: %w", err)
+	}
+	return nil
+}
+
+// Query runs a search
+func (es *ElasticStore) Query(q Query) (*Result, error) {
+	var response struct {
+		Hits struct {
+			Total struct {
+				Value int `json:"value"`
+			} `json:"total"`
+			Hits []struct {
+				ID     string   `json:"_id"`
+				Source Document `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := es.search(ElasticSearchBody(q), &response); err != nil {
+		return nil, err
+	}
+
+	result := &Result{Total: response.Hits.Total.Value}
+	for _, hit := range response.Hits.Hits {
+		result.Hits = append(result.Hits, Hit{ID: hit.ID, Doc: hit.Source})
+	}
+	return result, nil
+}
+
+// pitKeepAlive is how long a point in time is kept between two pages of a scan
+const pitKeepAlive = "2m"
+
+// Scan pages through the matches with a point in time and search_after: every page
+// sees the index as it was when the scan started, and there is no limit on how deep
+// it goes, unlike from and size
+func (es *ElasticStore) Scan(q Query, fn func(Hit) error) error {
+	res, err := es.Client.OpenPointInTime([]string{es.Index}, pitKeepAlive)
+	if err != nil {
+		return fmt.Errorf("failed to open point in time: %w", err)
+	}
+	var pit struct {
+		ID string `json:"id"`
+	}
+	if res.IsError() {
+		defer res.Body.Close()
+		return fmt.Errorf("failed to open point in time: %s: %s", res.Status(), readBody(res))
+	}
+	err = json.NewDecoder(res.Body).Decode(&pit)
+	res.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to decode point in time: %w", err)
+	}
+	defer func() {
+		data, _ := json.Marshal(map[string]string{"id": pit.ID})
+		if res, err := es.Client.ClosePointInTime(es.Client.ClosePointInTime.WithBody(bytes.NewReader(data))); err == nil {
+			res.Body.Close()
+		}
+	}()
+
+	body := ElasticSearchBody(q)
+	delete(body, "from")
+	body["track_total_hits"] = false
+	if q.Sort == SortNone {
+		body["sort"] = []interface{}{"_shard_doc"}
+	}
+	skip, passed := q.Offset, 0
+	for {
+		body["size"] = scanPageSize
+		body["pit"] = map[string]interface{}{"id": pit.ID, "keep_alive": pitKeepAlive}
+		var response struct {
+			PitID string `json:"pit_id"`
+			Hits  struct {
+				Hits []struct {
+					ID     string            `json:"_id"`
+					Source Document          `json:"_source"`
+					Sort   []json.RawMessage `json:"sort"`
+				} `json:"hits"`
+			} `json:"hits"`
+		}
+		if err := es.search(body, &response); err != nil {
+			return err
+		}
+		// The ID may change from one page to the next
+		if response.PitID != "" {
+			pit.ID = response.PitID
+		}
+
+		hits := response.Hits.Hits
+		for _, hit := range hits {
+			if skip > 0 {
+				skip--
+				continue
+			}
+			if q.Size > 0 && passed >= q.Size {
+				return nil
+			}
+			if err := fn(Hit{ID: hit.ID, Doc: hit.Source}); err != nil {
+				return err
+			}
+			passed++
+		}
+		if len(hits) < scanPageSize || (q.Size > 0 && passed >= q.Size) {
+			return nil
+		}
+		// The sort values of the last hit, including the tiebreaker the point in time adds
+		body["search_after"] = hits[len(hits)-1].Sort
+	}
+}
+
+// Histogram runs a date_histogram aggregation on the event time
+func (es *ElasticStore) Histogram(q Query, interval time.Duration) ([]TimeBucket, error) {
+	if interval < time.Millisecond {
+		return nil, fmt.Errorf("histogram interval must be at least 1ms")
+	}
+	histogram := map[string]interface{}{
+		"field":          FieldTimestamp,
+		"fixed_interval": fmt.Sprintf("%dms", interval.Milliseconds()),
+		"min_doc_count":  0,
+	}
+	bounds := map[string]interface{}{}
+	if !q.From.IsZero() {
+		bounds["min"] = q.From.UnixMilli()
+	}
+	if !q.To.IsZero() {
+		bounds["max"] = q.To.UnixMilli() - 1
+	}
+	if len(bounds) > 0 {
+		histogram["extended_bounds"] = bounds
+	}
+	body := map[string]interface{}{
+		"query": ElasticQuery(q),
+		"size":  0,
+		"aggs": map[string]interface{}{
+			"histogram": map[string]interface{}{"date_histogram": histogram},
+		},
+	}
+	var response struct {
+		Aggregations struct {
+			Histogram struct {
+				Buckets []struct {
+					Key      int64 `json:"key"`
+					DocCount int   `json:"doc_count"`
+				} `json:"buckets"`
+			} `json:"histogram"`
+		} `json:"aggregations"`
+	}
+	if err := es.search(body, &response); err != nil {
+		return nil, err
+	}
+
+	var buckets []TimeBucket
+	for _, bucket := range response.Aggregations.Histogram.Buckets {
+		buckets = append(buckets, TimeBucket{Start: time.UnixMilli(bucket.Key).UTC(), Count: bucket.DocCount})
+	}
+	return buckets, nil
+}
+
+// numberScript emits a field as a double whether it was indexed as a number or, as
+// the logger sends response times, as a keyword holding one
+const numberScript = `
+def field = params.field;
+if (!doc.containsKey(field) || doc[field].size() == 0) {
+	return;
+}
+def value = doc[field].value;
+if (value instanceof String) {
+	try {
+		emit(Double.parseDouble(value.trim()));
+	} catch (NumberFormatException e) {
+	}
+} else {
+	emit(((Number) value).doubleValue());
+}`
+
+// Distribution runs stats and percentiles aggregations on a runtime field parsing the
+// values of field
+func (es *ElasticStore) Distribution(q Query, field string, percents []float64) (Distribution, error) {
+	value := map[string]interface{}{"field": "distribution_value"}
+	aggs := map[string]interface{}{"stats": map[string]interface{}{"stats": value}}
+	if len(percents) > 0 {
+		aggs["percentiles"] = map[string]interface{}{
+			"percentiles": map[string]interface{}{"field": "distribution_value", "percents": percents, "keyed": false},
+		}
+	}
+	body := map[string]interface{}{
+		"query": ElasticQuery(q),
+		"size":  0,
+		"runtime_mappings": map[string]interface{}{
+			"distribution_value": map[string]interface{}{
+				"type":   "double",
+				"script": map[string]interface{}{"source": numberScript, "params": map[string]interface{}{"field": field}},
+			},
+		},
+		"aggs": aggs,
+	}
+	var response struct {
+		Aggregations struct {
+			Stats struct {
+				Count int      `json:"count"`
+				Min   *float64 `json:"min"`
+				Max   *float64 `json:"max"`
+				Avg   *float64 `json:"avg"`
+			} `json:"stats"`
+			Percentiles struct {
+				Values []struct {
+					Value *float64 `json:"value"`
+				} `json:"values"`
+			} `json:"percentiles"`
+		} `json:"aggregations"`
+	}
+	if err := es.search(body, &response); err != nil {
+		return Distribution{}, err
+	}
+
+	stats := response.Aggregations.Stats
+	dist := Distribution{Count: stats.Count}
+	if stats.Count == 0 {
+		return dist, nil
+	}
+	dist.Min, dist.Max, dist.Avg = *stats.Min, *stats.Max, *stats.Avg
+	for _, percentile := range response.Aggregations.Percentiles.Values {
+		if percentile.Value != nil {
+			dist.Percentiles = append(dist.Percentiles, *percentile.Value)
+		}
+	}
+	return dist, nil
+}
+
+// Aggregate runs a terms aggregation
+func (es *ElasticStore) Aggregate(q Query, field string, size int) ([]Bucket, error) {
+	body := map[string]interface{}{
+		"query": ElasticQuery(q),
+		"size":  0,
+		"aggs": map[string]interface{}{
+			"groups": map[string]interface{}{"terms": map[string]interface{}{"field": keywordField(field), "size": size}},
+		},
+	}
+	var response struct {
+		Aggregations struct {
+			Groups struct {
+				Buckets []struct {
+					Key      interface{} `json:"key"`
+					DocCount int         `json:"doc_count"`
+				} `json:"buckets"`
+			} `json:"groups"`
+		} `json:"aggregations"`
+	}
+	if err := es.search(body, &response); err != nil {
+		return nil, err
+	}
+
+	var buckets []Bucket
+	for _, bucket := range response.Aggregations.Groups.Buckets {
+		buckets = append(buckets, Bucket{Key: FormatValue(bucket.Key), Count: bucket.DocCount})
+	}
+	return buckets, nil
+}
+
+// Delete runs a delete by query and refreshes the index, so the documents are gone
+// from searches when it returns
+func (es *ElasticStore) Delete(q Query) (int, error) {
+	data, err := json.Marshal(map[string]interface{}{"query": ElasticQuery(q)})
+	if err != nil {
+		return 0, err
+	}
+	res, err := es.Client.DeleteByQuery(
+		[]string{es.Index},
+		bytes.NewReader(data),
+		es.Client.DeleteByQuery.WithConflicts("proceed"),
+		es.Client.DeleteByQuery.WithRefresh(true),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return 0, fmt.Errorf("delete failed: %s: %s", res.Status(), readBody(res))
+	}
+	var response struct {
+		Deleted  int           `json:"deleted"`
+		Failures []interface{} `json:"failures"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return 0, fmt.Errorf("failed to decode delete result: %w", err)
+	}
+	if len(response.Failures) > 0 {
+		return response.Deleted, fmt.Errorf("%d documents could not be deleted", len(response.Failures))
+	}
+	return response.Deleted, nil
+}
+
+// Ping checks that the cluster answers
+func (es *ElasticStore) Ping() error {
+	res, err := es.Client.Ping()
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("ping failed: %s", res.Status())
+	}
+	return nil
+}
+
+// Close does nothing, the client has no resources to release
+func (es *ElasticStore) Close() error {
+	return nil
+}
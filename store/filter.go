@@ -0,0 +1,308 @@
+package store
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Filter decides whether a document matches; backends may also translate it to their
+// own query language
+type Filter interface {
+	Match(doc Document) bool
+}
+
+// Term matches documents whose field equals one of the values
+type Term struct {
+	Field  string
+	Values []string
+}
+
+// Contains matches documents whose field contains the text, ignoring case
+type Contains struct {
+	Field string
+	Text  string
+}
+
+// Text is a full-text search: it matches documents whose field holds every word of
+// the query, ignoring case and punctuation
+type Text struct {
+	Field string
+	Query string
+}
+
+// Regexp matches documents whose field contains a match of the pattern; Elasticsearch
+// matches it against the keyword version of the field, with Lucene's syntax
+type Regexp struct {
+	Field   string
+	Pattern *regexp.Regexp
+}
+
+// Wildcard matches documents whose whole field matches the pattern, where * stands for
+// any text and ? for one character; a backslash escapes them
+type Wildcard struct {
+	Field   string
+	Pattern string
+}
+
+// Range matches documents whose field compares to Value with Op: >, >=, < or <=.
+// Value is a time.Time, compared with the field as a timestamp, or a float64, compared
+// with the field as a number even when it's sent as a string.
+type Range struct {
+	Field string
+	Op    string
+	Value interface{}
+}
+
+// IDs matches the documents with one of these IDs, as returned in Hit.ID: the document
+// _id in Elasticsearch, which logs indexed without an event_id also have, and the
+// event_id in the local store
+type IDs []string
+
+// And matches documents that match every filter
+type And []Filter
+
+// Or matches documents that match at least one filter
+type Or []Filter
+
+// Not matches documents that don't match the filter
+type Not struct {
+	Filter Filter
+}
+
+func (f Term) Match(doc Document) bool {
+	value := doc.String(f.Field)
+	for _, wanted := range f.Values {
+		if value == wanted {
+			return true
+		}
+	}
+	return false
+}
+
+func (f Contains) Match(doc Document) bool {
+	return strings.Contains(strings.ToLower(doc.String(f.Field)), strings.ToLower(f.Text))
+}
+
+// Words splits text into lower-case words the way a standard analyzer roughly does;
+// Text filters match these words
+func Words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func (f Text) Match(doc Document) bool {
+	have := make(map[string]bool)
+	for _, word := range Words(doc.String(f.Field)) {
+		have[word] = true
+	}
+	for _, word := range Words(f.Query) {
+		if !have[word] {
+			return false
+		}
+	}
+	return true
+}
+
+func (f Regexp) Match(doc Document) bool {
+	return f.Pattern.MatchString(doc.String(f.Field))
+}
+
+func (f Wildcard) Match(doc Document) bool {
+	if _, ok := doc.Lookup(f.Field); !ok {
+		return false
+	}
+	return wildcardMatch([]rune(f.Pattern), []rune(doc.String(f.Field)))
+}
+
+// wildcardMatch matches text against a whole wildcard pattern
+func wildcardMatch(pattern []rune, text []rune) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := 0; i <= len(text); i++ {
+				if wildcardMatch(pattern[1:], text[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(text) == 0 {
+				return false
+			}
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(text) == 0 || text[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, text = pattern[1:], text[1:]
+	}
+	return len(text) == 0
+}
+
+func (f Range) Match(doc Document) bool {
+	var cmp int
+	switch bound := f.Value.(type) {
+	case time.Time:
+		var t time.Time
+		var ok bool
+		if f.Field == FieldTimestamp {
+			t, ok = EventTime(doc)
+		} else {
+			var err error
+			t, err = ParseTimestamp(doc.String(f.Field))
+			ok = err == nil
+		}
+		if !ok {
+			return false
+		}
+		cmp = t.Compare(bound)
+	case float64:
+		value, ok := doc.Lookup(f.Field)
+		if !ok {
+			return false
+		}
+		number, ok := NumberValue(value)
+		if !ok {
+			return false
+		}
+		switch {
+		case number < bound:
+			cmp = -1
+		case number > bound:
+			cmp = 1
+		}
+	default:
+		return false
+	}
+	switch f.Op {
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
+func (f And) Match(doc Document) bool {
+	for _, filter := range f {
+		if !filter.Match(doc) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f Or) Match(doc Document) bool {
+	for _, filter := range f {
+		if filter.Match(doc) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f IDs) Match(doc Document) bool {
+	id := doc.String(FieldEventID)
+	for _, wanted := range f {
+		if id == wanted {
+			return true
+		}
+	}
+	return false
+}
+
+func (f Not) Match(doc Document) bool {
+	return !f.Filter.Match(doc)
+}
+
+// The String methods render filters in a Lucene-like syntax, to show what a query does
+
+func (f Term) String() string {
+	values := make([]string, len(f.Values))
+	for i, value := range f.Values {
+		values[i] = strconv.Quote(value)
+	}
+	if len(values) == 1 {
+		return f.Field + ":" + values[0]
+	}
+	return f.Field + ":(" + strings.Join(values, " OR ") + ")"
+}
+
+func (f Contains) String() string {
+	return fmt.Sprintf("%s contains %q", f.Field, f.Text)
+}
+
+func (f Text) String() string {
+	return fmt.Sprintf("%s has words %q", f.Field, f.Query)
+}
+
+func (f Regexp) String() string {
+	return fmt.Sprintf("%s:/%s/", f.Field, f.Pattern)
+}
+
+func (f Wildcard) String() string {
+	return f.Field + ":" + f.Pattern
+}
+
+func (f Range) String() string {
+	if t, ok := f.Value.(time.Time); ok {
+		return f.Field + f.Op + t.Format(time.RFC3339Nano)
+	}
+	return f.Field + f.Op + FormatValue(f.Value)
+}
+
+func (f IDs) String() string {
+	return Term{Field: "_id", Values: f}.String()
+}
+
+func (f And) String() string {
+	return joinFilters(f, " AND ")
+}
+
+func (f Or) String() string {
+	return joinFilters(f, " OR ")
+}
+
+func (f Not) String() string {
+	return "NOT " + filterString(f.Filter)
+}
+
+// filterString renders a filter, in parentheses when it combines others
+func filterString(f Filter) string {
+	switch filter := f.(type) {
+	case And:
+		if len(filter) > 1 {
+			return "(" + filter.String() + ")"
+		}
+	case Or:
+		if len(filter) > 1 {
+			return "(" + filter.String() + ")"
+		}
+	}
+	return fmt.Sprint(f)
+}
+
+func joinFilters(filters []Filter, operator string) string {
+	if len(filters) == 0 {
+		return "*"
+	}
+	parts := make([]string, len(filters))
+	for i, filter := range filters {
+		parts[i] = filterString(filter)
+	}
+	return strings.Join(parts, operator)
+}
@@ -0,0 +1,17 @@
+module example.com/store
+
+go 1.23.2
+
+require (
+	github.com/elastic/go-elasticsearch/v8 v8.16.0
+	github.com/google/uuid v1.6.0
+)
+
+require (
+	github.com/elastic/elastic-transport-go/v8 v8.6.0 // indirect
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	go.opentelemetry.io/otel v1.28.0 // indirect
+	go.opentelemetry.io/otel/metric v1.28.0 // indirect
+	go.opentelemetry.io/otel/trace v1.28.0 // indirect
+)
@@ -0,0 +1,825 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// indexedFields have an inverted index in the local store
+var indexedFields = []string{"service_name", "log_level", "node_id", "message_type"}
+
+// LocalConfig says where the local store keeps its files. A read-only store never
+// creates, repairs or writes files, so it can read a directory another process writes to.
+type LocalConfig struct {
+	Dir         string `json:"dir"`
+	SegmentSize int64  `json:"segment_size"`
+	ReadOnly    bool   `json:"-"`
+}
+
+// entry locates one document in the segment files
+type entry struct {
+	ID      string `json:"id"`
+	Time    int64  `json:"t"`
+	Segment int    `json:"s"`
+	Offset  int64  `json:"o"`
+	Length  int    `json:"l"`
+	// Values of the indexed fields, in the order of indexedFields
+	Keys []string `json:"k"`
+}
+
+// LocalStore keeps logs in append-only NDJSON segment files in a directory, with an
+// in-memory time index and inverted index on service, level, node and message type.
+// Sealed segments get a sidecar .idx file so reopening doesn't reparse them, and
+// deleted documents are listed in a tombstone file until their segment is rewritten.
+type LocalStore struct {
+	mu          sync.RWMutex
+	dir         string
+	segmentSize int64
+	readOnly    bool
+	segments    []*os.File // by segment number
+	active      *os.File
+	activeSize  int64
+	entries     []entry            // in append order; replaced and deleted documents stay, marked deleted
+	deleted     map[int]bool       // positions replaced by a newer version or deleted
+	removed     []int              // positions deleted with Delete, kept in the tombstone file
+	byID        map[string]int     // event_id -> position
+	byTime      []int              // positions sorted by event time
+	inverted    []map[string][]int // per indexed field: value -> positions
+	timeSorted  bool
+}
+
+// NewLocalStore opens, or creates, a local store in cfg.Dir; a read-only store of a
+// missing or empty directory is empty until another process writes to it
+func NewLocalStore(cfg LocalConfig) (*LocalStore, error) {
+	if cfg.SegmentSize <= 0 {
+		cfg.SegmentSize = 64 << 20
+	}
+	if !cfg.ReadOnly {
+		if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", cfg.Dir, err)
+		}
+	}
+
+	ls := &LocalStore{
+		dir:         cfg.Dir,
+		segmentSize: cfg.SegmentSize,
+		readOnly:    cfg.ReadOnly,
+		deleted:     make(map[int]bool),
+		byID:        make(map[string]int),
+		inverted:    make([]map[string][]int, len(indexedFields)),
+		timeSorted:  true,
+	}
+	for i := range ls.inverted {
+		ls.inverted[i] = make(map[string][]int)
+	}
+
+	names, err := filepath.Glob(filepath.Join(cfg.Dir, "segment-*.ndjson"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	for number, name := range names {
+		if name != ls.segmentPath(number) {
+			return nil, fmt.Errorf("unexpected segment file %s", name)
+		}
+		last := number == len(names)-1
+		if err := ls.loadSegment(number, last); err != nil {
+			ls.Close()
+			return nil, err
+		}
+	}
+	if ls.active == nil && !ls.readOnly {
+		if err := ls.openSegment(len(ls.segments)); err != nil {
+			return nil, err
+		}
+	}
+
+	// Positions are stable across reopens, segments being loaded in the same order
+	data, err := os.ReadFile(ls.tombstonePath())
+	if err != nil && !os.IsNotExist(err) {
+		ls.Close()
+		return nil, fmt.Errorf("failed to read tombstones: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &ls.removed); err != nil {
+			ls.Close()
+			return nil, fmt.Errorf("failed to parse tombstones: %w", err)
+		}
+		for _, position := range ls.removed {
+			ls.remove(position)
+		}
+	}
+	return ls, nil
+}
+
+func (ls *LocalStore) segmentPath(number int) string {
+	return filepath.Join(ls.dir, fmt.Sprintf("segment-%06d.ndjson", number))
+}
+
+func (ls *LocalStore) indexPath(number int) string {
+	return filepath.Join(ls.dir, fmt.Sprintf("segment-%06d.idx", number))
+}
+
+func (ls *LocalStore) tombstonePath() string {
+	return filepath.Join(ls.dir, "tombstones.json")
+}
+
+// loadSegment indexes an existing segment, from its .idx file when it has one
+func (ls *LocalStore) loadSegment(number int, last bool) error {
+	flags := os.O_RDONLY
+	if last && !ls.readOnly {
+		flags = os.O_RDWR | os.O_APPEND
+	}
+	file, err := os.OpenFile(ls.segmentPath(number), flags, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open segment: %w", err)
+	}
+	ls.segments = append(ls.segments, file)
+
+	if entries, err := readIndex(ls.indexPath(number)); err == nil && !last {
+		for _, e := range entries {
+			ls.add(e)
+		}
+		return nil
+	}
+
+	// Scan the segment; the writer cuts off a torn last line from a crash, while a
+	// read-only store leaves it, as it may be a line the writer is still writing
+	reader := bufio.NewReaderSize(file, 1<<20)
+	var offset int64
+	var entries []entry
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			if len(line) > 0 && last && !ls.readOnly {
+				if err := file.Truncate(offset); err != nil {
+					return fmt.Errorf("failed to repair segment: %w", err)
+				}
+			}
+			break
+		}
+		var doc Document
+		if json.Unmarshal(line, &doc) == nil {
+			e := newEntry(doc, number, offset, len(line))
+			entries = append(entries, e)
+			ls.add(e)
+		}
+		offset += int64(len(line))
+	}
+
+	if last {
+		ls.active = file
+		ls.activeSize = offset
+	} else if !ls.readOnly {
+		writeIndex(ls.indexPath(number), entries)
+	}
+	return nil
+}
+
+// openSegment creates a new empty segment and makes it the active one
+func (ls *LocalStore) openSegment(number int) error {
+	file, err := os.OpenFile(ls.segmentPath(number), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create segment: %w", err)
+	}
+	ls.segments = append(ls.segments, file)
+	ls.active = file
+	ls.activeSize = 0
+	return nil
+}
+
+// seal writes the index of the active segment and starts a new one
+func (ls *LocalStore) seal() error {
+	number := len(ls.segments) - 1
+	var entries []entry
+	for _, e := range ls.entries {
+		if e.Segment == number {
+			entries = append(entries, e)
+		}
+	}
+	if err := ls.active.Sync(); err != nil {
+		return err
+	}
+	if err := writeIndex(ls.indexPath(number), entries); err != nil {
+		return err
+	}
+	return ls.openSegment(number + 1)
+}
+
+func readIndex(path string) ([]entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []entry
+	err = json.Unmarshal(data, &entries)
+	return entries, err
+}
+
+func writeIndex(path string, entries []entry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// newEntry builds the index entry of a document
+func newEntry(doc Document, segment int, offset int64, length int) entry {
+	e := entry{ID: doc.String(FieldEventID), Segment: segment, Offset: offset, Length: length}
+	if t, ok := EventTime(doc); ok {
+		e.Time = t.UnixNano()
+	}
+	e.Keys = make([]string, len(indexedFields))
+	for i, field := range indexedFields {
+		e.Keys[i] = doc.String(field)
+	}
+	return e
+}
+
+// add indexes an entry; callers hold the write lock
+func (ls *LocalStore) add(e entry) {
+	position := len(ls.entries)
+	ls.entries = append(ls.entries, e)
+
+	if e.ID != "" {
+		if previous, ok := ls.byID[e.ID]; ok {
+			ls.deleted[previous] = true
+		}
+		ls.byID[e.ID] = position
+	}
+	for i, key := range e.Keys {
+		ls.inverted[i][key] = append(ls.inverted[i][key], position)
+	}
+
+	if n := len(ls.byTime); n > 0 && entryLess(e, ls.entries[ls.byTime[n-1]]) {
+		ls.timeSorted = false
+	}
+	ls.byTime = append(ls.byTime, position)
+}
+
+// errReadOnly is returned when writing to a read-only store
+var errReadOnly = errors.New("local store is read-only")
+
+// Append writes documents to the active segment, giving an event_id to those without one
+func (ls *LocalStore) Append(docs ...Document) error {
+	if ls.readOnly {
+		return errReadOnly
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	for _, doc := range docs {
+		if doc.String(FieldEventID) == "" {
+			doc[FieldEventID] = uuid.NewString()
+		}
+		data, err := json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+
+		if ls.activeSize > 0 && ls.activeSize+int64(len(data)) > ls.segmentSize {
+			if err := ls.seal(); err != nil {
+				return fmt.Errorf("failed to roll segment: %w", err)
+			}
+		}
+		if _, err := ls.active.Write(data); err != nil {
+			return fmt.Errorf("failed to append: %w", err)
+		}
+		ls.add(newEntry(doc, len(ls.segments)-1, ls.activeSize, len(data)))
+		ls.activeSize += int64(len(data))
+	}
+	return nil
+}
+
+// read loads the document at a position
+func (ls *LocalStore) read(position int) (Document, error) {
+	e := ls.entries[position]
+	data := make([]byte, e.Length)
+	if _, err := ls.segments[e.Segment].ReadAt(data, e.Offset); err != nil {
+		return nil, fmt.Errorf("failed to read segment %d: %w", e.Segment, err)
+	}
+	var doc Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// candidates narrows a filter down to the positions the inverted index allows; ok is
+// false when the index can't help and every document has to be checked
+func (ls *LocalStore) candidates(f Filter) (map[int]bool, bool) {
+	switch filter := f.(type) {
+	case Term:
+		for i, field := range indexedFields {
+			if field != filter.Field {
+				continue
+			}
+			positions := make(map[int]bool)
+			for _, value := range filter.Values {
+				for _, position := range ls.inverted[i][value] {
+					positions[position] = true
+				}
+			}
+			return positions, true
+		}
+	case IDs:
+		positions := make(map[int]bool)
+		for _, id := range filter {
+			if position, ok := ls.byID[id]; ok {
+				positions[position] = true
+			}
+		}
+		return positions, true
+	case And:
+		var result map[int]bool
+		for _, child := range filter {
+			positions, ok := ls.candidates(child)
+			if !ok {
+				continue
+			}
+			if result == nil {
+				result = positions
+				continue
+			}
+			for position := range result {
+				if !positions[position] {
+					delete(result, position)
+				}
+			}
+		}
+		return result, result != nil
+	case Or:
+		result := make(map[int]bool)
+		for _, child := range filter {
+			positions, ok := ls.candidates(child)
+			if !ok {
+				return nil, false
+			}
+			for position := range positions {
+				result[position] = true
+			}
+		}
+		return result, true
+	}
+	return nil, false
+}
+
+// exact reports whether the inverted index answers f on its own, so documents don't
+// have to be read to match it
+func exact(f Filter) bool {
+	switch filter := f.(type) {
+	case nil, IDs:
+		return true
+	case Term:
+		for _, field := range indexedFields {
+			if field == filter.Field {
+				return true
+			}
+		}
+	case And:
+		for _, child := range filter {
+			if !exact(child) {
+				return false
+			}
+		}
+		return true
+	case Or:
+		for _, child := range filter {
+			if !exact(child) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// entryLess orders entries by event time, then by event_id like the Elasticsearch store
+func entryLess(a, b entry) bool {
+	if a.Time != b.Time {
+		return a.Time < b.Time
+	}
+	return a.ID < b.ID
+}
+
+// ensureTimeOrder sorts the time index after out of order appends; callers hold the write lock
+func (ls *LocalStore) ensureTimeOrder() {
+	if ls.timeSorted {
+		return
+	}
+	sort.Slice(ls.byTime, func(i, j int) bool {
+		return entryLess(ls.entries[ls.byTime[i]], ls.entries[ls.byTime[j]])
+	})
+	ls.timeSorted = true
+}
+
+// matches walks the documents matching q in the requested order, stopping when fn returns
+// false. Without load, fn gets a nil document when the index alone answers the filter.
+func (ls *LocalStore) matches(q Query, load bool, fn func(position int, doc Document) bool) error {
+	ls.mu.Lock()
+	ls.ensureTimeOrder()
+	ls.mu.Unlock()
+
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	allowed, indexed := ls.candidates(q.Filter)
+	load = load || !exact(q.Filter)
+
+	var from, to int64
+	if !q.From.IsZero() {
+		from = q.From.UnixNano()
+	}
+	if !q.To.IsZero() {
+		to = q.To.UnixNano()
+	}
+
+	order := ls.byTime
+	if q.Sort == SortNone {
+		order = make([]int, len(ls.entries))
+		for i := range order {
+			order[i] = i
+		}
+	}
+	// Binary search the time range when walking in time order
+	start, end := 0, len(order)
+	if q.Sort != SortNone {
+		if from != 0 {
+			start = sort.Search(len(order), func(i int) bool { return ls.entries[order[i]].Time >= from })
+		}
+		if to != 0 {
+			end = sort.Search(len(order), func(i int) bool { return ls.entries[order[i]].Time >= to })
+		}
+		if q.After != nil {
+			after := entry{ID: q.After.ID}
+			if !q.After.Time.IsZero() {
+				after.Time = q.After.Time.UnixNano()
+			}
+			if q.Sort == SortOldest {
+				start = max(start, sort.Search(len(order), func(i int) bool { return entryLess(after, ls.entries[order[i]]) }))
+			} else {
+				end = min(end, sort.Search(len(order), func(i int) bool { return !entryLess(ls.entries[order[i]], after) }))
+			}
+		}
+	}
+
+	for i := start; i < end; i++ {
+		index := i
+		if q.Sort == SortNewest {
+			index = end - 1 - (i - start)
+		}
+		position := order[index]
+		e := ls.entries[position]
+		if ls.deleted[position] || (indexed && !allowed[position]) {
+			continue
+		}
+		if (from != 0 && e.Time < from) || (to != 0 && e.Time >= to) {
+			continue
+		}
+		var doc Document
+		if load {
+			var err error
+			if doc, err = ls.read(position); err != nil {
+				return err
+			}
+			if q.Filter != nil && !q.Filter.Match(doc) {
+				continue
+			}
+		}
+		if !fn(position, doc) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Query returns the matching documents; counting them only reads the documents a filter
+// the index can't answer needs
+func (ls *LocalStore) Query(q Query) (*Result, error) {
+	result := &Result{}
+	var readErr error
+	err := ls.matches(q, false, func(position int, doc Document) bool {
+		if result.Total >= q.Offset && len(result.Hits) < q.Size {
+			if doc == nil {
+				if doc, readErr = ls.read(position); readErr != nil {
+					return false
+				}
+			}
+			result.Hits = append(result.Hits, Hit{ID: doc.String(FieldEventID), Doc: doc})
+		}
+		result.Total++
+		return true
+	})
+	if err == nil {
+		err = readErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Scan walks the matches a page at a time, each page continuing after the last document
+// of the previous one, so appends aren't held up for the whole scan
+func (ls *LocalStore) Scan(q Query, fn func(Hit) error) error {
+	if q.Sort == SortNone {
+		q.Sort = SortOldest
+	}
+	skip, passed := q.Offset, 0
+	for {
+		var page []Hit
+		err := ls.matches(q, true, func(position int, doc Document) bool {
+			page = append(page, Hit{ID: doc.String(FieldEventID), Doc: doc})
+			return len(page) < scanPageSize
+		})
+		if err != nil {
+			return err
+		}
+		for _, hit := range page {
+			if skip > 0 {
+				skip--
+				continue
+			}
+			if q.Size > 0 && passed >= q.Size {
+				return nil
+			}
+			if err := fn(hit); err != nil {
+				return err
+			}
+			passed++
+		}
+		if len(page) < scanPageSize {
+			return nil
+		}
+		after := PositionOf(page[len(page)-1])
+		q.After = &after
+	}
+}
+
+// Histogram counts the matching documents per interval of event time
+func (ls *LocalStore) Histogram(q Query, interval time.Duration) ([]TimeBucket, error) {
+	if interval < time.Millisecond {
+		return nil, fmt.Errorf("histogram interval must be at least 1ms")
+	}
+	counts := make(map[time.Time]int)
+	var first, last time.Time
+	err := ls.matches(q, true, func(position int, doc Document) bool {
+		t, ok := EventTime(doc)
+		if !ok {
+			return true
+		}
+		start := histogramStart(t, interval)
+		counts[start]++
+		if first.IsZero() || start.Before(first) {
+			first = start
+		}
+		if start.After(last) {
+			last = start
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !q.From.IsZero() {
+		first = histogramStart(q.From, interval)
+	}
+	if !q.To.IsZero() {
+		last = histogramStart(q.To.Add(-time.Millisecond), interval)
+	}
+	if first.IsZero() || last.IsZero() {
+		return nil, nil
+	}
+	var buckets []TimeBucket
+	for start := first; !start.After(last); start = start.Add(interval) {
+		buckets = append(buckets, TimeBucket{Start: start, Count: counts[start]})
+	}
+	return buckets, nil
+}
+
+// Distribution summarizes the numeric values of field in the matching documents;
+// percentiles interpolate between the two closest values
+func (ls *LocalStore) Distribution(q Query, field string, percents []float64) (Distribution, error) {
+	var values []float64
+	err := ls.matches(q, true, func(position int, doc Document) bool {
+		if value, ok := doc.Lookup(field); ok {
+			if number, ok := NumberValue(value); ok {
+				values = append(values, number)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return Distribution{}, err
+	}
+
+	dist := Distribution{Count: len(values)}
+	if len(values) == 0 {
+		return dist, nil
+	}
+	sort.Float64s(values)
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+	}
+	dist.Min, dist.Max, dist.Avg = values[0], values[len(values)-1], sum/float64(len(values))
+	for _, percent := range percents {
+		rank := percent / 100 * float64(len(values)-1)
+		lower := int(math.Floor(rank))
+		upper := int(math.Ceil(rank))
+		dist.Percentiles = append(dist.Percentiles, values[lower]+(values[upper]-values[lower])*(rank-float64(lower)))
+	}
+	return dist, nil
+}
+
+// Aggregate counts the matching documents by the values of field
+func (ls *LocalStore) Aggregate(q Query, field string, size int) ([]Bucket, error) {
+	counts := make(map[string]int)
+	err := ls.matches(q, true, func(position int, doc Document) bool {
+		if value, ok := doc.Lookup(field); ok {
+			counts[FormatValue(value)]++
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make([]Bucket, 0, len(counts))
+	for key, count := range counts {
+		buckets = append(buckets, Bucket{Key: key, Count: count})
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		if buckets[i].Count != buckets[j].Count {
+			return buckets[i].Count > buckets[j].Count
+		}
+		return strings.Compare(buckets[i].Key, buckets[j].Key) < 0
+	})
+	if size > 0 && len(buckets) > size {
+		buckets = buckets[:size]
+	}
+	return buckets, nil
+}
+
+// Refresh indexes what another process, like the server, appended to the store since
+// it was opened or last refreshed, and the documents it deleted. Only complete lines
+// are read; one still being written is picked up by the next refresh.
+func (ls *LocalStore) Refresh() error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	for {
+		if err := ls.scanActive(); err != nil {
+			return err
+		}
+		next := ls.segmentPath(len(ls.segments))
+		if _, err := os.Stat(next); os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return err
+		}
+		// The writer is done with a segment once the next one exists, but may have
+		// appended to it since the scan above
+		if err := ls.scanActive(); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(next, os.O_RDONLY, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to open segment: %w", err)
+		}
+		ls.segments = append(ls.segments, file)
+		ls.active = file
+		ls.activeSize = 0
+	}
+
+	data, err := os.ReadFile(ls.tombstonePath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read tombstones: %w", err)
+	}
+	var removed []int
+	if err := json.Unmarshal(data, &removed); err != nil {
+		return fmt.Errorf("failed to parse tombstones: %w", err)
+	}
+	for _, position := range removed[min(len(ls.removed), len(removed)):] {
+		ls.remove(position)
+	}
+	if len(removed) > len(ls.removed) {
+		ls.removed = removed
+	}
+	return nil
+}
+
+// scanActive indexes the complete lines of the active segment past what is indexed;
+// callers hold the write lock
+func (ls *LocalStore) scanActive() error {
+	if ls.active == nil {
+		// A read-only store opened before the first segment was created
+		return nil
+	}
+	number := len(ls.segments) - 1
+	reader := bufio.NewReaderSize(io.NewSectionReader(ls.active, ls.activeSize, math.MaxInt64-ls.activeSize), 1<<20)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read segment %d: %w", number, err)
+		}
+		var doc Document
+		if json.Unmarshal(line, &doc) == nil {
+			ls.add(newEntry(doc, number, ls.activeSize, len(line)))
+		}
+		ls.activeSize += int64(len(line))
+	}
+}
+
+// remove marks a position deleted; callers hold the write lock
+func (ls *LocalStore) remove(position int) {
+	if position < 0 || position >= len(ls.entries) {
+		return
+	}
+	ls.deleted[position] = true
+	if id := ls.entries[position].ID; ls.byID[id] == position {
+		delete(ls.byID, id)
+	}
+}
+
+// Delete marks the matching documents deleted and records them in the tombstone file;
+// the space they take in the segments is not reclaimed
+func (ls *LocalStore) Delete(q Query) (int, error) {
+	if ls.readOnly {
+		return 0, errReadOnly
+	}
+	var positions []int
+	err := ls.matches(q, true, func(position int, doc Document) bool {
+		positions = append(positions, position)
+		return true
+	})
+	if err != nil || len(positions) == 0 {
+		return 0, err
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	for _, position := range positions {
+		ls.remove(position)
+	}
+	ls.removed = append(ls.removed, positions...)
+	data, err := json.Marshal(ls.removed)
+	if err != nil {
+		return 0, err
+	}
+	tmp := ls.tombstonePath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return 0, fmt.Errorf("failed to write tombstones: %w", err)
+	}
+	if err := os.Rename(tmp, ls.tombstonePath()); err != nil {
+		return 0, fmt.Errorf("failed to write tombstones: %w", err)
+	}
+	return len(positions), nil
+}
+
+// Ping always succeeds, the store is in-process
+func (ls *LocalStore) Ping() error {
+	return nil
+}
+
+// Close flushes the active segment and closes all files
+func (ls *LocalStore) Close() error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	var firstErr error
+	if ls.active != nil && !ls.readOnly {
+		firstErr = ls.active.Sync()
+	}
+	for _, file := range ls.segments {
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	ls.segments = nil
+	ls.active = nil
+	return firstErr
+}
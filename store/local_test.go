@@ -0,0 +1,328 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testDoc builds a log document at base plus offset seconds
+func testDoc(id string, offset int, service string, level string) Document {
+	base := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	return Document{
+		FieldEventID:   id,
+		FieldTimestamp: base.Add(time.Duration(offset) * time.Second).Format(time.RFC3339Nano),
+		"service_name": service,
+		"log_level":    level,
+		"node_id":      float64(offset % 3),
+		"message":      fmt.Sprintf("message %d", offset),
+	}
+}
+
+// openTestStore opens a local store in dir, failing the test on error
+func openTestStore(t *testing.T, cfg LocalConfig) *LocalStore {
+	t.Helper()
+	ls, err := NewLocalStore(cfg)
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	t.Cleanup(func() { ls.Close() })
+	return ls
+}
+
+// hitIDs returns the event IDs of hits, in order
+func hitIDs(hits []Hit) []string {
+	ids := make([]string, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.ID
+	}
+	return ids
+}
+
+func equalIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestLocalStoreQuery(t *testing.T) {
+	ls := openTestStore(t, LocalConfig{Dir: t.TempDir()})
+	// Appended out of time order, "b" replaced by a newer version
+	docs := []Document{
+		testDoc("c", 3, "cache", "ERROR"),
+		testDoc("a", 1, "router", "INFO"),
+		testDoc("b", 2, "cache", "INFO"),
+		testDoc("d", 4, "router", "WARN"),
+		testDoc("b", 2, "cache", "WARN"),
+	}
+	if err := ls.Append(docs...); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	base := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+		total int
+	}{
+		{"newest first", Query{Sort: SortNewest, Size: 10}, []string{"d", "c", "b", "a"}, 4},
+		{"oldest first", Query{Sort: SortOldest, Size: 10}, []string{"a", "b", "c", "d"}, 4},
+		{"size", Query{Sort: SortNewest, Size: 2}, []string{"d", "c"}, 4},
+		{"offset", Query{Sort: SortOldest, Offset: 1, Size: 2}, []string{"b", "c"}, 4},
+		{"indexed term", Query{Filter: Term{Field: "service_name", Values: []string{"cache"}}, Sort: SortOldest, Size: 10}, []string{"b", "c"}, 2},
+		{"replaced version", Query{Filter: Term{Field: "log_level", Values: []string{"INFO"}}, Sort: SortOldest, Size: 10}, []string{"a"}, 1},
+		{"time range", Query{From: base.Add(2 * time.Second), To: base.Add(4 * time.Second), Sort: SortOldest, Size: 10}, []string{"b", "c"}, 2},
+		{"not", Query{Filter: Not{Filter: Term{Field: "service_name", Values: []string{"cache"}}}, Sort: SortOldest, Size: 10}, []string{"a", "d"}, 2},
+		{"after", Query{Sort: SortOldest, After: &Position{Time: base.Add(2 * time.Second), ID: "b"}, Size: 10}, []string{"c", "d"}, 2},
+		{"after newest", Query{Sort: SortNewest, After: &Position{Time: base.Add(3 * time.Second), ID: "c"}, Size: 10}, []string{"b", "a"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ls.Query(tt.query)
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+			if got := hitIDs(result.Hits); !equalIDs(got, tt.want) {
+				t.Errorf("hits = %v, want %v", got, tt.want)
+			}
+			if result.Total != tt.total {
+				t.Errorf("total = %d, want %d", result.Total, tt.total)
+			}
+		})
+	}
+}
+
+func TestLocalStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	// Small segments, so the documents span sealed segments with .idx files
+	cfg := LocalConfig{Dir: dir, SegmentSize: 300}
+	ls, err := NewLocalStore(cfg)
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	var want []string
+	for i := 0; i < 10; i++ {
+		id := fmt.Sprintf("doc-%02d", i)
+		want = append(want, id)
+		if err := ls.Append(testDoc(id, i, "cache", "INFO")); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	if _, err := ls.Delete(Query{Filter: Term{Field: FieldEventID, Values: []string{"doc-03"}}}); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := ls.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if segments, _ := filepath.Glob(filepath.Join(dir, "segment-*.ndjson")); len(segments) < 2 {
+		t.Fatalf("got %d segments, want several", len(segments))
+	}
+
+	reopened := openTestStore(t, cfg)
+	result, err := reopened.Query(Query{Sort: SortOldest, Size: 100})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	want = append(want[:3], want[4:]...)
+	if got := hitIDs(result.Hits); !equalIDs(got, want) {
+		t.Errorf("after reopen got %v, want %v", got, want)
+	}
+	if result.Hits[0].Doc.String("message") != "message 0" {
+		t.Errorf("document not read back: %v", result.Hits[0].Doc)
+	}
+}
+
+func TestLocalStoreDelete(t *testing.T) {
+	ls := openTestStore(t, LocalConfig{Dir: t.TempDir()})
+	for i := 0; i < 6; i++ {
+		service := "cache"
+		if i%2 == 1 {
+			service = "router"
+		}
+		if err := ls.Append(testDoc(fmt.Sprint(i), i, service, "INFO")); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+
+	deleted, err := ls.Delete(Query{Filter: Term{Field: "service_name", Values: []string{"router"}}})
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if deleted != 3 {
+		t.Errorf("deleted %d, want 3", deleted)
+	}
+	if deleted, _ := ls.Delete(Query{Filter: Term{Field: "service_name", Values: []string{"router"}}}); deleted != 0 {
+		t.Errorf("deleted %d again, want 0", deleted)
+	}
+	result, err := ls.Query(Query{Sort: SortOldest, Size: 10})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if got, want := hitIDs(result.Hits), []string{"0", "2", "4"}; !equalIDs(got, want) {
+		t.Errorf("left %v, want %v", got, want)
+	}
+}
+
+func TestLocalStoreScan(t *testing.T) {
+	ls := openTestStore(t, LocalConfig{Dir: t.TempDir()})
+	// More than a page, several documents per timestamp
+	count := scanPageSize*2 + 10
+	for i := 0; i < count; i++ {
+		if err := ls.Append(testDoc(fmt.Sprintf("%05d", i), i/4, "cache", "INFO")); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  int
+		first string
+	}{
+		{"all", Query{}, count, "00000"},
+		{"size", Query{Sort: SortOldest, Size: scanPageSize + 5}, scanPageSize + 5, "00000"},
+		{"offset", Query{Sort: SortOldest, Offset: scanPageSize + 1}, count - scanPageSize - 1, fmt.Sprintf("%05d", scanPageSize+1)},
+		{"newest", Query{Sort: SortNewest}, count, fmt.Sprintf("%05d", count-1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ids []string
+			seen := make(map[string]bool)
+			err := ls.Scan(tt.query, func(hit Hit) error {
+				if seen[hit.ID] {
+					t.Fatalf("%s scanned twice", hit.ID)
+				}
+				seen[hit.ID] = true
+				ids = append(ids, hit.ID)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Scan: %v", err)
+			}
+			if len(ids) != tt.want {
+				t.Errorf("scanned %d, want %d", len(ids), tt.want)
+			}
+			if len(ids) > 0 && ids[0] != tt.first {
+				t.Errorf("first = %s, want %s", ids[0], tt.first)
+			}
+		})
+	}
+}
+
+func TestLocalStoreReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "missing")
+	reader := openTestStore(t, LocalConfig{Dir: empty, ReadOnly: true})
+	if _, err := os.Stat(empty); !os.IsNotExist(err) {
+		t.Errorf("read-only store created its directory")
+	}
+	if err := reader.Append(testDoc("a", 1, "cache", "INFO")); err == nil {
+		t.Errorf("Append on a read-only store succeeded")
+	}
+
+	writer := openTestStore(t, LocalConfig{Dir: dir})
+	if err := writer.Append(testDoc("a", 1, "cache", "INFO")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	// A line the writer is still writing
+	segment := writer.segmentPath(0)
+	torn := []byte(`{"@timestamp":"2026-10-19T09:00:00Z","event_id":"b","message":"half`)
+	if _, err := writer.active.Write(torn); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	reader = openTestStore(t, LocalConfig{Dir: dir, ReadOnly: true})
+	result, err := reader.Query(Query{Sort: SortOldest, Size: 10})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if got := hitIDs(result.Hits); !equalIDs(got, []string{"a"}) {
+		t.Errorf("got %v, want only the complete line", got)
+	}
+	info, err := os.Stat(segment)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	before := info.Size()
+
+	// The writer finishes the line, and the reader picks it up on refresh
+	if _, err := writer.active.Write([]byte(`"}` + "\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := reader.Refresh(); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	result, err = reader.Query(Query{Sort: SortOldest, Size: 10})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if got := hitIDs(result.Hits); !equalIDs(got, []string{"a", "b"}) {
+		t.Errorf("after refresh got %v, want [a b]", got)
+	}
+	if info, _ := os.Stat(segment); info.Size() != before+3 {
+		t.Errorf("segment is %d bytes, want %d: the reader changed it", info.Size(), before+3)
+	}
+	if names, _ := filepath.Glob(filepath.Join(empty, "*")); len(names) != 0 {
+		t.Errorf("read-only store created %v", names)
+	}
+}
+
+func TestLocalStoreCount(t *testing.T) {
+	dir := t.TempDir()
+	ls := openTestStore(t, LocalConfig{Dir: dir})
+	for i := 0; i < 6; i++ {
+		level := "INFO"
+		if i%3 == 0 {
+			level = "ERROR"
+		}
+		if err := ls.Append(testDoc(fmt.Sprint(i), i, "cache", level)); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	// Garbage in place of the documents: only counts the index answers can still work
+	segment := ls.segmentPath(0)
+	info, err := os.Stat(segment)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if err := os.WriteFile(segment, make([]byte, info.Size()), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		total  int
+	}{
+		{"all", nil, 6},
+		{"term", Term{Field: "log_level", Values: []string{"ERROR"}}, 2},
+		{"and", And{Term{Field: "log_level", Values: []string{"INFO"}}, Term{Field: "service_name", Values: []string{"cache"}}}, 4},
+		{"or", Or{Term{Field: "log_level", Values: []string{"ERROR"}}, IDs{"1"}}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ls.Query(Query{Filter: tt.filter})
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+			if result.Total != tt.total {
+				t.Errorf("total = %d, want %d", result.Total, tt.total)
+			}
+		})
+	}
+	if _, err := ls.Query(Query{Filter: Text{Field: "message", Query: "message"}}); err == nil {
+		t.Errorf("count with a filter the index can't answer didn't read the documents")
+	}
+	if _, err := ls.Query(Query{Size: 1}); err == nil {
+		t.Errorf("query returning hits didn't read the documents")
+	}
+}
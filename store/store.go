@@ -0,0 +1,236 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Document is one stored log, as decoded from its JSON form
+type Document map[string]interface{}
+
+// Sort orders query results by event time
+type Sort int
+
+const (
+	SortNone   Sort = iota // whatever order the backend finds cheapest
+	SortNewest             // newest first
+	SortOldest             // oldest first
+)
+
+// Query selects documents from a store
+type Query struct {
+	Filter Filter    // nil matches every document
+	From   time.Time // inclusive lower bound on the event time, zero for none
+	To     time.Time // exclusive upper bound on the event time, zero for none
+	Sort   Sort
+	After  *Position // only documents after this one in the Sort order, which can't be SortNone
+	Offset int       // number of matching documents to skip
+	Size   int       // maximum number of documents to return
+}
+
+// Hit is one document returned by a query
+type Hit struct {
+	ID  string
+	Doc Document
+}
+
+// Position is a place in the event time order: a time, and the event_id breaking ties
+// between documents of the same time
+type Position struct {
+	Time time.Time
+	ID   string
+}
+
+// PositionOf returns the position of a hit, to continue a query after it
+func PositionOf(hit Hit) Position {
+	t, _ := EventTime(hit.Doc)
+	return Position{Time: t, ID: hit.ID}
+}
+
+// Result is the answer to a query
+type Result struct {
+	Total int // number of matching documents, regardless of Offset and Size
+	Hits  []Hit
+}
+
+// Bucket is one group of an aggregation
+type Bucket struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// TimeBucket counts the documents of one interval of a date histogram
+type TimeBucket struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+}
+
+// Distribution summarizes the numeric values of a field
+type Distribution struct {
+	Count       int       `json:"count"` // documents with a numeric value
+	Min         float64   `json:"min"`
+	Max         float64   `json:"max"`
+	Avg         float64   `json:"avg"`
+	Percentiles []float64 `json:"percentiles"` // the values at the requested percents, in order
+}
+
+// LogStore is a place logs can be written to and read back from
+type LogStore interface {
+	// Append stores documents; a document whose event_id is already stored is replaced
+	Append(docs ...Document) error
+	// Query returns the documents matching q
+	Query(q Query) (*Result, error)
+	// Scan calls fn with the documents matching q, in q's order, however many there
+	// are; q.Size limits how many when it isn't 0, and an error from fn stops the scan
+	Scan(q Query, fn func(Hit) error) error
+	// Aggregate counts the documents matching q by the values of field, largest groups first
+	Aggregate(q Query, field string, size int) ([]Bucket, error)
+	// Histogram counts the documents matching q per interval of event time, oldest first;
+	// intervals start at multiples of interval since the Unix epoch, and the empty ones
+	// between q.From and q.To are included
+	Histogram(q Query, interval time.Duration) ([]TimeBucket, error)
+	// Distribution summarizes the numeric values of field in the documents matching q,
+	// numbers sent as strings included; percents range from 0 to 100
+	Distribution(q Query, field string, percents []float64) (Distribution, error)
+	// Delete removes the documents matching q and returns how many it removed
+	Delete(q Query) (int, error)
+	// Ping reports whether the store can be reached
+	Ping() error
+	// Close releases the resources of the store
+	Close() error
+}
+
+// Refresher is implemented by stores that only see what other processes write to them
+// after a refresh
+type Refresher interface {
+	Refresh() error
+}
+
+// Config selects and configures a backend
+type Config struct {
+	Backend       string        `json:"backend"` // "elasticsearch" or "local"
+	Elasticsearch ElasticConfig `json:"elasticsearch"`
+	Local         LocalConfig   `json:"local"`
+}
+
+// Open creates the store described by cfg
+func Open(cfg Config) (LogStore, error) {
+	switch cfg.Backend {
+	case "", "elasticsearch":
+		return NewElasticStore(cfg.Elasticsearch)
+	case "local":
+		return NewLocalStore(cfg.Local)
+	}
+	return nil, fmt.Errorf("unknown store backend %q", cfg.Backend)
+}
+
+// scanPageSize is how many documents a scan reads at once
+const scanPageSize = 1000
+
+// Fields used by every backend
+const (
+	FieldTimestamp = "@timestamp"
+	FieldEventID   = "event_id"
+)
+
+// timestampLayouts are the formats accepted for event times; the first one is what
+// Go's time.Time.String produces, which is what the logger sends
+var timestampLayouts = []string{
+	"2006-01-02 15:04:05.999999999 -0700 MST",
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+}
+
+// ParseTimestamp parses an event time in any of the formats the services produce
+func ParseTimestamp(s string) (time.Time, error) {
+	// Drop the monotonic clock reading of time.Time.String ("... m=+0.001")
+	if i := strings.Index(s, " m="); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.TrimSpace(s)
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized timestamp %q", s)
+}
+
+// EventTime returns the time a document was produced: its @timestamp, else its timestamp
+func EventTime(doc Document) (time.Time, bool) {
+	for _, field := range []string{FieldTimestamp, "timestamp"} {
+		if s, ok := doc[field].(string); ok {
+			if t, err := ParseTimestamp(s); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// NumberValue returns a field value as a number; services send some numbers as strings
+func NumberValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return parsed, err == nil
+	}
+	return 0, false
+}
+
+// histogramStart returns the start of the histogram interval holding t
+func histogramStart(t time.Time, interval time.Duration) time.Time {
+	ms, step := t.UnixMilli(), interval.Milliseconds()
+	start := ms - ms%step
+	if ms%step < 0 {
+		start -= step
+	}
+	return time.UnixMilli(start).UTC()
+}
+
+// Lookup returns the value of a field, following dots into nested objects
+func (doc Document) Lookup(field string) (interface{}, bool) {
+	if value, ok := doc[field]; ok {
+		return value, true
+	}
+	var current interface{} = map[string]interface{}(doc)
+	for _, part := range strings.Split(field, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = object[part]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// String returns a field as a string, formatting numbers without exponent
+func (doc Document) String(field string) string {
+	value, ok := doc.Lookup(field)
+	if !ok {
+		return ""
+	}
+	return FormatValue(value)
+}
+
+// FormatValue formats a JSON value the way filters compare it
+func FormatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}